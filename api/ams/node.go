@@ -223,39 +223,39 @@ type Node struct {
 type NodesPost struct {
 	// Name of the node
 	// Example: lxd0
-	Name string `json:"name"`
+	Name string `json:"name" yaml:"name"`
 	// Internal IP address of the node
 	// Example: 10.0.0.1
 	// swagger:strfmt ipv4
-	Address string `json:"address"`
+	Address string `json:"address" yaml:"address"`
 	// Public IP address of the node
 	// Example: 10.0.0.1
 	// swagger:strfmt ipv4
-	PublicAddress string `json:"public_address"`
+	PublicAddress string `json:"public_address" yaml:"public_address"`
 	// Trust password for the LXD instance
 	// Example: sUp3rs3cr3t
-	TrustPassword string `json:"trust_password"`
+	TrustPassword string `json:"trust_password" yaml:"trust_password"`
 	// MTU for the configured network bridge on LXD
 	// Example: 1500
-	NetworkBridgeMTU int `json:"network_bridge_mtu"`
+	NetworkBridgeMTU int `json:"network_bridge_mtu" yaml:"network_bridge_mtu"`
 	// Storage device to use for configuring LXD storage pools
 	// Example: /dev/sdb
-	StorageDevice string `json:"storage_device"`
+	StorageDevice string `json:"storage_device" yaml:"storage_device"`
 	// Number of CPUs on the node
 	// Example: 4
-	CPUs int `json:"cpus"`
+	CPUs int `json:"cpus" yaml:"cpus"`
 	// CPU allocation rate for the node
 	// Example: 4
-	CPUAllocationRate float32 `json:"cpu_allocation_rate"`
+	CPUAllocationRate float32 `json:"cpu_allocation_rate" yaml:"cpu_allocation_rate"`
 	// Memory (in GB) of the node
 	// Example: 8GB
-	Memory string `json:"memory"`
+	Memory string `json:"memory" yaml:"memory"`
 	// Memory allocation rate for the node
 	// Example: 2
-	MemoryAllocationRate float32 `json:"memory_allocation_rate"`
+	MemoryAllocationRate float32 `json:"memory_allocation_rate" yaml:"memory_allocation_rate"`
 	// Number of GPU slots to configure on the node
 	// Example: 2
-	GPUSlots int `json:"gpu_slots"`
+	GPUSlots int `json:"gpu_slots" yaml:"gpu_slots"`
 	// Number of GPU encoder slots to configure on the node
 	// Example: 4
 	GPUEncoderSlots int `json:"gpu_encoder_slots" yaml:"gpu_encoder_slots"`
@@ -277,7 +277,7 @@ type NodesPost struct {
 	NetworkSubnet string `json:"network_subnet" yaml:"network_subnet"`
 	// Trust token for the LXD instance
 	// Example: csdflkj3lks
-	TrustToken string `json:"trust_token"`
+	TrustToken string `json:"trust_token" yaml:"trust_token"`
 
 	// Name of the network ACL to create on the LXD node
 	// Example: ams0
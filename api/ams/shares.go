@@ -57,3 +57,21 @@ type InstanceSharePatch struct {
 	// The value is a Unix timestamp (UTC) indicating when the share will expire.
 	ExpiryAt *int64 `json:"expiry_at,omitempty" example:"1610645117"`
 }
+
+// InstanceShare describes an active share of an instance
+//
+// swagger:model
+type InstanceShare struct {
+	// ID of the share
+	ID string `json:"id" example:"ctigqirc209urni862a0"`
+	// Type of the share
+	Type string `json:"type" example:"adb"`
+	// Description is the user provided description for the share
+	Description string `json:"description" example:"instance shared with john"`
+	// URL is the endpoint to reach to connect to the share
+	URL string `json:"url,omitempty" example:"https://api.example.com/1.0/sessions/foo/connect?token=bar"`
+	// CreatedAt specifies the time at which the share was created
+	CreatedAt int64 `json:"created_at" example:"1610641517"`
+	// ExpiryAt specifies the exact expiration time for the share
+	ExpiryAt int64 `json:"expiry_at" example:"1610645117"`
+}
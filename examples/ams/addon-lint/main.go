@@ -0,0 +1,49 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+)
+
+func main() {
+	addonPath := flag.String("path", "", "Path to the addon package")
+	flag.Parse()
+
+	if len(*addonPath) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	p, err := packages.NewAddonPackage(*addonPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report := p.Lint()
+	fmt.Println(report)
+	if report.Err() != nil {
+		os.Exit(1)
+	}
+}
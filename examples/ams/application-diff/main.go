@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+)
+
+type appDiffCmd struct {
+	common.ConnectionCmd
+	id         string
+	version    int
+	newPath    string
+	jsonOutput bool
+}
+
+func (command *appDiffCmd) Parse() {
+	flag.StringVar(&command.id, "id", "", "Application id to compare against")
+	flag.IntVar(&command.version, "version", 0, "Application version to compare against")
+	flag.StringVar(&command.newPath, "path", "", "Path to the new package")
+	flag.BoolVar(&command.jsonOutput, "json", false, "Print the diff as JSON")
+
+	command.ConnectionCmd.Parse()
+
+	if len(command.newPath) == 0 || len(command.id) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &appDiffCmd{}
+	cmd.Parse()
+
+	c := cmd.NewClient()
+
+	diff, err := c.DiffApplicationVersion(context.Background(), cmd.id, cmd.version, cmd.newPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cmd.jsonOutput {
+		b, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	fmt.Println(diff)
+}
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+type applicationMigrateCmd struct {
+	common.ConnectionCmd
+	target      common.ConnectionCmd
+	id          string
+	versions    string
+	useRegistry bool
+}
+
+func (command *applicationMigrateCmd) Parse() {
+	flag.StringVar(&command.id, "id", "", "ID of the application to migrate")
+	flag.StringVar(&command.versions, "versions", "", "Comma separated list of versions to migrate. All versions are migrated if not given.")
+	flag.BoolVar(&command.useRegistry, "registry", false, "Migrate through the application registry")
+	flag.StringVar(&command.target.ClientCert, "target-cert", "", "Path to the file with the client certificate to use to connect to the target AMS")
+	flag.StringVar(&command.target.ClientKey, "target-key", "", "Path to the file with the client key to use to connect to the target AMS")
+	flag.StringVar(&command.target.ServiceURL, "target-url", "", "URL of the target AMS server")
+
+	command.ConnectionCmd.Parse()
+
+	if len(command.id) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if err := command.target.Validate(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &applicationMigrateCmd{}
+	cmd.Parse()
+	src := cmd.NewClient()
+	dst := cmd.target.NewClient()
+
+	var versions []int
+	if len(cmd.versions) > 0 {
+		for _, s := range strings.Split(cmd.versions, ",") {
+			v, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				log.Fatalf("Invalid version %q", s)
+			}
+			versions = append(versions, v)
+		}
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	result, err := client.MigrateApplicationWithArgs(ctx, src, dst, cmd.id, versions, &client.ApplicationMigrateArgs{
+		UseRegistry: cmd.useRegistry,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	numbers := make([]int, 0, len(result.Versions))
+	for v := range result.Versions {
+		numbers = append(numbers, v)
+	}
+	sort.Ints(numbers)
+	fmt.Printf("Application migrated as %s\n", result.ID)
+	for _, v := range numbers {
+		fmt.Printf("  version %d -> %d\n", v, result.Versions[v])
+	}
+}
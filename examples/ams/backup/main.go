@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/backup"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/inventory"
+)
+
+type backupCmd struct {
+	common.ConnectionCmd
+	outputPath    string
+	restorePath   string
+	skipPackages  bool
+	imagePackages string
+	addonPackages string
+	skipConfig    string
+}
+
+func (command *backupCmd) Parse() {
+	flag.StringVar(&command.outputPath, "output", "", "Write a backup of the cluster to the given path")
+	flag.StringVar(&command.restorePath, "restore", "", "Restore the backup at the given path into the cluster")
+	flag.BoolVar(&command.skipPackages, "skip-packages", false, "Leave application packages out of the backup")
+	flag.StringVar(&command.imagePackages, "image-packages", "", "Comma separated list of <image>=<path> to upload missing images from when restoring")
+	flag.StringVar(&command.addonPackages, "addon-packages", "", "Comma separated list of <addon>=<path> to upload missing addons from when restoring")
+	flag.StringVar(&command.skipConfig, "skip-config", "", "Comma separated list of config items not to restore")
+
+	command.ConnectionCmd.Parse()
+
+	if (len(command.outputPath) == 0) == (len(command.restorePath) == 0) {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &backupCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if len(cmd.outputPath) > 0 {
+		f, err := os.Create(cmd.outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		m, err := backup.Create(ctx, c, f, &backup.Args{
+			SkipPackages: cmd.skipPackages,
+			Progress: func(application string, version int, err error) {
+				if err == nil {
+					fmt.Printf("Exported version %d of application %s\n", version, application)
+				}
+			},
+		})
+		if err == nil {
+			err = f.Close()
+		} else {
+			f.Close()
+			os.Remove(cmd.outputPath)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Backup with %d application packages written to %s\n", len(m.Packages), cmd.outputPath)
+		return
+	}
+
+	imagePackages, err := parsePackages(cmd.imagePackages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	addonPackages, err := parsePackages(cmd.addonPackages)
+	if err != nil {
+		log.Fatal(err)
+	}
+	args := &backup.RestoreArgs{
+		ImagePackages: imagePackages,
+		AddonPackages: addonPackages,
+		Progress: func(kind inventory.Kind, name string, err error) {
+			if err != nil {
+				fmt.Printf("Failed to restore %s %s: %v\n", kind, name, err)
+			}
+		},
+	}
+	if len(cmd.skipConfig) > 0 {
+		args.SkipConfig = strings.Split(cmd.skipConfig, ",")
+	}
+
+	f, err := os.Open(cmd.restorePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	result, err := backup.Restore(ctx, c, f, args)
+	if result != nil {
+		sort.Strings(result.Restored)
+		for _, name := range result.Restored {
+			fmt.Printf("Restored %s\n", name)
+		}
+		fmt.Printf("%d restored, %d unchanged, %d failed\n", len(result.Restored), len(result.Unchanged), len(result.Failed))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parsePackages(list string) (map[string]string, error) {
+	packages := map[string]string{}
+	if len(list) == 0 {
+		return packages, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		name, path, ok := strings.Cut(item, "=")
+		if !ok || len(name) == 0 || len(path) == 0 {
+			return nil, fmt.Errorf("invalid package %q, expected <name>=<path>", item)
+		}
+		packages[name] = path
+	}
+	return packages, nil
+}
@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+)
+
+type capacityCmd struct {
+	common.ConnectionCmd
+	appID string
+}
+
+func (command *capacityCmd) Parse() {
+	flag.StringVar(&command.appID, "app", "", "Application id to compute the number of instances which still fit for")
+
+	command.ConnectionCmd.Parse()
+}
+
+func main() {
+	cmd := &capacityCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	nodes, err := c.ListNodes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	instances, err := c.ListInstances()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cluster, err := capacity.Compute(nodes, instances)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, n := range cluster.Nodes {
+		fmt.Printf("%s: instances %d, cpus %d/%d, memory %s/%s, disk %s/%s, gpu slots %d/%d, vpu slots %d/%d\n",
+			n.Node, n.Instances,
+			n.Committed.CPUs, n.Total.CPUs,
+			shared.GetByteSizeString(n.Committed.Memory, 1), shared.GetByteSizeString(n.Total.Memory, 1),
+			shared.GetByteSizeString(n.Committed.DiskSize, 1), shared.GetByteSizeString(n.Total.DiskSize, 1),
+			n.Committed.GPUSlots, n.Total.GPUSlots,
+			n.Committed.VPUSlots, n.Total.VPUSlots)
+	}
+
+	if len(cmd.appID) == 0 {
+		return
+	}
+
+	app, _, err := c.RetrieveApplicationByID(cmd.appID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fit, err := cluster.Fit(capacity.RequirementsFromApplication(app))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("\n%d more instances of %s fit into the cluster\n", fit.Count, app.Name)
+	for _, n := range fit.Nodes {
+		fmt.Printf("  %s: %d (limited by %s)\n", n.Node, n.Count, n.Reason)
+	}
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+)
+
+type deviceReportCmd struct {
+	common.ConnectionCmd
+	format string
+}
+
+func (command *deviceReportCmd) Parse() {
+	flag.StringVar(&command.format, "format", "table", "Output format (table, json, csv)")
+
+	command.ConnectionCmd.Parse()
+}
+
+func main() {
+	cmd := &deviceReportCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	nodes, err := c.ListNodes()
+	if err != nil {
+		log.Fatal(err)
+	}
+	instances, err := c.ListInstances()
+	if err != nil {
+		log.Fatal(err)
+	}
+	report := capacity.NewDeviceReport(nodes, instances)
+
+	switch cmd.format {
+	case "table":
+		err = report.WriteTable(os.Stdout)
+	case "csv":
+		err = report.WriteCSV(os.Stdout)
+	case "json":
+		var b []byte
+		b, err = json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			fmt.Println(string(b))
+		}
+	default:
+		log.Fatalf("Unknown format %q", cmd.format)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/exporter"
+)
+
+type exporterCmd struct {
+	common.ConnectionCmd
+	listen  string
+	refresh time.Duration
+	resync  time.Duration
+}
+
+func (command *exporterCmd) Parse() {
+	flag.StringVar(&command.listen, "listen", ":9410", "Address to serve metrics on")
+	flag.DurationVar(&command.refresh, "refresh", time.Minute, "How often nodes, applications, images and tasks are listed")
+	flag.DurationVar(&command.resync, "resync", 10*time.Minute, "How often instances and operations are fully listed")
+
+	command.ConnectionCmd.Parse()
+}
+
+func main() {
+	cmd := &exporterCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	e, err := exporter.New(c, &exporter.Options{
+		RefreshInterval: cmd.refresh,
+		ResyncInterval:  cmd.resync,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	srv := &http.Server{Addr: cmd.listen, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+	defer srv.Close()
+
+	if err := e.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
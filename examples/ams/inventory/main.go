@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/inventory"
+)
+
+type inventoryCmd struct {
+	common.ConnectionCmd
+	savePath string
+	fromPath string
+	toPath   string
+}
+
+func (command *inventoryCmd) Parse() {
+	flag.StringVar(&command.savePath, "save", "", "Write a snapshot of the cluster to the given path (.json or .yaml)")
+	flag.StringVar(&command.fromPath, "from", "", "Snapshot to compare from")
+	flag.StringVar(&command.toPath, "to", "", "Snapshot to compare to. The cluster at -url is used if not given.")
+	flag.StringVar(&command.ClientCert, "cert", "", "Path to the file with the client certificate to use to connect to AMS")
+	flag.StringVar(&command.ClientKey, "key", "", "Path to the file with the client key to use to connect to AMS")
+	flag.StringVar(&command.ServiceURL, "url", "", "URL of the AMS server")
+
+	flag.Parse()
+
+	if len(command.savePath) == 0 && len(command.fromPath) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	if len(command.toPath) == 0 {
+		if err := command.Validate(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+func main() {
+	cmd := &inventoryCmd{}
+	cmd.Parse()
+
+	var to *inventory.Snapshot
+	var err error
+	if len(cmd.toPath) > 0 {
+		to, err = inventory.Load(cmd.toPath)
+	} else {
+		to, err = inventory.Take(cmd.NewClient())
+		if err == nil && !to.Consistent {
+			log.Print("Cluster kept changing while taking the snapshot")
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cmd.savePath) > 0 {
+		if err := to.Save(cmd.savePath); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(cmd.fromPath) == 0 {
+		return
+	}
+	from, err := inventory.Load(cmd.fromPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d, err := inventory.Compare(from, to)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(d)
+	if !d.Empty() {
+		os.Exit(1)
+	}
+}
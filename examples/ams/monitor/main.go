@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/monitor"
+)
+
+type monitorCmd struct {
+	common.ConnectionCmd
+	configPath string
+}
+
+func (command *monitorCmd) Parse() {
+	flag.StringVar(&command.configPath, "config", "", "Path to the YAML file describing rules and sinks")
+
+	command.ConnectionCmd.Parse()
+
+	if len(command.configPath) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &monitorCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	cfg, err := monitor.LoadConfig(cmd.configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts, err := cfg.Options()
+	if err != nil {
+		log.Fatal(err)
+	}
+	m, err := monitor.New(c, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := m.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
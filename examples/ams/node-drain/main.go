@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+type nodeDrainCmd struct {
+	common.ConnectionCmd
+	name           string
+	policy         string
+	concurrency    int
+	maxUnavailable int
+	timeout        time.Duration
+	force          bool
+	uncordon       bool
+}
+
+func (command *nodeDrainCmd) Parse() {
+	flag.StringVar(&command.name, "name", "", "Name of the node to drain")
+	flag.StringVar(&command.policy, "policy", string(client.DrainPolicyWait), "How to evict instances (wait, delete, relaunch)")
+	flag.IntVar(&command.concurrency, "concurrency", 0, "Number of instances evicted at the same time")
+	flag.IntVar(&command.maxUnavailable, "max-unavailable", 0, "Maximum number of instances of one application evicted at the same time")
+	flag.DurationVar(&command.timeout, "timeout", 0, "Maximum time to wait for instances to terminate on their own")
+	flag.BoolVar(&command.force, "force", false, "Force deletion of instances")
+	flag.BoolVar(&command.uncordon, "uncordon", false, "Mark the node as schedulable again instead of draining it")
+
+	command.ConnectionCmd.Parse()
+
+	if len(command.name) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &nodeDrainCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if cmd.uncordon {
+		if err := c.UncordonNode(ctx, cmd.name); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_, err := c.DrainNode(ctx, cmd.name, &client.DrainArgs{
+		Policy:         client.DrainPolicy(cmd.policy),
+		Concurrency:    cmd.concurrency,
+		MaxUnavailable: cmd.maxUnavailable,
+		Timeout:        cmd.timeout,
+		Force:          cmd.force,
+		Progress:       printProgress,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func printProgress(p client.DrainProgress) {
+	switch p.Phase {
+	case client.DrainPhaseCordoned:
+		fmt.Printf("Node %s cordoned\n", p.Node)
+	case client.DrainPhaseEvicting:
+		fmt.Printf("Evicting %s\n", p.Instance)
+	case client.DrainPhaseEvicted:
+		if len(p.Replacement) > 0 {
+			fmt.Printf("Evicted %s, replaced by %s (%d/%d)\n", p.Instance, p.Replacement, p.Evicted, p.Total)
+		} else {
+			fmt.Printf("Evicted %s (%d/%d)\n", p.Instance, p.Evicted, p.Total)
+		}
+	case client.DrainPhaseFailed:
+		fmt.Printf("Failed to evict %s: %v\n", p.Instance, p.Err)
+	case client.DrainPhaseDone:
+		fmt.Printf("Node %s drained, %d instances evicted\n", p.Node, p.Evicted)
+	}
+}
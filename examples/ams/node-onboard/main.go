@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+type nodeOnboardCmd struct {
+	common.ConnectionCmd
+	inventoryPath   string
+	concurrency     int
+	noRollback      bool
+	preflightOnly   bool
+	existingSubnets string
+}
+
+func (command *nodeOnboardCmd) Parse() {
+	flag.StringVar(&command.inventoryPath, "inventory", "", "Path to the YAML inventory of nodes to add")
+	flag.IntVar(&command.concurrency, "concurrency", 0, "Number of nodes added at the same time")
+	flag.BoolVar(&command.noRollback, "no-rollback", false, "Keep added nodes if adding another node fails")
+	flag.BoolVar(&command.preflightOnly, "preflight", false, "Only check the nodes without adding them")
+	flag.StringVar(&command.existingSubnets, "existing-subnets", "", "Comma separated list of subnets already used by nodes of the cluster")
+
+	command.ConnectionCmd.Parse()
+
+	if len(command.inventoryPath) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func main() {
+	cmd := &nodeOnboardCmd{}
+	cmd.Parse()
+	c := cmd.NewClient()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	nodes, err := client.LoadNodeInventory(cmd.inventoryPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	preflight := client.NodePreflightArgs{}
+	if len(cmd.existingSubnets) > 0 {
+		preflight.ExistingSubnets = strings.Split(cmd.existingSubnets, ",")
+	}
+
+	if cmd.preflightOnly {
+		if err := c.PreflightNodes(ctx, nodes, &preflight); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("All %d nodes passed the preflight checks\n", len(nodes))
+		return
+	}
+
+	result, err := c.OnboardNodes(ctx, nodes, &client.NodeOnboardArgs{
+		NodePreflightArgs: preflight,
+		Concurrency:       cmd.concurrency,
+		NoRollback:        cmd.noRollback,
+		Progress: func(name string, err error) {
+			if err != nil {
+				fmt.Printf("Failed to add node %s: %v\n", name, err)
+				return
+			}
+			fmt.Printf("Added node %s\n", name)
+		},
+	})
+	if result != nil && len(result.RolledBack) > 0 {
+		fmt.Printf("Removed nodes again: %s\n", strings.Join(result.RolledBack, ", "))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/openapi"
+)
+
+func main() {
+	output := flag.String("output", "", "Path to write the OpenAPI document to. Printed to stdout if empty.")
+	check := flag.String("check", "", "Path of a stored OpenAPI document to compare with the generated one")
+	flag.Parse()
+
+	if len(*check) > 0 {
+		if err := openapi.Check(*check); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	b, err := openapi.Generate().JSON()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(*output) == 0 {
+		os.Stdout.Write(b)
+		return
+	}
+	if err := os.WriteFile(*output, b, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/schema"
+)
+
+func main() {
+	outputDir := flag.String("output", "", "Directory to write all schema documents to")
+	name := flag.String("validate", "", "Name of the schema to validate a file against")
+	file := flag.String("file", "", "YAML or JSON file to validate")
+	flag.Parse()
+
+	switch {
+	case len(*outputDir) > 0:
+		if err := writeSchemas(*outputDir); err != nil {
+			log.Fatal(err)
+		}
+	case len(*name) > 0 && len(*file) > 0:
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := schema.Validate(*name, data); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is a valid %s\n", *file, *name)
+	default:
+		fmt.Printf("Available schemas: %v\n", schema.Names())
+		flag.Usage()
+		os.Exit(1)
+	}
+}
+
+func writeSchemas(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, name := range schema.Names() {
+		s, err := schema.Get(name)
+		if err != nil {
+			return err
+		}
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dir, name+".schema.json")
+		if err := os.WriteFile(target, append(b, '\n'), 0644); err != nil {
+			return err
+		}
+		fmt.Println(target)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/anbox-cloud/ams-sdk/examples/ams/common"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/simulator"
+	yaml "gopkg.in/yaml.v2"
+)
+
+type simulateCmd struct {
+	common.ConnectionCmd
+	snapshotPath     string
+	scenarioPath     string
+	scorer           string
+	saveSnapshotPath string
+}
+
+func (command *simulateCmd) Parse() {
+	flag.StringVar(&command.snapshotPath, "snapshot", "", "Path to a YAML snapshot of the cluster. The cluster at -url is used if not given.")
+	flag.StringVar(&command.scenarioPath, "scenario", "", "Path to a YAML file describing the changes to simulate")
+	flag.StringVar(&command.scorer, "scorer", "spread", "Placement policy to model (spread, pack)")
+	flag.StringVar(&command.saveSnapshotPath, "save-snapshot", "", "Write the snapshot of the cluster to the given path")
+	flag.StringVar(&command.ClientCert, "cert", "", "Path to the file with the client certificate to use to connect to AMS")
+	flag.StringVar(&command.ClientKey, "key", "", "Path to the file with the client key to use to connect to AMS")
+	flag.StringVar(&command.ServiceURL, "url", "", "URL of the AMS server")
+
+	flag.Parse()
+
+	if len(command.snapshotPath) == 0 {
+		if err := command.Validate(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+}
+
+func main() {
+	cmd := &simulateCmd{}
+	cmd.Parse()
+
+	var snap *simulator.Snapshot
+	var err error
+	if len(cmd.snapshotPath) > 0 {
+		snap, err = simulator.LoadSnapshot(cmd.snapshotPath)
+	} else {
+		snap, err = simulator.TakeSnapshot(cmd.NewClient())
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if len(cmd.saveSnapshotPath) > 0 {
+		b, err := yaml.Marshal(snap)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(cmd.saveSnapshotPath, b, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	scenario := &simulator.Scenario{}
+	if len(cmd.scenarioPath) > 0 {
+		scenario, err = simulator.LoadScenario(cmd.scenarioPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	opts := &simulator.Options{}
+	switch cmd.scorer {
+	case "spread":
+		opts.Scorer = simulator.LeastAllocated
+	case "pack":
+		opts.Scorer = simulator.MostAllocated
+	default:
+		log.Fatalf("Unknown scorer %q", cmd.scorer)
+	}
+
+	result, err := simulator.Simulate(snap, scenario, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(result)
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,309 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package amstest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+// applicationState holds the packages of the applications the fake server
+// knows about and the objects they depend on
+type applicationState struct {
+	// packages holds the package of each application version, indexed by
+	// application ID and version number
+	packages     map[string][][]byte
+	addons       []api.Addon
+	config       map[string]interface{}
+	certificates []restapi.Certificate
+	exports      int
+}
+
+func (s *Server) registerApplications() {
+	s.packages = map[string][][]byte{}
+	s.config = map[string]interface{}{}
+	s.mux.HandleFunc("POST /1.0/applications", s.handleApplicationsPost)
+	s.mux.HandleFunc("GET /1.0/applications/{id}", s.handleApplicationGet)
+	s.mux.HandleFunc("PATCH /1.0/applications/{id}", s.handleApplicationPatch)
+	s.mux.HandleFunc("GET /1.0/applications/{id}/{version}", s.handleApplicationVersionGet)
+	s.mux.HandleFunc("PATCH /1.0/applications/{id}/{version}", s.handleApplicationVersionPatch)
+	s.mux.HandleFunc("GET /1.0/addons", s.handleAddonsGet)
+	s.mux.HandleFunc("GET /1.0/config", s.handleConfigGet)
+	s.mux.HandleFunc("PATCH /1.0/config", s.handleConfigPatch)
+	s.mux.HandleFunc("GET /1.0/certificates", s.handleCertificatesGet)
+}
+
+// SetAddons replaces the addons the server reports
+func (s *Server) SetAddons(addons ...api.Addon) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.addons = append([]api.Addon{}, addons...)
+}
+
+// SetConfig replaces the config items the server reports
+func (s *Server) SetConfig(config map[string]interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config = map[string]interface{}{}
+	for k, v := range config {
+		s.config[k] = v
+	}
+}
+
+// Config returns the config items the server currently holds
+func (s *Server) Config() map[string]interface{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	config := map[string]interface{}{}
+	for k, v := range s.config {
+		config[k] = v
+	}
+	return config
+}
+
+// SetCertificates replaces the certificates the server reports
+func (s *Server) SetCertificates(certificates ...restapi.Certificate) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.certificates = append([]restapi.Certificate{}, certificates...)
+}
+
+// Exports returns how many application versions were exported
+func (s *Server) Exports() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.exports
+}
+
+// findApplication returns the application with the given ID. The caller
+// must hold the lock.
+func (s *Server) findApplication(id string) *api.Application {
+	for n := range s.applications {
+		if s.applications[n].ID == id {
+			return &s.applications[n]
+		}
+	}
+	return nil
+}
+
+// addApplicationVersion adds a new version built from the given package to
+// the application. The caller must hold the lock.
+func (s *Server) addApplicationVersion(app *api.Application, data []byte) {
+	status := api.ImageStatusActive
+	app.Versions = append(app.Versions, api.ApplicationVersion{
+		Number:        len(app.Versions),
+		ParentImageID: app.ParentImageID,
+		StatusCode:    status,
+		Status:        status.String(),
+	})
+	s.packages[app.ID] = append(s.packages[app.ID], data)
+}
+
+// readManifest parses the manifest of an uploaded application package
+func readManifest(data []byte) (*packages.ApplicationManifest, error) {
+	f, err := os.CreateTemp("", "amstest-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	p, err := packages.NewApplicationPackage(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	return p.ApplicationManifest(), nil
+}
+
+func (s *Server) handleApplicationsPost(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.receivePayload(w, r)
+	if !ok {
+		return
+	}
+	manifest, err := readManifest(p.Data)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid application package: %v", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, app := range s.applications {
+		if app.Name == manifest.Name {
+			writeError(w, http.StatusBadRequest, "application %s already exists", manifest.Name)
+			return
+		}
+	}
+	status := api.ApplicationStatusReady
+	app := api.Application{
+		ID:           s.generateID(),
+		Name:         manifest.Name,
+		StatusCode:   status,
+		Status:       status.String(),
+		InstanceType: manifest.InstanceType,
+		Addons:       manifest.Addons,
+		VM:           r.URL.Query().Get("vm") == "true",
+	}
+	for _, img := range s.images {
+		if img.Name == manifest.Image {
+			app.ParentImageID = img.ID
+		}
+	}
+	s.addApplicationVersion(&app, p.Data)
+	s.applications = append(s.applications, app)
+	s.payloads = append(s.payloads, *p)
+
+	writeOperation(w, s.generateID(), "Creating application", map[string][]string{
+		"applications": {"/1.0/applications/" + app.ID},
+	})
+}
+
+func (s *Server) handleApplicationGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	app := s.findApplication(r.PathValue("id"))
+	if app == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeSync(w, app)
+}
+
+// handleApplicationPatch only supports adding a new version from a package
+func (s *Server) handleApplicationPatch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/octet-stream" {
+		writeError(w, http.StatusNotImplemented, "only package updates are supported")
+		return
+	}
+	p, ok := s.receivePayload(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	app := s.findApplication(r.PathValue("id"))
+	if app == nil {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	s.addApplicationVersion(app, p.Data)
+	s.payloads = append(s.payloads, *p)
+	writeOperation(w, s.generateID(), "Updating application", nil)
+}
+
+// findVersion returns the requested application version and its package. If
+// it fails, the error is written to w. The caller must hold the lock.
+func (s *Server) findVersion(w http.ResponseWriter, r *http.Request) (*api.ApplicationVersion, []byte, bool) {
+	app := s.findApplication(r.PathValue("id"))
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if app == nil || err != nil || version < 0 || version >= len(app.Versions) {
+		writeError(w, http.StatusNotFound, "not found")
+		return nil, nil, false
+	}
+	var data []byte
+	if pkgs := s.packages[app.ID]; version < len(pkgs) {
+		data = pkgs[version]
+	}
+	return &app.Versions[version], data, true
+}
+
+func (s *Server) handleApplicationVersionGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	_, data, ok := s.findVersion(w, r)
+	if ok && data == nil {
+		writeError(w, http.StatusNotFound, "version has no package")
+		ok = false
+	}
+	if ok {
+		s.exports++
+	}
+	s.lock.Unlock()
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("X-AMS-Fingerprint", fmt.Sprintf("%x", sha256.Sum256(data)))
+	w.Write(data)
+}
+
+func (s *Server) handleApplicationVersionPatch(w http.ResponseWriter, r *http.Request) {
+	var req api.ApplicationVersionPatch
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, _, ok := s.findVersion(w, r)
+	if !ok {
+		return
+	}
+	if req.Published != nil {
+		v.Published = *req.Published
+	}
+	writeOperation(w, s.generateID(), "Updating application version", nil)
+}
+
+func (s *Server) handleAddonsGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, s.addons)
+}
+
+func (s *Server) handleConfigGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, api.ConfigGet{Config: s.config})
+}
+
+func (s *Server) handleConfigPatch(w http.ResponseWriter, r *http.Request) {
+	var req api.ConfigPost
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.config[req.Name] = req.Value
+	writeOperation(w, s.generateID(), "Updating config", nil)
+}
+
+func (s *Server) handleCertificatesGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, s.certificates)
+}
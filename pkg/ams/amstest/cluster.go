@@ -0,0 +1,318 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package amstest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+	"github.com/anbox-cloud/ams-sdk/pkg/network"
+	"github.com/gorilla/websocket"
+)
+
+// clusterState holds the cluster objects the fake server reports
+type clusterState struct {
+	apiStatus    string
+	nodes        []api.Node
+	instances    []api.Instance
+	images       []api.Image
+	applications []api.Application
+	tasks        []api.Task
+	operations   []*restapi.Operation
+	eventConns   map[*websocket.Conn]bool
+	nodeFailures map[string]nodeFailure
+}
+
+// nodeFailure describes how adding a node fails
+type nodeFailure struct {
+	message string
+	keep    bool
+}
+
+func (s *Server) registerCluster() {
+	s.apiStatus = "stable"
+	s.eventConns = map[*websocket.Conn]bool{}
+	s.nodeFailures = map[string]nodeFailure{}
+	s.mux.HandleFunc("GET /1.0/nodes", s.handleNodesGet)
+	s.mux.HandleFunc("POST /1.0/nodes", s.handleNodesPost)
+	s.mux.HandleFunc("GET /1.0/nodes/{name}", s.handleNodeGet)
+	s.mux.HandleFunc("DELETE /1.0/nodes/{name}", s.handleNodeDelete)
+	s.mux.HandleFunc("GET /1.0/instances", s.handleInstancesGet)
+	s.mux.HandleFunc("GET /1.0/instances/{id}", s.handleInstanceGet)
+	s.mux.HandleFunc("GET /1.0/images", s.handleImagesGet)
+	s.mux.HandleFunc("GET /1.0/applications", s.handleApplicationsGet)
+	s.mux.HandleFunc("GET /1.0/tasks", s.handleTasksGet)
+	s.mux.HandleFunc("GET /1.0/operations", s.handleOperationsGet)
+	s.mux.HandleFunc("GET /1.0/events", s.handleEvents)
+}
+
+// SetAPIStatus changes the API status the server reports
+func (s *Server) SetAPIStatus(status string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.apiStatus = status
+}
+
+// SetNodes replaces the nodes the server reports
+func (s *Server) SetNodes(nodes ...api.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodes = append([]api.Node{}, nodes...)
+}
+
+// FailNodeAdd makes adding the node with the given name fail with the
+// given message. If keep is set the node stays in the cluster in error
+// state, like AMS does with nodes which fail while being set up.
+func (s *Server) FailNodeAdd(name, message string, keep bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.nodeFailures[name] = nodeFailure{message: message, keep: keep}
+}
+
+// SetInstances replaces the instances the server reports
+func (s *Server) SetInstances(instances ...api.Instance) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.instances = append([]api.Instance{}, instances...)
+}
+
+// SetImages replaces the images the server reports
+func (s *Server) SetImages(images ...api.Image) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.images = append([]api.Image{}, images...)
+}
+
+// SetApplications replaces the applications the server reports
+func (s *Server) SetApplications(applications ...api.Application) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.applications = append([]api.Application{}, applications...)
+}
+
+// SetTasks replaces the tasks the server reports
+func (s *Server) SetTasks(tasks ...api.Task) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tasks = append([]api.Task{}, tasks...)
+}
+
+// SetOperations replaces the operations the server reports
+func (s *Server) SetOperations(operations ...*restapi.Operation) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.operations = append([]*restapi.Operation{}, operations...)
+}
+
+// EventListeners returns the number of clients listening for events
+func (s *Server) EventListeners() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.eventConns)
+}
+
+// SendEvent sends an event to all clients listening for events
+func (s *Server) SendEvent(event api.Event) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for conn := range s.eventConns {
+		if err := conn.WriteMessage(websocket.TextMessage, b); err != nil {
+			conn.Close()
+			delete(s.eventConns, conn)
+		}
+	}
+	return nil
+}
+
+// Close disconnects all event listeners and shuts the server down
+func (s *Server) Close() {
+	s.lock.Lock()
+	for conn := range s.eventConns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+	s.Server.Close()
+}
+
+func (s *Server) handleNodesGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, s.nodes)
+}
+
+func (s *Server) handleNodeGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, n := range s.nodes {
+		if n.Name == r.PathValue("name") {
+			writeSync(w, n)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func (s *Server) handleNodesPost(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	node := api.NodesPost{}
+	if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid node: %v", err)
+		return
+	}
+	for _, n := range s.nodes {
+		if n.Name == node.Name {
+			writeError(w, http.StatusBadRequest, "node %s already exists", node.Name)
+			return
+		}
+	}
+
+	failure, failed := s.nodeFailures[node.Name]
+	if failed && !failure.keep {
+		writeError(w, http.StatusInternalServerError, "%s", failure.message)
+		return
+	}
+	status := api.NodeStatusOnline
+	if failed {
+		status = api.NodeStatusError
+	}
+	s.nodes = append(s.nodes, api.Node{
+		Name:       node.Name,
+		Address:    node.Address,
+		Status:     status.String(),
+		StatusCode: status,
+		Tags:       node.Tags,
+	})
+	if failed {
+		writeFailedOperation(w, s.generateID(), "Adding node", failure.message)
+		return
+	}
+	writeOperation(w, s.generateID(), "Adding node", map[string][]string{
+		"nodes": {"/1.0/nodes/" + node.Name},
+	})
+}
+
+func (s *Server) handleNodeDelete(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for n, node := range s.nodes {
+		if node.Name == r.PathValue("name") {
+			s.nodes = append(s.nodes[:n], s.nodes[n+1:]...)
+			writeOperation(w, s.generateID(), "Removing node", nil)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+// handleInstancesGet supports filtering by node, status and application
+func (s *Server) handleInstancesGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q := r.URL.Query()
+	instances := []api.Instance{}
+	for _, inst := range s.instances {
+		if (q.Has("node") && inst.Node != q.Get("node")) ||
+			(q.Has("status") && inst.Status != q.Get("status")) ||
+			(q.Has("app_id") && inst.AppID != q.Get("app_id")) {
+			continue
+		}
+		instances = append(instances, inst)
+	}
+	writeSync(w, instances)
+}
+
+func (s *Server) handleInstanceGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, inst := range s.instances {
+		if inst.ID == r.PathValue("id") {
+			writeSync(w, inst)
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "not found")
+}
+
+func (s *Server) handleImagesGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, s.images)
+}
+
+// handleApplicationsGet supports filtering by name
+func (s *Server) handleApplicationsGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	q := r.URL.Query()
+	applications := []api.Application{}
+	for _, app := range s.applications {
+		if q.Has("name") && app.Name != q.Get("name") {
+			continue
+		}
+		applications = append(applications, app)
+	}
+	writeSync(w, applications)
+}
+
+func (s *Server) handleTasksGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, s.tasks)
+}
+
+// handleOperationsGet groups the operations by their status like AMS does
+func (s *Server) handleOperationsGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	operations := map[string][]*restapi.Operation{}
+	for _, op := range s.operations {
+		key := strings.ToLower(op.Status)
+		operations[key] = append(operations[key], op)
+	}
+	writeSync(w, operations)
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	conn, err := network.WebsocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	s.eventConns[conn] = true
+	s.lock.Unlock()
+
+	// Drain the connection to notice when the client goes away
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+	s.lock.Lock()
+	delete(s.eventConns, conn)
+	s.lock.Unlock()
+	conn.Close()
+}
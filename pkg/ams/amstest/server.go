@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package amstest provides an in-memory fake of the AMS REST API which can be
+// used to test clients without a running AMS service.
+package amstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+// Server is a fake AMS service. Only the parts of the API needed by the
+// tests using it are implemented.
+type Server struct {
+	*httptest.Server
+
+	mux        *http.ServeMux
+	lock       sync.Mutex
+	extensions []string
+	nextID     int
+
+	uploadState
+	clusterState
+	applicationState
+}
+
+// NewServer starts a new fake AMS service which announces the given API
+// extensions. The server must be closed with Close once it isn't needed
+// anymore.
+func NewServer(extensions ...string) *Server {
+	s := &Server{
+		mux:        http.NewServeMux(),
+		extensions: extensions,
+	}
+	s.mux.HandleFunc("GET /1.0", s.handleServiceStatus)
+	s.registerUploads()
+	s.registerCluster()
+	s.registerApplications()
+	s.Server = httptest.NewServer(s.mux)
+	return s
+}
+
+func (s *Server) handleServiceStatus(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	writeSync(w, api.ServiceStatus{
+		APIExtensions: s.extensions,
+		APIStatus:     s.apiStatus,
+		APIVersion:    restapi.Version,
+		Auth:          "trusted",
+	})
+}
+
+// generateID returns a new unique ID. The caller must hold the lock.
+func (s *Server) generateID() string {
+	s.nextID++
+	return fmt.Sprintf("amstest%013d", s.nextID)
+}
+
+func writeSync(w http.ResponseWriter, metadata interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(restapi.ResponseRaw{
+		Response: restapi.Response{
+			Type:       restapi.ResponseTypeSync,
+			Status:     restapi.Success.String(),
+			StatusCode: int(restapi.Success),
+		},
+		Metadata: metadata,
+	})
+}
+
+// writeOperation responds with an operation which has already finished
+func writeOperation(w http.ResponseWriter, id, description string, resources map[string][]string) {
+	now := time.Now()
+	writeAsync(w, restapi.Operation{
+		ID:          id,
+		Description: description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Status:      restapi.Success.String(),
+		StatusCode:  restapi.Success,
+		Resources:   resources,
+	})
+}
+
+// writeFailedOperation responds with an operation which has already failed
+func writeFailedOperation(w http.ResponseWriter, id, description, message string) {
+	now := time.Now()
+	writeAsync(w, restapi.Operation{
+		ID:          id,
+		Description: description,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Status:      restapi.Failure.String(),
+		StatusCode:  restapi.Failure,
+		Err:         message,
+	})
+}
+
+func writeAsync(w http.ResponseWriter, op restapi.Operation) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(restapi.ResponseRaw{
+		Response: restapi.Response{
+			Type:       restapi.ResponseTypeAsync,
+			Status:     restapi.OperationCreated.String(),
+			StatusCode: int(restapi.OperationCreated),
+			Operation:  "/" + restapi.Version + "/operations/" + op.ID,
+		},
+		Metadata: op,
+	})
+}
+
+func writeError(w http.ResponseWriter, code int, format string, args ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(restapi.Response{
+		Type:  restapi.ResponseTypeError,
+		Code:  code,
+		Error: fmt.Sprintf(format, args...),
+	})
+}
@@ -0,0 +1,328 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package amstest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const uploadExpiry = 24 * time.Hour
+
+// uploadsPost and uploadInfo mirror the wire format of the experimental
+// chunked upload protocol implemented by pkg/ams/experimental/uploads
+type uploadsPost struct {
+	Fingerprint string `json:"fingerprint"`
+	Size        int64  `json:"size"`
+	ChunkSize   int64  `json:"chunk_size,omitempty"`
+}
+
+type uploadInfo struct {
+	ID             string  `json:"id"`
+	Fingerprint    string  `json:"fingerprint"`
+	Size           int64   `json:"size"`
+	ChunkSize      int64   `json:"chunk_size"`
+	ReceivedChunks []int64 `json:"received_chunks"`
+	Complete       bool    `json:"complete"`
+	ExpiryAt       int64   `json:"expiry_at"`
+}
+
+type upload struct {
+	uploadInfo
+	chunks map[int64][]byte
+}
+
+// Payload is a package the fake server received for an image or an
+// application
+type Payload struct {
+	// Method of the request which consumed the payload
+	Method string
+	// Path of the request which consumed the payload
+	Path string
+	// Request holds the raw X-AMS-Request header
+	Request string
+	// Data is the received payload
+	Data []byte
+	// Size is the length of the payload announced by the client or -1 if
+	// it was sent without announcing its length
+	Size int64
+	// Chunked is true when the payload was sent through a chunked upload
+	Chunked bool
+}
+
+// uploadState holds everything the fake server tracks for uploads
+type uploadState struct {
+	uploads       map[string]*upload
+	payloads      []Payload
+	failChunks    int
+	maxChunkSize  int64
+	chunkRequests int
+}
+
+func (s *Server) registerUploads() {
+	s.uploads = map[string]*upload{}
+	s.mux.HandleFunc("POST /1.0/uploads", s.handleUploadsPost)
+	s.mux.HandleFunc("GET /1.0/uploads/{id}", s.handleUploadGet)
+	s.mux.HandleFunc("PUT /1.0/uploads/{id}", s.handleUploadPut)
+	s.mux.HandleFunc("DELETE /1.0/uploads/{id}", s.handleUploadDelete)
+	s.mux.HandleFunc("POST /1.0/images", s.handlePayload)
+	s.mux.HandleFunc("PATCH /1.0/images/{id}", s.handlePayload)
+}
+
+// FailNextChunks lets the next n chunk uploads fail to simulate an unreliable
+// connection
+func (s *Server) FailNextChunks(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failChunks = n
+}
+
+// SetMaxChunkSize limits the chunk size the server accepts. Clients asking
+// for larger chunks are told to use this size instead.
+func (s *Server) SetMaxChunkSize(size int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxChunkSize = size
+}
+
+// ChunkRequests returns the number of chunk uploads the server has seen,
+// including failed ones
+func (s *Server) ChunkRequests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.chunkRequests
+}
+
+// Payloads returns all image and application payloads the server has received
+func (s *Server) Payloads() []Payload {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]Payload{}, s.payloads...)
+}
+
+func (s *Server) handleUploadsPost(w http.ResponseWriter, r *http.Request) {
+	var req uploadsPost
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if len(req.Fingerprint) == 0 || req.Size <= 0 {
+		writeError(w, http.StatusBadRequest, "fingerprint and size are required")
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Resume an incomplete upload of the same payload
+	for _, u := range s.uploads {
+		if u.Fingerprint == req.Fingerprint && u.Size == req.Size && !u.Complete {
+			writeSync(w, u.uploadInfo)
+			return
+		}
+	}
+
+	chunkSize := req.ChunkSize
+	if chunkSize <= 0 || (s.maxChunkSize > 0 && chunkSize > s.maxChunkSize) {
+		chunkSize = s.maxChunkSize
+	}
+	if chunkSize <= 0 {
+		chunkSize = req.Size
+	}
+
+	u := &upload{
+		uploadInfo: uploadInfo{
+			ID:             s.generateID(),
+			Fingerprint:    req.Fingerprint,
+			Size:           req.Size,
+			ChunkSize:      chunkSize,
+			ReceivedChunks: []int64{},
+			ExpiryAt:       time.Now().Add(uploadExpiry).Unix(),
+		},
+		chunks: map[int64][]byte{},
+	}
+	s.uploads[u.ID] = u
+	writeSync(w, u.uploadInfo)
+}
+
+func (s *Server) handleUploadGet(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	u, ok := s.uploads[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+	writeSync(w, u.uploadInfo)
+}
+
+func (s *Server) handleUploadDelete(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.uploads[id]; !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+	delete(s.uploads, id)
+	writeSync(w, nil)
+}
+
+func (s *Server) handleUploadPut(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("X-AMS-Upload-Offset"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid upload offset")
+		return
+	}
+
+	s.lock.Lock()
+	s.chunkRequests++
+	fail := s.failChunks > 0
+	if fail {
+		s.failChunks--
+	}
+	u, ok := s.uploads[r.PathValue("id")]
+	s.lock.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "upload not found")
+		return
+	}
+	if fail {
+		// Consume part of the chunk before failing, like a dropped connection would
+		io.CopyN(io.Discard, r.Body, 1)
+		writeError(w, http.StatusInternalServerError, "simulated failure")
+		return
+	}
+	if offset < 0 || offset >= u.Size || offset%u.ChunkSize != 0 {
+		writeError(w, http.StatusBadRequest, "invalid upload offset %d", offset)
+		return
+	}
+
+	idx := offset / u.ChunkSize
+	length := u.ChunkSize
+	if offset+length > u.Size {
+		length = u.Size - offset
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, length+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read chunk: %v", err)
+		return
+	}
+	if int64(len(data)) != length {
+		writeError(w, http.StatusBadRequest, "chunk %d has %d bytes, expected %d", idx, len(data), length)
+		return
+	}
+	if fp := r.Header.Get("X-AMS-Chunk-Fingerprint"); len(fp) > 0 && fp != fmt.Sprintf("%x", sha256.Sum256(data)) {
+		writeError(w, http.StatusBadRequest, "chunk %d does not match its fingerprint", idx)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := u.chunks[idx]; !ok {
+		u.chunks[idx] = data
+		u.ReceivedChunks = append(u.ReceivedChunks, idx)
+		sort.Slice(u.ReceivedChunks, func(i, j int) bool { return u.ReceivedChunks[i] < u.ReceivedChunks[j] })
+	}
+
+	numChunks := (u.Size + u.ChunkSize - 1) / u.ChunkSize
+	if int64(len(u.chunks)) == numChunks {
+		u.Complete = fmt.Sprintf("%x", sha256.Sum256(u.assemble())) == u.Fingerprint
+	}
+	writeSync(w, nil)
+}
+
+func (u *upload) assemble() []byte {
+	var buf bytes.Buffer
+	for idx := int64(0); idx < int64(len(u.chunks)); idx++ {
+		buf.Write(u.chunks[idx])
+	}
+	return buf.Bytes()
+}
+
+// handlePayload accepts image payloads either streamed in the request body or
+// referenced through a completed chunked upload
+func (s *Server) handlePayload(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.receivePayload(w, r)
+	if !ok {
+		return
+	}
+	s.lock.Lock()
+	s.payloads = append(s.payloads, *p)
+	opID := s.generateID()
+	s.lock.Unlock()
+
+	writeOperation(w, opID, "Uploading image", nil)
+}
+
+// receivePayload reads the payload of the request and verifies it against
+// its fingerprint. If it fails, the error is written to w.
+func (s *Server) receivePayload(w http.ResponseWriter, r *http.Request) (*Payload, bool) {
+	fingerprint := r.Header.Get("X-AMS-Fingerprint")
+	p := &Payload{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		Request: r.Header.Get("X-AMS-Request"),
+		Size:    r.ContentLength,
+	}
+
+	if id := r.Header.Get("X-AMS-Upload-ID"); len(id) > 0 {
+		s.lock.Lock()
+		u, ok := s.uploads[id]
+		if ok && u.Complete {
+			delete(s.uploads, id)
+		}
+		s.lock.Unlock()
+
+		if !ok || !u.Complete {
+			writeError(w, http.StatusBadRequest, "upload %s is not complete", id)
+			return nil, false
+		}
+		if u.Fingerprint != fingerprint {
+			writeError(w, http.StatusBadRequest, "upload %s does not match fingerprint", id)
+			return nil, false
+		}
+		p.Data = u.assemble()
+		p.Chunked = true
+		return p, true
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read payload: %v", err)
+		return nil, false
+	}
+	if fmt.Sprintf("%x", sha256.Sum256(data)) != fingerprint {
+		writeError(w, http.StatusBadRequest, "payload does not match fingerprint")
+		return nil, false
+	}
+	p.Data = data
+	return p, true
+}
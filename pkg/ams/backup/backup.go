@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package backup exports the control-plane objects of an AMS cluster into an
+// archive and restores them into another cluster.
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/inventory"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Version is the version of the archive format written by this package
+const Version = 1
+
+const (
+	manifestName  = "manifest.yaml"
+	inventoryName = "inventory.yaml"
+	packagesDir   = "applications"
+)
+
+// Manifest describes the content of a backup archive. Everything but the
+// application packages is stored as an inventory snapshot next to it.
+type Manifest struct {
+	Version   int       `yaml:"version"`
+	CreatedAt time.Time `yaml:"created_at"`
+	Packages  []Package `yaml:"packages"`
+}
+
+// Package is an exported application version stored in the archive
+type Package struct {
+	Application string `yaml:"application"`
+	Version     int    `yaml:"version"`
+	Published   bool   `yaml:"published"`
+	// Path of the package inside the archive
+	Path string `yaml:"path"`
+}
+
+// Args configures a backup
+type Args struct {
+	// SkipPackages leaves out the application packages, which make up most
+	// of the archive. Applications can't be restored from such a backup.
+	SkipPackages bool
+	// Progress is called after each application version was exported
+	Progress func(application string, version int, err error)
+}
+
+// Create writes a backup of the cluster the client is connected to. The
+// archive is a gzip compressed tarball. Application versions which failed to
+// build have no package and are left out.
+func Create(ctx context.Context, c client.Client, w io.Writer, args *Args) (*Manifest, error) {
+	if args == nil {
+		args = &Args{}
+	}
+	snap, err := inventory.Take(c)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp("", "ams-backup-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	aw := newArchiveWriter(w)
+	m := &Manifest{Version: Version, CreatedAt: snap.TakenAt}
+	if !args.SkipPackages {
+		for _, app := range snap.Applications {
+			for _, v := range app.Versions {
+				if v.StatusCode == api.ImageStatusError {
+					continue
+				}
+				p, err := exportPackage(ctx, c, aw, tmpDir, &app, &v)
+				if args.Progress != nil {
+					args.Progress(app.Name, v.Number, err)
+				}
+				if err != nil {
+					return nil, fmt.Errorf("failed to export version %d of application %s: %w", v.Number, app.Name, err)
+				}
+				m.Packages = append(m.Packages, *p)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := snap.Write(&buf, inventory.FormatYAML); err != nil {
+		return nil, err
+	}
+	if err := aw.addBytes(inventoryName, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	if err := aw.addBytes(manifestName, b); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// exportPackage downloads a single application version and adds it to the
+// archive
+func exportPackage(ctx context.Context, c client.Client, aw *archiveWriter, tmpDir string, app *api.Application, v *api.ApplicationVersion) (*Package, error) {
+	tmpPath := filepath.Join(tmpDir, fmt.Sprintf("%s-%d", app.ID, v.Number))
+	if err := c.ExportApplicationToFile(ctx, app.ID, v.Number, tmpPath); err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpPath)
+
+	ext := ".tar.bz2"
+	if format, err := packages.DetectPackageFormat(tmpPath); err == nil {
+		if exts := format.Extensions(); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	p := &Package{
+		Application: app.Name,
+		Version:     v.Number,
+		Published:   v.Published,
+		Path:        path.Join(packagesDir, app.Name, fmt.Sprintf("%d%s", v.Number, ext)),
+	}
+	if err := aw.addFile(p.Path, tmpPath); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// packagesFor returns the packages of an application ordered by version
+func (m *Manifest) packagesFor(application string) []Package {
+	var pkgs []Package
+	for _, p := range m.Packages {
+		if p.Application == application {
+			pkgs = append(pkgs, p)
+		}
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Version < pkgs[j].Version })
+	return pkgs
+}
@@ -0,0 +1,255 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+var testExtensions = []string{"application_image_export", "zip_archive_support"}
+
+func newTestClient(t *testing.T, s *amstest.Server) client.Client {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeTestPackage writes an application package whose content differs for
+// every version
+func writeTestPackage(t *testing.T, name string, version int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"manifest.yaml": fmt.Sprintf("name: %s\ninstance-type: a2.3\n", name),
+		"app.apk":       fmt.Sprintf("%s version %d", name, version),
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), fmt.Sprintf("%s-%d.zip", name, version))
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// addTestApplication creates an application with the given number of
+// versions of which the ones listed in published get published
+func addTestApplication(t *testing.T, c client.Client, name string, versions int, published ...int) {
+	t.Helper()
+	ctx := context.Background()
+	op, err := c.CreateApplication(writeTestPackage(t, name, 0), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	apps, err := c.ListApplicationsWithFilters([]string{"name=" + name})
+	if err != nil || len(apps) != 1 {
+		t.Fatalf("failed to find application %s: %v", name, err)
+	}
+	id := apps[0].ID
+	for n := 1; n < versions; n++ {
+		op, err := c.UpdateApplicationWithPackage(id, writeTestPackage(t, name, n), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := op.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range published {
+		op, err := c.PublishApplicationVersion(id, n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := op.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	src := amstest.NewServer(testExtensions...)
+	defer src.Close()
+	dst := amstest.NewServer(testExtensions...)
+	defer dst.Close()
+
+	srcClient := newTestClient(t, src)
+	addTestApplication(t, srcClient, "app1", 3, 1)
+	addTestApplication(t, srcClient, "app2", 1, 0)
+	src.SetConfig(map[string]interface{}{"application.auto_publish": "false", "cpu.limit_mode": "scheduler"})
+	dst.SetConfig(map[string]interface{}{"cpu.limit_mode": "scheduler"})
+
+	var archive bytes.Buffer
+	m, err := Create(context.Background(), srcClient, &archive, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Packages) != 4 {
+		t.Fatalf("expected 4 packages in the backup, got %d", len(m.Packages))
+	}
+
+	dstClient := newTestClient(t, dst)
+	args := &RestoreArgs{PollInterval: time.Millisecond}
+	res, err := Restore(context.Background(), dstClient, bytes.NewReader(archive.Bytes()), args)
+	if err != nil {
+		t.Fatalf("restore failed: %v (%v)", err, res.Failed)
+	}
+	checkObjects(t, "restored", res.Restored, "config/application.auto_publish", "application/app1", "application/app2")
+	checkObjects(t, "unchanged", res.Unchanged, "config/cpu.limit_mode")
+
+	srcApps, err := srcClient.ListApplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dstApps, err := dstClient.ListApplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dstApps) != len(srcApps) {
+		t.Fatalf("expected %d applications, got %d", len(srcApps), len(dstApps))
+	}
+	for n := range srcApps {
+		want, got := srcApps[n], dstApps[n]
+		if got.Name != want.Name || len(got.Versions) != len(want.Versions) {
+			t.Fatalf("expected application %s with %d versions, got %s with %d", want.Name, len(want.Versions), got.Name, len(got.Versions))
+		}
+		for i := range want.Versions {
+			if got.Versions[i].Published != want.Versions[i].Published {
+				t.Errorf("application %s version %d: expected published=%v", want.Name, i, want.Versions[i].Published)
+			}
+		}
+	}
+	srcPayloads, dstPayloads := src.Payloads(), dst.Payloads()
+	if len(dstPayloads) != len(srcPayloads) {
+		t.Fatalf("expected %d uploaded packages, got %d", len(srcPayloads), len(dstPayloads))
+	}
+	for n := range srcPayloads {
+		if !bytes.Equal(dstPayloads[n].Data, srcPayloads[n].Data) {
+			t.Errorf("package %d differs from the original one", n)
+		}
+	}
+	if v := dst.Config()["application.auto_publish"]; v != "false" {
+		t.Errorf("expected config item to be restored, got %v", v)
+	}
+
+	// Running the restore again must not change anything
+	res, err = Restore(context.Background(), dstClient, bytes.NewReader(archive.Bytes()), args)
+	if err != nil {
+		t.Fatalf("second restore failed: %v (%v)", err, res.Failed)
+	}
+	checkObjects(t, "restored", res.Restored)
+	checkObjects(t, "unchanged", res.Unchanged, "config/application.auto_publish", "config/cpu.limit_mode", "application/app1", "application/app2")
+	if n := len(dst.Payloads()); n != len(srcPayloads) {
+		t.Errorf("expected no further uploads, got %d", n-len(srcPayloads))
+	}
+}
+
+func TestRestoreAddsMissingVersions(t *testing.T) {
+	src := amstest.NewServer(testExtensions...)
+	defer src.Close()
+	dst := amstest.NewServer(testExtensions...)
+	defer dst.Close()
+
+	srcClient := newTestClient(t, src)
+	addTestApplication(t, srcClient, "app", 3, 2)
+	var archive bytes.Buffer
+	if _, err := Create(context.Background(), srcClient, &archive, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A previous restore stopped after the first version
+	dstClient := newTestClient(t, dst)
+	addTestApplication(t, dstClient, "app", 1)
+	res, err := Restore(context.Background(), dstClient, &archive, &RestoreArgs{PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("restore failed: %v (%v)", err, res.Failed)
+	}
+	checkObjects(t, "restored", res.Restored, "application/app")
+
+	apps, err := dstClient.ListApplications()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || len(apps[0].Versions) != 3 {
+		t.Fatalf("expected a single application with 3 versions, got %+v", apps)
+	}
+	for n, v := range apps[0].Versions {
+		if v.Published != (n == 2) {
+			t.Errorf("version %d: unexpected published=%v", n, v.Published)
+		}
+	}
+	payloads := dst.Payloads()
+	if len(payloads) != 3 {
+		t.Fatalf("expected 3 uploaded packages, got %d", len(payloads))
+	}
+	for n, p := range src.Payloads()[1:] {
+		if !bytes.Equal(payloads[n+1].Data, p.Data) {
+			t.Errorf("package of version %d differs from the original one", n+1)
+		}
+	}
+}
+
+func checkObjects(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	set := map[string]bool{}
+	for _, o := range got {
+		set[o] = true
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %s objects %v, got %v", what, want, got)
+		return
+	}
+	for _, o := range want {
+		if !set[o] {
+			t.Errorf("expected %s objects %v, got %v", what, want, got)
+			return
+		}
+	}
+}
@@ -0,0 +1,617 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/inventory"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+	restclient "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// RestoreArgs configures a restore
+type RestoreArgs struct {
+	// ImagePackages maps image names to local packages. Images are only
+	// referenced in a backup, so any image missing in the target cluster
+	// is uploaded from here.
+	ImagePackages map[string]string
+	// AddonPackages maps addon names to local packages. AMS has no way to
+	// export addons, so missing ones have to be provided separately.
+	AddonPackages map[string]string
+	// SkipConfig lists config items which are not restored, like ones
+	// specific to the old deployment
+	SkipConfig []string
+	// PollInterval is how often an application is checked while one of its
+	// versions is being built. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// Progress is called after each object was handled, with err set if it
+	// could not be restored
+	Progress func(kind inventory.Kind, name string, err error)
+}
+
+// RestoreResult lists what a restore did. Objects are named
+// "<kind>/<name>".
+type RestoreResult struct {
+	// Restored lists objects which were created or updated
+	Restored []string
+	// Unchanged lists objects which already matched the backup
+	Unchanged []string
+	// Failed maps objects which could not be restored to the reason
+	Failed map[string]error
+}
+
+// Restore replays a backup against the cluster the client is connected to.
+// Objects are restored in dependency order: config, images, addons,
+// applications with their versions and publish state, groups, certificates
+// and identities. Objects which already exist are updated to match the
+// backup, so a restore which failed half way can simply be run again.
+//
+// Application versions are matched by position as the target cluster
+// numbers them on its own. Permissions referring to applications or images
+// are rewritten to the IDs these got in the target cluster.
+func Restore(ctx context.Context, c client.Client, r io.Reader, args *RestoreArgs) (*RestoreResult, error) {
+	if args == nil {
+		args = &RestoreArgs{}
+	}
+	tmpDir, err := os.MkdirTemp("", "ams-restore-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := extractArchive(r, tmpDir); err != nil {
+		return nil, fmt.Errorf("failed to extract backup: %w", err)
+	}
+	m := &Manifest{}
+	if err := shared.LoadFromFile(filepath.Join(tmpDir, manifestName), m); err != nil {
+		return nil, fmt.Errorf("failed to read backup manifest: %w", err)
+	}
+	if m.Version != Version {
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("backup version %d", m.Version))
+	}
+	snap, err := inventory.Load(filepath.Join(tmpDir, inventoryName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup inventory: %w", err)
+	}
+
+	rs := &restorer{
+		c:        c,
+		args:     args,
+		dir:      tmpDir,
+		manifest: m,
+		snap:     snap,
+		result:   &RestoreResult{Failed: map[string]error{}},
+		appIDs:   map[string]string{},
+		imageIDs: map[string]string{},
+	}
+	if rs.args.PollInterval == 0 {
+		rs.args.PollInterval = defaultPollInterval
+	}
+	steps := []func(context.Context) error{
+		rs.restoreConfig,
+		rs.restoreImages,
+		rs.restoreAddons,
+		rs.restoreApplications,
+		rs.restoreGroups,
+		rs.restoreCertificates,
+		rs.restoreIdentities,
+	}
+	for _, step := range steps {
+		if err := ctx.Err(); err != nil {
+			return rs.result, err
+		}
+		if err := step(ctx); err != nil {
+			return rs.result, err
+		}
+	}
+	if len(rs.result.Failed) > 0 {
+		return rs.result, errs.NewErrFailed(fmt.Sprintf("restoring %d objects", len(rs.result.Failed)))
+	}
+	return rs.result, nil
+}
+
+type restorer struct {
+	c        client.Client
+	args     *RestoreArgs
+	dir      string
+	manifest *Manifest
+	snap     *inventory.Snapshot
+	result   *RestoreResult
+	// appIDs and imageIDs map IDs from the backup to the target cluster
+	appIDs   map[string]string
+	imageIDs map[string]string
+}
+
+func (rs *restorer) record(kind inventory.Kind, name string, changed bool, err error) {
+	key := fmt.Sprintf("%s/%s", kind, name)
+	switch {
+	case err != nil:
+		rs.result.Failed[key] = err
+	case changed:
+		rs.result.Restored = append(rs.result.Restored, key)
+	default:
+		rs.result.Unchanged = append(rs.result.Unchanged, key)
+	}
+	if rs.args.Progress != nil {
+		rs.args.Progress(kind, name, err)
+	}
+}
+
+func (rs *restorer) restoreConfig(ctx context.Context) error {
+	if len(rs.snap.Config) == 0 {
+		return nil
+	}
+	current, err := rs.c.RetrieveConfigItems()
+	if err != nil {
+		return fmt.Errorf("failed to retrieve config: %w", err)
+	}
+	keys := make([]string, 0, len(rs.snap.Config))
+	for k := range rs.snap.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := rs.snap.Config[k]
+		if v == nil || shared.StringInSlice(k, rs.args.SkipConfig) {
+			continue
+		}
+		want := configValue(v)
+		if cur, ok := current[k]; ok && configValue(cur) == want {
+			rs.record(inventory.KindConfig, k, false, nil)
+			continue
+		}
+		rs.record(inventory.KindConfig, k, true, rs.c.SetConfigItem(k, want))
+	}
+	return nil
+}
+
+// configValue formats a config value the way AMS expects it. Numbers are
+// decoded as floats and must not end up in exponent notation.
+func configValue(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func (rs *restorer) restoreImages(ctx context.Context) error {
+	if len(rs.snap.Images) == 0 {
+		return nil
+	}
+	existing, err := rs.listImages()
+	if err != nil {
+		return err
+	}
+	added := map[string]error{}
+	for _, img := range rs.snap.Images {
+		if _, ok := existing[img.Name]; ok {
+			continue
+		}
+		packagePath, ok := rs.args.ImagePackages[img.Name]
+		if !ok {
+			added[img.Name] = errs.NewErrNotFound(fmt.Sprintf("package for image %s", img.Name))
+			continue
+		}
+		op, err := rs.c.AddImage(img.Name, packagePath, img.Default, nil)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		added[img.Name] = err
+	}
+
+	if existing, err = rs.listImages(); err != nil {
+		return err
+	}
+	for _, img := range rs.snap.Images {
+		err, changed := added[img.Name]
+		target, ok := existing[img.Name]
+		if err == nil && ok {
+			rs.imageIDs[img.ID] = target.ID
+			if img.Default && !target.Default {
+				err = rs.c.SetDefaultImage(target.ID)
+				changed = true
+			}
+		}
+		rs.record(inventory.KindImage, img.Name, changed, err)
+	}
+	return nil
+}
+
+func (rs *restorer) listImages() (map[string]api.Image, error) {
+	images, err := rs.c.ListImages()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	m := make(map[string]api.Image, len(images))
+	for _, img := range images {
+		m[img.Name] = img
+	}
+	return m, nil
+}
+
+func (rs *restorer) restoreAddons(ctx context.Context) error {
+	if len(rs.snap.Addons) == 0 {
+		return nil
+	}
+	addons, err := rs.c.ListAddons()
+	if err != nil {
+		return fmt.Errorf("failed to list addons: %w", err)
+	}
+	existing := map[string]bool{}
+	for _, a := range addons {
+		existing[a.Name] = true
+	}
+	for _, a := range rs.snap.Addons {
+		if existing[a.Name] {
+			rs.record(inventory.KindAddon, a.Name, false, nil)
+			continue
+		}
+		packagePath, ok := rs.args.AddonPackages[a.Name]
+		if !ok {
+			rs.record(inventory.KindAddon, a.Name, false, errs.NewErrNotFound(fmt.Sprintf("package for addon %s", a.Name)))
+			continue
+		}
+		op, err := rs.c.AddAddon(a.Name, packagePath, nil)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		rs.record(inventory.KindAddon, a.Name, true, err)
+	}
+	return nil
+}
+
+func (rs *restorer) restoreApplications(ctx context.Context) error {
+	if len(rs.snap.Applications) == 0 {
+		return nil
+	}
+	apps, err := rs.c.ListApplications()
+	if err != nil {
+		return fmt.Errorf("failed to list applications: %w", err)
+	}
+	existing := map[string]*api.Application{}
+	for n := range apps {
+		existing[apps[n].Name] = &apps[n]
+	}
+	for _, app := range rs.snap.Applications {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		changed, err := rs.restoreApplication(ctx, &app, existing[app.Name])
+		rs.record(inventory.KindApplication, app.Name, changed, err)
+	}
+	return nil
+}
+
+// restoreApplication creates the application if needed, uploads the
+// versions the target is missing and syncs the publish state
+func (rs *restorer) restoreApplication(ctx context.Context, app *api.Application, target *api.Application) (bool, error) {
+	pkgs := rs.manifest.packagesFor(app.Name)
+	changed := false
+	if target == nil {
+		if len(pkgs) == 0 {
+			return false, errs.NewErrNotFound(fmt.Sprintf("package for application %s", app.Name))
+		}
+		op, err := rs.c.CreateApplicationWithArgs(&client.ApplicationCreateArgs{
+			PackagePath: rs.packagePath(&pkgs[0]),
+			VM:          app.VM,
+		})
+		if err != nil {
+			return false, err
+		}
+		if err := op.Wait(ctx); err != nil {
+			return false, err
+		}
+		changed = true
+		apps, err := rs.c.ListApplicationsWithFilters([]string{"name=" + app.Name})
+		if err != nil {
+			return changed, err
+		}
+		for n := range apps {
+			if apps[n].Name == app.Name {
+				target = &apps[n]
+			}
+		}
+		if target == nil {
+			return changed, errs.NewErrNotFound(fmt.Sprintf("application %s after creating it", app.Name))
+		}
+		if target, err = client.WaitForApplicationVersions(ctx, rs.c, target.ID, 1, rs.args.PollInterval); err != nil {
+			return changed, err
+		}
+	}
+	rs.appIDs[app.ID] = target.ID
+
+	for n := len(target.Versions); n < len(pkgs); n++ {
+		op, err := rs.c.UpdateApplicationWithPackage(target.ID, rs.packagePath(&pkgs[n]), nil)
+		if err != nil {
+			return changed, err
+		}
+		if err := op.Wait(ctx); err != nil {
+			return changed, err
+		}
+		changed = true
+		if target, err = client.WaitForApplicationVersions(ctx, rs.c, target.ID, n+1, rs.args.PollInterval); err != nil {
+			return changed, err
+		}
+	}
+
+	versions := append([]api.ApplicationVersion{}, target.Versions...)
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	for n := 0; n < len(versions) && n < len(pkgs); n++ {
+		if versions[n].Published == pkgs[n].Published {
+			continue
+		}
+		var op restclient.Operation
+		var err error
+		if pkgs[n].Published {
+			op, err = rs.c.PublishApplicationVersion(target.ID, versions[n].Number)
+		} else {
+			op, err = rs.c.RevokeApplicationVersion(target.ID, versions[n].Number)
+		}
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return changed, fmt.Errorf("failed to change publish state of version %d: %w", versions[n].Number, err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+func (rs *restorer) packagePath(p *Package) string {
+	return filepath.Join(rs.dir, filepath.FromSlash(p.Path))
+}
+
+func (rs *restorer) restoreGroups(ctx context.Context) error {
+	if len(rs.snap.Groups) == 0 {
+		return nil
+	}
+	groups, err := rs.c.ListAuthGroupsWithFilters(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list groups: %w", err)
+	}
+	existing := map[string]*api.AuthGroup{}
+	for n := range groups {
+		existing[groups[n].Name] = &groups[n]
+	}
+	for _, g := range rs.snap.Groups {
+		// Built-in groups can't be changed
+		if g.Immutable {
+			continue
+		}
+		changed, err := rs.restoreGroup(ctx, &g, existing[g.Name])
+		rs.record(inventory.KindGroup, g.Name, changed, err)
+	}
+	return nil
+}
+
+func (rs *restorer) restoreGroup(ctx context.Context, g *api.AuthGroup, target *api.AuthGroup) (bool, error) {
+	changed := false
+	if target == nil {
+		op, err := rs.c.CreateAuthGroup(&g.AuthGroupPost)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return false, err
+		}
+		changed = true
+		target = &api.AuthGroup{AuthGroupPost: g.AuthGroupPost}
+	}
+	if target.Description != g.Description {
+		op, err := rs.c.UpdateAuthGroupDescription(g.Name, g.Description)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	permissions := rs.remapPermissions(g.Permissions)
+	if !samePermissions(target.Permissions, permissions) {
+		op, err := rs.c.SetPermissionsForGroup(g.Name, permissions)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// remapPermissions points permissions on applications and images to the IDs
+// these have in the target cluster
+func (rs *restorer) remapPermissions(permissions []api.Permission) []api.Permission {
+	remapped := make([]api.Permission, 0, len(permissions))
+	for _, p := range permissions {
+		if typ, id, ok := strings.Cut(p.Resource, ":"); ok {
+			var ids map[string]string
+			switch typ {
+			case "application":
+				ids = rs.appIDs
+			case "image":
+				ids = rs.imageIDs
+			}
+			if newID, ok := ids[id]; ok {
+				p.Resource = typ + ":" + newID
+			}
+		}
+		remapped = append(remapped, p)
+	}
+	return remapped
+}
+
+func samePermissions(a, b []api.Permission) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[api.Permission]int{}
+	for _, p := range a {
+		set[p]++
+	}
+	for _, p := range b {
+		if set[p] == 0 {
+			return false
+		}
+		set[p]--
+	}
+	return true
+}
+
+func (rs *restorer) restoreCertificates(ctx context.Context) error {
+	if len(rs.snap.Certificates) == 0 {
+		return nil
+	}
+	certs, err := rs.c.ListCertificates()
+	if err != nil {
+		return fmt.Errorf("failed to list certificates: %w", err)
+	}
+	existing := map[string]bool{}
+	for _, cert := range certs {
+		existing[cert.Fingerprint] = true
+	}
+	for _, cert := range rs.snap.Certificates {
+		if existing[cert.Fingerprint] {
+			rs.record(inventory.KindCertificate, cert.Fingerprint, false, nil)
+			continue
+		}
+		_, err := rs.c.AddCertificate(&restapi.CertificatesPost{Certificate: cert.Certificate})
+		rs.record(inventory.KindCertificate, cert.Fingerprint, true, err)
+	}
+	return nil
+}
+
+func (rs *restorer) restoreIdentities(ctx context.Context) error {
+	if len(rs.snap.Identities) == 0 {
+		return nil
+	}
+	// Listed after the certificates were restored as these may have
+	// brought some identities with them
+	identities, err := rs.c.ListIdentitiesWithFilters(nil)
+	if err != nil {
+		return fmt.Errorf("failed to list identities: %w", err)
+	}
+	for _, id := range rs.snap.Identities {
+		changed, err := rs.restoreIdentity(ctx, &id, findIdentity(identities, &id))
+		rs.record(inventory.KindIdentity, identityName(&id), changed, err)
+	}
+	return nil
+}
+
+func (rs *restorer) restoreIdentity(ctx context.Context, id *api.Identity, target *api.Identity) (bool, error) {
+	changed := false
+	if target == nil {
+		op, err := rs.c.CreateIdentity(&api.IdentityPost{
+			AuthenticationMethod: id.AuthenticationMethod,
+			Name:                 id.Name,
+			Email:                id.Email,
+			Certificate:          id.Certificate,
+		})
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return false, err
+		}
+		changed = true
+		identities, err := rs.c.ListIdentitiesWithFilters(nil)
+		if err != nil {
+			return changed, err
+		}
+		if target = findIdentity(identities, id); target == nil {
+			return changed, errs.NewErrNotFound(fmt.Sprintf("identity %s after creating it", identityName(id)))
+		}
+	}
+	if !sameStrings(target.Groups, id.Groups) {
+		op, err := rs.c.SetGroupsForIdentity(target.ID, id.Groups)
+		if err == nil {
+			err = op.Wait(ctx)
+		}
+		if err != nil {
+			return changed, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// findIdentity finds the identity matching one from the backup. IDs differ
+// between clusters, so identities are matched by fingerprint if they have
+// one and by name and email otherwise.
+func findIdentity(identities []api.Identity, id *api.Identity) *api.Identity {
+	for n := range identities {
+		i := &identities[n]
+		if i.AuthenticationMethod != id.AuthenticationMethod {
+			continue
+		}
+		if len(id.Fingerprint) > 0 {
+			if i.Fingerprint == id.Fingerprint {
+				return i
+			}
+			continue
+		}
+		if i.Name == id.Name && i.Email == id.Email {
+			return i
+		}
+	}
+	return nil
+}
+
+func identityName(id *api.Identity) string {
+	for _, name := range []string{id.Name, id.Email, id.Fingerprint} {
+		if len(name) > 0 {
+			return name
+		}
+	}
+	return id.ID
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
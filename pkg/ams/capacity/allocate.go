@@ -0,0 +1,88 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package capacity
+
+import (
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// Allocate commits the resources of one more instance with the given
+// requirements on a node of the cluster. It fails if the instance doesn't
+// fit on the node.
+func (c *Cluster) Allocate(node string, req *Requirements) error {
+	nc := c.Node(node)
+	if nc == nil {
+		return errs.NewErrNotFound("node")
+	}
+	if err := nc.Allocate(req); err != nil {
+		return err
+	}
+	c.sum()
+	return nil
+}
+
+// Allocate commits the resources of one more instance with the given
+// requirements on the node. It fails if the instance doesn't fit.
+func (n *NodeCapacity) Allocate(req *Requirements) error {
+	nf, err := n.Fit(req)
+	if err != nil {
+		return err
+	}
+	if nf.Count == 0 {
+		return errs.NewErrFailed("allocation on node " + n.Node + ": " + string(nf.Reason))
+	}
+	need, err := requiredResources(&req.Resources)
+	if err != nil {
+		return err
+	}
+	if req.Resources.NoDiskReserve {
+		need.DiskSize = 0
+	}
+
+	if need.GPUSlots > 0 && len(n.GPUs) > 0 {
+		allocateSlots(n.GPUs, need.GPUSlots, req.Resources.GPUType)
+	}
+	if need.VPUSlots > 0 && len(n.VPUs) > 0 {
+		allocateSlots(n.VPUs, need.VPUSlots, "")
+	}
+
+	n.Instances++
+	n.Committed = n.Committed.Add(need)
+	n.Free = n.Total.Sub(n.Committed)
+	return nil
+}
+
+// allocateSlots takes the slots from the least used device with enough free
+// slots, which is the device AMS picks as well
+func allocateSlots(devices []DeviceCapacity, slots int, deviceType string) {
+	var best *DeviceCapacity
+	for n := range devices {
+		d := &devices[n]
+		if d.Free < slots || (len(deviceType) > 0 && d.Type != deviceType) {
+			continue
+		}
+		if best == nil || d.Committed < best.Committed {
+			best = d
+		}
+	}
+	if best != nil {
+		best.Committed += slots
+		best.Free -= slots
+	}
+}
@@ -0,0 +1,237 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package capacity computes how much of the resources of an AMS cluster is
+// committed to instances and how many more instances fit into it.
+package capacity
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+)
+
+// Resources describes an amount of resources on a node or in the cluster
+type Resources struct {
+	CPUs     int   `json:"cpus" yaml:"cpus"`
+	Memory   int64 `json:"memory" yaml:"memory"`
+	DiskSize int64 `json:"disk_size" yaml:"disk_size"`
+	GPUSlots int   `json:"gpu_slots" yaml:"gpu_slots"`
+	VPUSlots int   `json:"vpu_slots" yaml:"vpu_slots"`
+}
+
+// Add returns the sum of both resources
+func (r Resources) Add(o Resources) Resources {
+	return Resources{
+		CPUs:     r.CPUs + o.CPUs,
+		Memory:   r.Memory + o.Memory,
+		DiskSize: r.DiskSize + o.DiskSize,
+		GPUSlots: r.GPUSlots + o.GPUSlots,
+		VPUSlots: r.VPUSlots + o.VPUSlots,
+	}
+}
+
+// Sub returns the difference of both resources. Resources never become
+// negative.
+func (r Resources) Sub(o Resources) Resources {
+	return Resources{
+		CPUs:     max(r.CPUs-o.CPUs, 0),
+		Memory:   max(r.Memory-o.Memory, 0),
+		DiskSize: max(r.DiskSize-o.DiskSize, 0),
+		GPUSlots: max(r.GPUSlots-o.GPUSlots, 0),
+		VPUSlots: max(r.VPUSlots-o.VPUSlots, 0),
+	}
+}
+
+// DeviceCapacity describes the slots of a single GPU or VPU
+type DeviceCapacity struct {
+	ID        uint64 `json:"id" yaml:"id"`
+	Type      string `json:"type" yaml:"type"`
+	Slots     int    `json:"slots" yaml:"slots"`
+	Committed int    `json:"committed" yaml:"committed"`
+	Free      int    `json:"free" yaml:"free"`
+}
+
+// NodeCapacity describes the resources of a single node
+type NodeCapacity struct {
+	Node          string             `json:"node" yaml:"node"`
+	Architecture  string             `json:"architecture" yaml:"architecture"`
+	Tags          []string           `json:"tags" yaml:"tags"`
+	InstanceTypes []api.InstanceType `json:"instance_types" yaml:"instance_types"`
+	Status        api.NodeStatus     `json:"status" yaml:"status"`
+	Unschedulable bool               `json:"unschedulable" yaml:"unschedulable"`
+	// Total resources of the node with the allocation rates applied
+	Total     Resources `json:"total" yaml:"total"`
+	Committed Resources `json:"committed" yaml:"committed"`
+	Free      Resources `json:"free" yaml:"free"`
+	// Instances is the number of instances on the node
+	Instances int              `json:"instances" yaml:"instances"`
+	GPUs      []DeviceCapacity `json:"gpus,omitempty" yaml:"gpus,omitempty"`
+	VPUs      []DeviceCapacity `json:"vpus,omitempty" yaml:"vpus,omitempty"`
+}
+
+// Schedulable returns whether new instances can be placed on the node
+func (n *NodeCapacity) Schedulable() bool {
+	return n.Status == api.NodeStatusOnline && !n.Unschedulable
+}
+
+// Cluster describes the resources of all nodes of a cluster
+type Cluster struct {
+	// Nodes sorted by name
+	Nodes []NodeCapacity `json:"nodes" yaml:"nodes"`
+	// Total, Committed and Free sum up the resources of all schedulable nodes
+	Total     Resources `json:"total" yaml:"total"`
+	Committed Resources `json:"committed" yaml:"committed"`
+	Free      Resources `json:"free" yaml:"free"`
+}
+
+// Node returns the capacity of the node with the given name or nil if it
+// doesn't exist
+func (c *Cluster) Node(name string) *NodeCapacity {
+	for n := range c.Nodes {
+		if c.Nodes[n].Node == name {
+			return &c.Nodes[n]
+		}
+	}
+	return nil
+}
+
+// Compute calculates the capacity of a cluster from its nodes and the
+// instances running on them. Deleted nodes and instances are ignored.
+func Compute(nodes []api.Node, instances []api.Instance) (*Cluster, error) {
+	byNode := map[string][]*api.Instance{}
+	for n := range instances {
+		inst := &instances[n]
+		if len(inst.Node) == 0 || inst.StatusCode == api.InstanceStatusDeleted {
+			continue
+		}
+		byNode[inst.Node] = append(byNode[inst.Node], inst)
+	}
+
+	c := &Cluster{}
+	for n := range nodes {
+		if nodes[n].StatusCode == api.NodeStatusDeleted {
+			continue
+		}
+		nc, err := computeNode(&nodes[n], byNode[nodes[n].Name])
+		if err != nil {
+			return nil, err
+		}
+		c.Nodes = append(c.Nodes, *nc)
+	}
+	sort.Slice(c.Nodes, func(i, j int) bool {
+		return c.Nodes[i].Node < c.Nodes[j].Node
+	})
+	c.sum()
+	return c, nil
+}
+
+// sum recalculates the cluster wide resources from the nodes
+func (c *Cluster) sum() {
+	c.Total, c.Committed, c.Free = Resources{}, Resources{}, Resources{}
+	for n := range c.Nodes {
+		nc := &c.Nodes[n]
+		if !nc.Schedulable() {
+			continue
+		}
+		c.Total = c.Total.Add(nc.Total)
+		c.Committed = c.Committed.Add(nc.Committed)
+		c.Free = c.Free.Add(nc.Free)
+	}
+}
+
+func computeNode(node *api.Node, instances []*api.Instance) (*NodeCapacity, error) {
+	memory, err := shared.ParseByteSizeString(node.Memory)
+	if err != nil {
+		return nil, fmt.Errorf("invalid memory of node %s: %w", node.Name, err)
+	}
+	disk, err := shared.ParseByteSizeString(node.DiskSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid disk size of node %s: %w", node.Name, err)
+	}
+
+	nc := &NodeCapacity{
+		Node:          node.Name,
+		Architecture:  node.Architecture,
+		Tags:          node.Tags,
+		InstanceTypes: node.InstanceTypes,
+		Status:        node.StatusCode,
+		Unschedulable: node.Unschedulable || node.DEPRECATEDUnschedulable,
+		Instances:     len(instances),
+		Total: Resources{
+			CPUs:     int(float64(node.CPUs) * allocationRate(node.CPUAllocationRate)),
+			Memory:   int64(float64(memory) * allocationRate(node.MemoryAllocationRate)),
+			DiskSize: disk,
+			GPUSlots: node.GPUSlots,
+		},
+	}
+
+	for _, inst := range instances {
+		r := inst.Resources
+		nc.Committed.CPUs += r.CPUs
+		nc.Committed.Memory += r.Memory
+		if !r.NoDiskReserve {
+			nc.Committed.DiskSize += r.DiskSize
+		}
+		nc.Committed.GPUSlots += max(r.GPUSlots, 0)
+		nc.Committed.VPUSlots += max(r.VPUSlots, 0)
+	}
+
+	// Per device allocations are more accurate than the instance resources
+	// so prefer them when the node reports its devices
+	if len(node.GPUs) > 0 {
+		nc.Total.GPUSlots, nc.Committed.GPUSlots = 0, 0
+		for _, gpu := range node.GPUs {
+			d := DeviceCapacity{ID: gpu.ID, Type: gpu.Type, Slots: gpu.Slots}
+			for _, a := range gpu.Allocations {
+				d.Committed += a.Slots
+			}
+			d.Free = max(d.Slots-d.Committed, 0)
+			nc.GPUs = append(nc.GPUs, d)
+			nc.Total.GPUSlots += d.Slots
+			nc.Committed.GPUSlots += d.Committed
+		}
+	}
+	if len(node.VPUs) > 0 {
+		nc.Committed.VPUSlots = 0
+		for _, vpu := range node.VPUs {
+			d := DeviceCapacity{ID: vpu.ID, Type: vpu.Type, Slots: vpu.Slots}
+			for _, a := range vpu.Allocations {
+				d.Committed += a.Slots
+			}
+			d.Free = max(d.Slots-d.Committed, 0)
+			nc.VPUs = append(nc.VPUs, d)
+			nc.Total.VPUSlots += d.Slots
+			nc.Committed.VPUSlots += d.Committed
+		}
+	}
+
+	nc.Free = nc.Total.Sub(nc.Committed)
+	return nc, nil
+}
+
+// allocationRate returns the over-commit factor of a resource. Nodes which
+// don't report one don't over-commit.
+func allocationRate(rate float32) float64 {
+	if rate <= 0 {
+		return 1
+	}
+	return float64(rate)
+}
@@ -0,0 +1,340 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package capacity
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+)
+
+// DeviceKind distinguishes GPUs from VPUs
+type DeviceKind string
+
+const (
+	// DeviceKindGPU denotes a GPU
+	DeviceKindGPU DeviceKind = "gpu"
+	// DeviceKindVPU denotes a VPU
+	DeviceKindVPU DeviceKind = "vpu"
+)
+
+// DeviceAllocation describes the slots of a device taken by an instance
+type DeviceAllocation struct {
+	Instance     string `json:"instance" yaml:"instance"`
+	Application  string `json:"application,omitempty" yaml:"application,omitempty"`
+	Slots        int    `json:"slots" yaml:"slots"`
+	EncoderSlots int    `json:"encoder_slots,omitempty" yaml:"encoder_slots,omitempty"`
+	// Devices lists the IDs of all devices the allocation spans
+	Devices []uint64 `json:"devices" yaml:"devices"`
+	// Orphaned is set if the instance doesn't exist anymore
+	Orphaned bool `json:"orphaned" yaml:"orphaned"`
+}
+
+// DeviceUsage describes the utilisation of a single GPU or VPU
+type DeviceUsage struct {
+	Node             string             `json:"node" yaml:"node"`
+	Kind             DeviceKind         `json:"kind" yaml:"kind"`
+	ID               uint64             `json:"id" yaml:"id"`
+	Type             string             `json:"type" yaml:"type"`
+	Model            string             `json:"model,omitempty" yaml:"model,omitempty"`
+	PCIAddress       string             `json:"pci_address,omitempty" yaml:"pci_address,omitempty"`
+	NUMANode         uint64             `json:"numa_node" yaml:"numa_node"`
+	Slots            int                `json:"slots" yaml:"slots"`
+	UsedSlots        int                `json:"used_slots" yaml:"used_slots"`
+	EncoderSlots     int                `json:"encoder_slots,omitempty" yaml:"encoder_slots,omitempty"`
+	UsedEncoderSlots int                `json:"used_encoder_slots,omitempty" yaml:"used_encoder_slots,omitempty"`
+	Allocations      []DeviceAllocation `json:"allocations" yaml:"allocations"`
+}
+
+// Utilization returns the share of used slots of the device
+func (d *DeviceUsage) Utilization() float64 {
+	if d.Slots <= 0 {
+		return 0
+	}
+	return float64(d.UsedSlots) / float64(d.Slots)
+}
+
+// NUMAUsage sums up the slots of all devices of a kind on one NUMA node
+type NUMAUsage struct {
+	Node      string     `json:"node" yaml:"node"`
+	Kind      DeviceKind `json:"kind" yaml:"kind"`
+	NUMANode  uint64     `json:"numa_node" yaml:"numa_node"`
+	Devices   int        `json:"devices" yaml:"devices"`
+	Slots     int        `json:"slots" yaml:"slots"`
+	UsedSlots int        `json:"used_slots" yaml:"used_slots"`
+}
+
+// Fragmentation describes how scattered the free slots of the devices of a
+// kind on a node are. As an instance takes all its slots from a single
+// device, free slots spread over many devices can't be used by instances
+// needing many slots.
+type Fragmentation struct {
+	Node      string     `json:"node" yaml:"node"`
+	Kind      DeviceKind `json:"kind" yaml:"kind"`
+	FreeSlots int        `json:"free_slots" yaml:"free_slots"`
+	// LargestFree is the largest number of free slots on a single device
+	LargestFree int `json:"largest_free" yaml:"largest_free"`
+	// Ratio is 0 if all free slots are on a single device and approaches
+	// 1 the more they are scattered
+	Ratio float64 `json:"ratio" yaml:"ratio"`
+}
+
+// DeviceReport describes how the GPUs and VPUs of a cluster are allocated
+type DeviceReport struct {
+	Devices       []DeviceUsage   `json:"devices" yaml:"devices"`
+	NUMA          []NUMAUsage     `json:"numa" yaml:"numa"`
+	Fragmentation []Fragmentation `json:"fragmentation" yaml:"fragmentation"`
+	// Orphaned lists allocations of instances which don't exist anymore
+	Orphaned []DeviceUsage `json:"orphaned" yaml:"orphaned"`
+}
+
+// NewDeviceReport cross-references the GPU and VPU allocations of the nodes
+// with the existing instances
+func NewDeviceReport(nodes []api.Node, instances []api.Instance) *DeviceReport {
+	existing := map[string]*api.Instance{}
+	for n := range instances {
+		if instances[n].StatusCode != api.InstanceStatusDeleted {
+			existing[instances[n].ID] = &instances[n]
+		}
+	}
+	allocation := func(id string, slots, encoderSlots int, devices []uint64) DeviceAllocation {
+		a := DeviceAllocation{Instance: id, Slots: slots, EncoderSlots: encoderSlots, Devices: devices}
+		if inst, ok := existing[id]; ok {
+			a.Application = inst.AppName
+		} else {
+			a.Orphaned = true
+		}
+		return a
+	}
+
+	r := &DeviceReport{}
+	for _, node := range nodes {
+		if node.StatusCode == api.NodeStatusDeleted {
+			continue
+		}
+		for _, gpu := range node.GPUs {
+			d := DeviceUsage{
+				Node:         node.Name,
+				Kind:         DeviceKindGPU,
+				ID:           gpu.ID,
+				Type:         gpu.Type,
+				PCIAddress:   gpu.PCIAddress,
+				NUMANode:     gpu.NUMANode,
+				Slots:        gpu.Slots,
+				EncoderSlots: gpu.EncoderSlots,
+			}
+			for id, a := range gpu.Allocations {
+				d.UsedSlots += a.Slots
+				d.UsedEncoderSlots += a.EncoderSlots
+				d.Allocations = append(d.Allocations, allocation(id, a.Slots, a.EncoderSlots, a.GPUs))
+			}
+			r.addDevice(d)
+		}
+		for _, vpu := range node.VPUs {
+			d := DeviceUsage{
+				Node:     node.Name,
+				Kind:     DeviceKindVPU,
+				ID:       vpu.ID,
+				Type:     vpu.Type,
+				Model:    vpu.Model,
+				NUMANode: vpu.NUMANode,
+				Slots:    vpu.Slots,
+			}
+			for id, a := range vpu.Allocations {
+				d.UsedSlots += a.Slots
+				d.Allocations = append(d.Allocations, allocation(id, a.Slots, 0, a.IDs))
+			}
+			r.addDevice(d)
+		}
+	}
+	r.summarize()
+	return r
+}
+
+func (r *DeviceReport) addDevice(d DeviceUsage) {
+	sort.Slice(d.Allocations, func(i, j int) bool {
+		return d.Allocations[i].Instance < d.Allocations[j].Instance
+	})
+	var orphaned []DeviceAllocation
+	for _, a := range d.Allocations {
+		if a.Orphaned {
+			orphaned = append(orphaned, a)
+		}
+	}
+	if len(orphaned) > 0 {
+		o := d
+		o.Allocations = orphaned
+		r.Orphaned = append(r.Orphaned, o)
+	}
+	r.Devices = append(r.Devices, d)
+}
+
+// summarize calculates the NUMA and fragmentation statistics from the devices
+func (r *DeviceReport) summarize() {
+	type key struct {
+		node string
+		kind DeviceKind
+	}
+	type numaKey struct {
+		key
+		numa uint64
+	}
+	numa := map[numaKey]*NUMAUsage{}
+	frag := map[key]*Fragmentation{}
+	for _, d := range r.Devices {
+		k := key{d.Node, d.Kind}
+		nk := numaKey{k, d.NUMANode}
+		if numa[nk] == nil {
+			numa[nk] = &NUMAUsage{Node: d.Node, Kind: d.Kind, NUMANode: d.NUMANode}
+		}
+		numa[nk].Devices++
+		numa[nk].Slots += d.Slots
+		numa[nk].UsedSlots += d.UsedSlots
+
+		if frag[k] == nil {
+			frag[k] = &Fragmentation{Node: d.Node, Kind: d.Kind}
+		}
+		free := max(d.Slots-d.UsedSlots, 0)
+		frag[k].FreeSlots += free
+		frag[k].LargestFree = max(frag[k].LargestFree, free)
+	}
+	for _, n := range numa {
+		r.NUMA = append(r.NUMA, *n)
+	}
+	for _, f := range frag {
+		if f.FreeSlots > 0 {
+			f.Ratio = 1 - float64(f.LargestFree)/float64(f.FreeSlots)
+		}
+		r.Fragmentation = append(r.Fragmentation, *f)
+	}
+
+	sort.SliceStable(r.Devices, func(i, j int) bool {
+		a, b := r.Devices[i], r.Devices[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.ID < b.ID
+	})
+	sort.Slice(r.NUMA, func(i, j int) bool {
+		a, b := r.NUMA[i], r.NUMA[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.NUMANode < b.NUMANode
+	})
+	sort.Slice(r.Fragmentation, func(i, j int) bool {
+		a, b := r.Fragmentation[i], r.Fragmentation[j]
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.Kind < b.Kind
+	})
+}
+
+// WriteTable writes the report as human readable tables
+func (r *DeviceReport) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tKIND\tID\tTYPE\tNUMA\tSLOTS\tUSED\tENCODER\tUTILIZATION\tINSTANCES")
+	for _, d := range r.Devices {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%d\t%d\t%s\t%.0f%%\t%s\n",
+			d.Node, d.Kind, d.ID, d.Type, d.NUMANode, d.Slots, d.UsedSlots,
+			encoderColumn(&d), d.Utilization()*100, instancesColumn(d.Allocations))
+	}
+
+	fmt.Fprintln(tw, "\nNODE\tKIND\tNUMA\tDEVICES\tSLOTS\tUSED")
+	for _, n := range r.NUMA {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\n", n.Node, n.Kind, n.NUMANode, n.Devices, n.Slots, n.UsedSlots)
+	}
+
+	fmt.Fprintln(tw, "\nNODE\tKIND\tFREE\tLARGEST FREE\tFRAGMENTATION")
+	for _, f := range r.Fragmentation {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.2f\n", f.Node, f.Kind, f.FreeSlots, f.LargestFree, f.Ratio)
+	}
+
+	if len(r.Orphaned) > 0 {
+		fmt.Fprintln(tw, "\nORPHANED ALLOCATIONS")
+		fmt.Fprintln(tw, "NODE\tKIND\tID\tINSTANCE\tSLOTS")
+		for _, d := range r.Orphaned {
+			for _, a := range d.Allocations {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\n", d.Node, d.Kind, d.ID, a.Instance, a.Slots)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// WriteCSV writes one record per allocation. Devices without allocations
+// are written as a single record with empty instance columns.
+func (r *DeviceReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"node", "kind", "id", "type", "numa_node", "slots", "used_slots",
+		"encoder_slots", "used_encoder_slots", "instance", "application",
+		"instance_slots", "instance_encoder_slots", "orphaned",
+	})
+	for _, d := range r.Devices {
+		device := []string{
+			d.Node, string(d.Kind), strconv.FormatUint(d.ID, 10), d.Type,
+			strconv.FormatUint(d.NUMANode, 10), strconv.Itoa(d.Slots), strconv.Itoa(d.UsedSlots),
+			strconv.Itoa(d.EncoderSlots), strconv.Itoa(d.UsedEncoderSlots),
+		}
+		if len(d.Allocations) == 0 {
+			cw.Write(append(device, "", "", "", "", ""))
+			continue
+		}
+		for _, a := range d.Allocations {
+			cw.Write(append(device[:len(device):len(device)],
+				a.Instance, a.Application, strconv.Itoa(a.Slots),
+				strconv.Itoa(a.EncoderSlots), strconv.FormatBool(a.Orphaned)))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func encoderColumn(d *DeviceUsage) string {
+	if d.Kind != DeviceKindGPU {
+		return "-"
+	}
+	return fmt.Sprintf("%d/%d", d.UsedEncoderSlots, d.EncoderSlots)
+}
+
+func instancesColumn(allocations []DeviceAllocation) string {
+	ids := make([]string, 0, len(allocations))
+	for _, a := range allocations {
+		id := a.Instance
+		if a.Orphaned {
+			id += " (orphaned)"
+		}
+		ids = append(ids, id)
+	}
+	return strings.Join(ids, ",")
+}
@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package capacity
+
+import (
+	"reflect"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+)
+
+// testGPU returns a GPU with one allocation per instance taking the given
+// number of slots
+func testGPU(id, numa uint64, slots int, allocations map[string]int) api.NodeGPU {
+	gpu := api.NodeGPU{ID: id, NUMANode: numa, Slots: slots, Allocations: map[string]api.NodeGPUAllocation{}}
+	for inst, s := range allocations {
+		gpu.Allocations[inst] = api.NodeGPUAllocation{GPUs: []uint64{id}, Slots: s}
+	}
+	return gpu
+}
+
+func testVPU(id, numa uint64, slots int, allocations map[string]int) api.NodeVPU {
+	vpu := api.NodeVPU{ID: id, NUMANode: numa, Slots: slots, Allocations: map[string]api.NodeVPUAllocation{}}
+	for inst, s := range allocations {
+		vpu.Allocations[inst] = api.NodeVPUAllocation{IDs: []uint64{id}, Slots: s}
+	}
+	return vpu
+}
+
+func TestDeviceReportOrphans(t *testing.T) {
+	instances := []api.Instance{
+		{ID: "inst0", AppName: "game", StatusCode: api.InstanceStatusRunning},
+		{ID: "inst1", AppName: "game", StatusCode: api.InstanceStatusDeleted},
+	}
+	tests := []struct {
+		name        string
+		node        api.Node
+		allocations []DeviceAllocation
+		orphaned    []string
+	}{
+		{
+			name: "existing instance",
+			node: api.Node{Name: "lxd0", GPUs: []api.NodeGPU{testGPU(0, 0, 10, map[string]int{"inst0": 2})}},
+			allocations: []DeviceAllocation{
+				{Instance: "inst0", Application: "game", Slots: 2, Devices: []uint64{0}},
+			},
+		},
+		{
+			name: "missing instance",
+			node: api.Node{Name: "lxd0", GPUs: []api.NodeGPU{testGPU(0, 0, 10, map[string]int{"inst0": 2, "inst9": 3})}},
+			allocations: []DeviceAllocation{
+				{Instance: "inst0", Application: "game", Slots: 2, Devices: []uint64{0}},
+				{Instance: "inst9", Slots: 3, Devices: []uint64{0}, Orphaned: true},
+			},
+			orphaned: []string{"inst9"},
+		},
+		{
+			name: "deleted instance",
+			node: api.Node{Name: "lxd0", VPUs: []api.NodeVPU{testVPU(0, 0, 4, map[string]int{"inst1": 1})}},
+			allocations: []DeviceAllocation{
+				{Instance: "inst1", Slots: 1, Devices: []uint64{0}, Orphaned: true},
+			},
+			orphaned: []string{"inst1"},
+		},
+		{
+			name: "deleted node",
+			node: api.Node{
+				Name:       "lxd0",
+				StatusCode: api.NodeStatusDeleted,
+				GPUs:       []api.NodeGPU{testGPU(0, 0, 10, map[string]int{"inst9": 3})},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewDeviceReport([]api.Node{test.node}, instances)
+			var allocations []DeviceAllocation
+			for _, d := range r.Devices {
+				allocations = append(allocations, d.Allocations...)
+			}
+			if !reflect.DeepEqual(allocations, test.allocations) {
+				t.Fatalf("expected allocations %+v, got %+v", test.allocations, allocations)
+			}
+			var orphaned []string
+			for _, d := range r.Orphaned {
+				for _, a := range d.Allocations {
+					orphaned = append(orphaned, a.Instance)
+				}
+			}
+			if !reflect.DeepEqual(orphaned, test.orphaned) {
+				t.Fatalf("expected orphaned %v, got %v", test.orphaned, orphaned)
+			}
+		})
+	}
+}
+
+func TestDeviceReportFragmentation(t *testing.T) {
+	tests := []struct {
+		name          string
+		gpus          []api.NodeGPU
+		fragmentation Fragmentation
+	}{
+		{
+			name:          "single device",
+			gpus:          []api.NodeGPU{testGPU(0, 0, 10, map[string]int{"inst0": 4})},
+			fragmentation: Fragmentation{FreeSlots: 6, LargestFree: 6, Ratio: 0},
+		},
+		{
+			name: "two devices",
+			gpus: []api.NodeGPU{
+				testGPU(0, 0, 10, map[string]int{"inst0": 8}),
+				testGPU(1, 0, 10, map[string]int{"inst1": 4}),
+			},
+			fragmentation: Fragmentation{FreeSlots: 8, LargestFree: 6, Ratio: 0.25},
+		},
+		{
+			name: "evenly scattered",
+			gpus: []api.NodeGPU{
+				testGPU(0, 0, 4, map[string]int{"inst0": 2}),
+				testGPU(1, 0, 4, map[string]int{"inst1": 2}),
+				testGPU(2, 1, 4, map[string]int{"inst2": 2}),
+				testGPU(3, 1, 4, map[string]int{"inst3": 2}),
+			},
+			fragmentation: Fragmentation{FreeSlots: 8, LargestFree: 2, Ratio: 0.75},
+		},
+		{
+			name: "all used",
+			gpus: []api.NodeGPU{
+				testGPU(0, 0, 10, map[string]int{"inst0": 10}),
+				testGPU(1, 0, 10, map[string]int{"inst1": 10}),
+			},
+			fragmentation: Fragmentation{FreeSlots: 0, LargestFree: 0, Ratio: 0},
+		},
+		{
+			name: "over-allocated device",
+			gpus: []api.NodeGPU{
+				testGPU(0, 0, 10, map[string]int{"inst0": 8, "inst1": 4}),
+				testGPU(1, 0, 10, map[string]int{"inst2": 6}),
+			},
+			fragmentation: Fragmentation{FreeSlots: 4, LargestFree: 4, Ratio: 0},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewDeviceReport([]api.Node{{Name: "lxd0", GPUs: test.gpus}}, nil)
+			expected := test.fragmentation
+			expected.Node, expected.Kind = "lxd0", DeviceKindGPU
+			if len(r.Fragmentation) != 1 || r.Fragmentation[0] != expected {
+				t.Fatalf("expected %+v, got %+v", expected, r.Fragmentation)
+			}
+		})
+	}
+}
+
+func TestDeviceReportNUMA(t *testing.T) {
+	nodes := []api.Node{
+		{
+			Name: "lxd1",
+			GPUs: []api.NodeGPU{testGPU(0, 0, 10, map[string]int{"inst0": 3})},
+		},
+		{
+			Name: "lxd0",
+			GPUs: []api.NodeGPU{
+				testGPU(0, 0, 10, map[string]int{"inst1": 2}),
+				testGPU(1, 1, 10, map[string]int{"inst2": 5, "inst3": 1}),
+				testGPU(2, 1, 8, nil),
+			},
+			VPUs: []api.NodeVPU{
+				testVPU(0, 0, 4, map[string]int{"inst1": 1}),
+				testVPU(1, 0, 4, map[string]int{"inst2": 2}),
+			},
+		},
+	}
+	expected := []NUMAUsage{
+		{Node: "lxd0", Kind: DeviceKindGPU, NUMANode: 0, Devices: 1, Slots: 10, UsedSlots: 2},
+		{Node: "lxd0", Kind: DeviceKindGPU, NUMANode: 1, Devices: 2, Slots: 18, UsedSlots: 6},
+		{Node: "lxd0", Kind: DeviceKindVPU, NUMANode: 0, Devices: 2, Slots: 8, UsedSlots: 3},
+		{Node: "lxd1", Kind: DeviceKindGPU, NUMANode: 0, Devices: 1, Slots: 10, UsedSlots: 3},
+	}
+	r := NewDeviceReport(nodes, nil)
+	if !reflect.DeepEqual(r.NUMA, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, r.NUMA)
+	}
+}
@@ -0,0 +1,300 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package capacity
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/constants"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+var instanceTypeRegexp = regexp.MustCompile(constants.InstanceTypePattern)
+
+// instanceTypes lists the resources of the instance types AMS provides
+var instanceTypes = map[string]api.ApplicationResources{
+	"a2.3":  {CPUs: 2, Memory: "3GB", DiskSize: "3GB"},
+	"a4.3":  {CPUs: 4, Memory: "3GB", DiskSize: "3GB"},
+	"a8.3":  {CPUs: 8, Memory: "3GB", DiskSize: "3GB"},
+	"a10.3": {CPUs: 10, Memory: "3GB", DiskSize: "3GB"},
+	"g2.3":  {CPUs: 2, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1},
+	"g4.3":  {CPUs: 4, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1},
+	"g8.3":  {CPUs: 8, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1},
+	"g10.3": {CPUs: 10, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1},
+}
+
+// Reason explains why an instance doesn't fit on a node
+type Reason string
+
+const (
+	// ReasonUnschedulable is given for nodes which are not online or cordoned
+	ReasonUnschedulable Reason = "unschedulable"
+	// ReasonNodeSelector is given for nodes missing a tag of the node selector
+	ReasonNodeSelector Reason = "node-selector"
+	// ReasonInstanceType is given for nodes not supporting the instance type
+	ReasonInstanceType Reason = "instance-type"
+	// ReasonArchitecture is given for nodes with a different architecture
+	ReasonArchitecture Reason = "architecture"
+	// ReasonCPUs is given for nodes without enough free CPUs
+	ReasonCPUs Reason = "cpus"
+	// ReasonMemory is given for nodes without enough free memory
+	ReasonMemory Reason = "memory"
+	// ReasonDiskSize is given for nodes without enough free disk space
+	ReasonDiskSize Reason = "disk-size"
+	// ReasonGPUSlots is given for nodes without enough free GPU slots
+	ReasonGPUSlots Reason = "gpu-slots"
+	// ReasonVPUSlots is given for nodes without enough free VPU slots
+	ReasonVPUSlots Reason = "vpu-slots"
+)
+
+// Requirements describes what a single instance needs from a node
+type Requirements struct {
+	Resources api.ApplicationResources
+	// NodeSelector lists the tags a node must have
+	NodeSelector []string
+	// InstanceType the node must support. Any type is accepted if empty.
+	InstanceType api.InstanceType
+	// Architecture the node must have. Any architecture is accepted if empty.
+	Architecture string
+}
+
+// RequirementsFromApplication returns the requirements of instances
+// launched from the given application. Applications without explicit
+// resources get the resources of their instance type.
+func RequirementsFromApplication(app *api.Application) *Requirements {
+	resources := app.Resources
+	if !hasResources(&resources) && len(app.InstanceType) > 0 {
+		if r, err := ResourcesFromInstanceType(app.InstanceType); err == nil {
+			resources = r
+		}
+	}
+	req := &Requirements{
+		Resources:    resources,
+		NodeSelector: app.NodeSelector,
+		InstanceType: api.InstanceTypeContainer,
+		Architecture: abiToArchitecture(app.ABI),
+	}
+	if app.VM {
+		req.InstanceType = api.InstanceTypeVM
+	}
+	return req
+}
+
+// ResourcesFromInstanceType returns the resources of an instance type like
+// a2.3. Only the instance types AMS provides are known, an ErrNotSupported
+// is returned for any other type.
+func ResourcesFromInstanceType(instanceType string) (api.ApplicationResources, error) {
+	if !instanceTypeRegexp.MatchString(instanceType) {
+		return api.ApplicationResources{}, errs.NewErrInvalidFormat(instanceType)
+	}
+	r, ok := instanceTypes[instanceType]
+	if !ok {
+		return api.ApplicationResources{}, errs.NewErrNotSupported(fmt.Sprintf("instance type %q", instanceType))
+	}
+	return r, nil
+}
+
+// hasResources checks whether any resource is set explicitly
+func hasResources(r *api.ApplicationResources) bool {
+	return r.CPUs > 0 || len(r.Memory) > 0 || len(r.DiskSize) > 0 || r.GPUSlots > 0 || r.VPUSlots > 0
+}
+
+// NodeFit describes how many instances fit on a single node
+type NodeFit struct {
+	Node  string `json:"node" yaml:"node"`
+	Count int    `json:"count" yaml:"count"`
+	// Reason explains why no further instance fits on the node
+	Reason Reason `json:"reason" yaml:"reason"`
+}
+
+// Fit describes how many instances fit into the cluster
+type Fit struct {
+	Count int       `json:"count" yaml:"count"`
+	Nodes []NodeFit `json:"nodes" yaml:"nodes"`
+}
+
+// Fit returns how many more instances with the given requirements fit into
+// the cluster
+func (c *Cluster) Fit(req *Requirements) (*Fit, error) {
+	f := &Fit{}
+	for n := range c.Nodes {
+		nf, err := c.Nodes[n].Fit(req)
+		if err != nil {
+			return nil, err
+		}
+		f.Count += nf.Count
+		f.Nodes = append(f.Nodes, nf)
+	}
+	return f, nil
+}
+
+// Fit returns how many more instances with the given requirements fit on
+// the node
+func (n *NodeCapacity) Fit(req *Requirements) (NodeFit, error) {
+	if req == nil {
+		return NodeFit{}, errs.NewInvalidArgument("requirements")
+	}
+	need, err := requiredResources(&req.Resources)
+	if err != nil {
+		return NodeFit{}, err
+	}
+
+	nf := NodeFit{Node: n.Node}
+	if reason := n.accepts(req); len(reason) > 0 {
+		nf.Reason = reason
+		return nf, nil
+	}
+
+	nf.Count = math.MaxInt
+	limit := func(free, required int64, reason Reason) {
+		if required <= 0 {
+			return
+		}
+		if count := int(free / required); count <= nf.Count {
+			nf.Count = count
+			nf.Reason = reason
+		}
+	}
+	limit(int64(n.Free.CPUs), int64(need.CPUs), ReasonCPUs)
+	limit(n.Free.Memory, need.Memory, ReasonMemory)
+	if !req.Resources.NoDiskReserve {
+		limit(n.Free.DiskSize, need.DiskSize, ReasonDiskSize)
+	}
+	limit(int64(n.gpuFit(need.GPUSlots, req.Resources.GPUType)), 1, ReasonGPUSlots)
+	limit(int64(n.vpuFit(need.VPUSlots)), 1, ReasonVPUSlots)
+	return nf, nil
+}
+
+// accepts checks whether the node is a candidate for the requirements at all
+func (n *NodeCapacity) accepts(req *Requirements) Reason {
+	if !n.Schedulable() {
+		return ReasonUnschedulable
+	}
+	if !hasAll(n.Tags, req.NodeSelector) {
+		return ReasonNodeSelector
+	}
+	if len(req.InstanceType) > 0 && !supportsInstanceType(n.InstanceTypes, req.InstanceType) {
+		return ReasonInstanceType
+	}
+	if len(req.Architecture) > 0 && len(n.Architecture) > 0 && n.Architecture != req.Architecture {
+		return ReasonArchitecture
+	}
+	return ""
+}
+
+// gpuFit returns how many instances needing the given GPU slots fit on the
+// node. All slots of an instance are taken from a single GPU.
+func (n *NodeCapacity) gpuFit(slots int, gpuType string) int {
+	if slots <= 0 {
+		return math.MaxInt
+	}
+	if len(n.GPUs) == 0 {
+		if len(gpuType) > 0 {
+			return 0
+		}
+		return n.Free.GPUSlots / slots
+	}
+	count := 0
+	for _, gpu := range n.GPUs {
+		if len(gpuType) == 0 || gpu.Type == gpuType {
+			count += gpu.Free / slots
+		}
+	}
+	return count
+}
+
+// vpuFit returns how many instances needing the given VPU slots fit on the
+// node
+func (n *NodeCapacity) vpuFit(slots int) int {
+	if slots <= 0 {
+		return math.MaxInt
+	}
+	if len(n.VPUs) == 0 {
+		return n.Free.VPUSlots / slots
+	}
+	count := 0
+	for _, vpu := range n.VPUs {
+		count += vpu.Free / slots
+	}
+	return count
+}
+
+// requiredResources converts application resources into the resources taken
+// by a single instance
+func requiredResources(r *api.ApplicationResources) (Resources, error) {
+	memory, err := shared.ParseByteSizeString(r.Memory)
+	if err != nil {
+		return Resources{}, fmt.Errorf("invalid memory: %w", err)
+	}
+	disk, err := shared.ParseByteSizeString(r.DiskSize)
+	if err != nil {
+		return Resources{}, fmt.Errorf("invalid disk size: %w", err)
+	}
+	need := Resources{
+		CPUs:     r.CPUs,
+		Memory:   memory,
+		DiskSize: disk,
+		GPUSlots: max(r.GPUSlots, 0),
+		VPUSlots: max(r.VPUSlots, 0),
+	}
+	if need.CPUs <= 0 && need.Memory <= 0 && need.DiskSize <= 0 && need.GPUSlots == 0 && need.VPUSlots == 0 {
+		return Resources{}, errs.NewInvalidArgument("resources")
+	}
+	return need, nil
+}
+
+// hasAll checks whether all tags of the selector are present
+func hasAll(tags, selector []string) bool {
+	for _, s := range selector {
+		if !shared.StringInSlice(s, tags) {
+			return false
+		}
+	}
+	return true
+}
+
+// supportsInstanceType checks whether a node supports an instance type.
+// Nodes which don't report their instance types only run containers.
+func supportsInstanceType(types []api.InstanceType, t api.InstanceType) bool {
+	if len(types) == 0 {
+		return t == api.InstanceTypeContainer
+	}
+	for _, it := range types {
+		if it == t {
+			return true
+		}
+	}
+	return false
+}
+
+// abiToArchitecture maps an Android ABI to the architecture of the nodes
+// able to run it
+func abiToArchitecture(abi string) string {
+	switch abi {
+	case "arm64-v8a", "armeabi-v7a", "armeabi":
+		return "aarch64"
+	case "x86_64", "x86":
+		return "x86_64"
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package capacity
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+func TestResourcesFromInstanceType(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		resources    api.ApplicationResources
+		check        func(error) bool
+	}{
+		{instanceType: "a2.3", resources: api.ApplicationResources{CPUs: 2, Memory: "3GB", DiskSize: "3GB"}},
+		{instanceType: "a10.3", resources: api.ApplicationResources{CPUs: 10, Memory: "3GB", DiskSize: "3GB"}},
+		{instanceType: "g4.3", resources: api.ApplicationResources{CPUs: 4, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1}},
+		// Looks like an instance type but AMS doesn't provide it
+		{instanceType: "a3.7", check: errs.IsErrNotSupported},
+		{instanceType: "g16.32", check: errs.IsErrNotSupported},
+		{instanceType: "x2.3", check: func(err error) bool { return errors.As(err, &errs.ErrInvalidFormat{}) }},
+		{instanceType: "", check: func(err error) bool { return errors.As(err, &errs.ErrInvalidFormat{}) }},
+	}
+	for _, test := range tests {
+		t.Run(test.instanceType, func(t *testing.T) {
+			r, err := ResourcesFromInstanceType(test.instanceType)
+			if test.check != nil {
+				if !test.check(err) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r, test.resources) {
+				t.Fatalf("expected %+v, got %+v", test.resources, r)
+			}
+		})
+	}
+}
+
+func TestRequirementsFromApplication(t *testing.T) {
+	explicit := api.ApplicationResources{CPUs: 3, Memory: "4GB"}
+	tests := []struct {
+		name      string
+		app       api.Application
+		resources api.ApplicationResources
+	}{
+		{
+			name:      "instance type",
+			app:       api.Application{InstanceType: "g2.3"},
+			resources: api.ApplicationResources{CPUs: 2, Memory: "3GB", DiskSize: "3GB", GPUSlots: 1},
+		},
+		{
+			name:      "explicit resources",
+			app:       api.Application{InstanceType: "a2.3", Resources: explicit},
+			resources: explicit,
+		},
+		{
+			name: "unknown instance type",
+			app:  api.Application{InstanceType: "a3.7"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := RequirementsFromApplication(&test.app)
+			if !reflect.DeepEqual(req.Resources, test.resources) {
+				t.Fatalf("expected %+v, got %+v", test.resources, req.Resources)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"strconv"
 
 	api "github.com/anbox-cloud/ams-sdk/api/ams"
@@ -41,6 +42,21 @@ func (c *clientImpl) UpdateAddon(name, packagePath string, sentBytes chan float6
 	return c.upload("PATCH", client.APIPath("addons", name), nil, packagePath, details, sentBytes)
 }
 
+// AddAddonFromReader adds a new addon with the package read from the given source
+func (c *clientImpl) AddAddonFromReader(name string, src *UploadSource) (client.Operation, error) {
+	details := api.AddonsPost{Name: name}
+	return c.uploadFromReader(context.Background(), "POST", client.APIPath("addons"), nil, src, details)
+}
+
+// UpdateAddonFromReader updates an existing addon with the package read from the given source
+func (c *clientImpl) UpdateAddonFromReader(name string, src *UploadSource) (client.Operation, error) {
+	if len(name) == 0 {
+		return nil, errs.NewInvalidArgument("name")
+	}
+	details := api.AddonPatch{}
+	return c.uploadFromReader(context.Background(), "PATCH", client.APIPath("addons", name), nil, src, details)
+}
+
 // RetrieveAddon loads an addon from the connected AMS service
 func (c *clientImpl) RetrieveAddon(name string) (*api.Addon, string, error) {
 	if len(name) == 0 {
@@ -22,9 +22,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	api "github.com/anbox-cloud/ams-sdk/api/ams"
 	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
@@ -36,6 +38,9 @@ type ApplicationCreateArgs struct {
 	PackagePath   string
 	VM            bool
 	SentBytesChan chan float64
+	// Source, if set, provides the package as stream and takes precedence
+	// over PackagePath and SentBytesChan
+	Source *UploadSource
 }
 
 // CreateApplication creates a new application
@@ -46,6 +51,11 @@ func (c *clientImpl) CreateApplication(packagePath string, sentBytes chan float6
 	})
 }
 
+// CreateApplicationFromReader creates a new application with the package read from the given source
+func (c *clientImpl) CreateApplicationFromReader(src *UploadSource) (client.Operation, error) {
+	return c.CreateApplicationWithArgs(&ApplicationCreateArgs{Source: src})
+}
+
 // CreateApplicationWithArgs creates a new application based on the provided arguments
 func (c *clientImpl) CreateApplicationWithArgs(args *ApplicationCreateArgs) (client.Operation, error) {
 	hasVMSupport, err := c.HasExtension("vm_support")
@@ -58,6 +68,9 @@ func (c *clientImpl) CreateApplicationWithArgs(args *ApplicationCreateArgs) (cli
 	params := client.QueryParams{
 		"vm": strconv.FormatBool(args.VM),
 	}
+	if args.Source != nil {
+		return c.uploadFromReader(context.Background(), "POST", client.APIPath("applications"), params, args.Source, nil)
+	}
 	return c.upload("POST", client.APIPath("applications"), params,
 		args.PackagePath, nil, args.SentBytesChan)
 }
@@ -90,6 +103,14 @@ func (c *clientImpl) UpdateApplicationWithPackage(id, packagePath string, sentBy
 	return c.upload("PATCH", client.APIPath("applications", id), nil, packagePath, nil, sentBytes)
 }
 
+// UpdateApplicationFromReader updates an existing application with the package read from the given source
+func (c *clientImpl) UpdateApplicationFromReader(id string, src *UploadSource) (client.Operation, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	return c.uploadFromReader(context.Background(), "PATCH", client.APIPath("applications", id), nil, src, nil)
+}
+
 // UpdateApplication updates an existing application
 func (c *clientImpl) UpdateApplication(id string) (client.Operation, error) {
 	if len(id) == 0 {
@@ -177,6 +198,10 @@ func (c *clientImpl) DeleteApplications(ids []string, force bool) (client.Operat
 
 // ExportApplicationByVersion exports an existing application identified by its version
 func (c *clientImpl) ExportApplicationByVersion(id string, version int, downloader func(header *http.Header, body io.ReadCloser) error) error {
+	return c.exportApplication(context.Background(), id, version, nil, downloader)
+}
+
+func (c *clientImpl) exportApplication(ctx context.Context, id string, version int, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
 	if len(id) == 0 {
 		return errs.NewInvalidArgument("id")
 	}
@@ -191,7 +216,7 @@ func (c *clientImpl) ExportApplicationByVersion(id string, version int, download
 		return errs.NewErrNotSupported("api extension \"application_image_export\"")
 	}
 
-	return c.download(client.APIPath("applications", id, strconv.Itoa(version)), nil, nil, downloader)
+	return c.download(ctx, client.APIPath("applications", id, strconv.Itoa(version)), nil, header, downloader)
 }
 
 func (c *clientImpl) changeApplicationVersion(id string, version int, details *api.ApplicationVersionPatch) (client.Operation, error) {
@@ -236,3 +261,46 @@ func (c *clientImpl) DeleteApplicationVersion(id string, version int, force bool
 	op, _, err := c.QueryOperation("DELETE", client.APIPath("applications", id, strconv.Itoa(version)), nil, nil, bytes.NewReader(b), "")
 	return op, err
 }
+
+// WaitForApplicationVersions waits until the application with the given ID
+// has at least count versions and the latest one finished building. The
+// application is checked every interval. On failure no application is
+// returned.
+func WaitForApplicationVersions(ctx context.Context, c Client, id string, count int, interval time.Duration) (*api.Application, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	if interval <= 0 {
+		return nil, errs.NewInvalidArgument("interval")
+	}
+	for {
+		app, _, err := c.RetrieveApplicationByID(id)
+		if err != nil {
+			return nil, err
+		}
+		if len(app.Versions) >= count {
+			latest := latestApplicationVersion(app)
+			switch latest.StatusCode {
+			case api.ImageStatusActive:
+				return app, nil
+			case api.ImageStatusError:
+				return nil, errs.NewErrFailed(fmt.Sprintf("building version %d: %s", latest.Number, latest.ErrorMessage))
+			}
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func latestApplicationVersion(app *api.Application) *api.ApplicationVersion {
+	var latest *api.ApplicationVersion
+	for n := range app.Versions {
+		if latest == nil || app.Versions[n].Number > latest.Number {
+			latest = &app.Versions[n]
+		}
+	}
+	return latest
+}
@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// DiffApplicationVersion compares the package of an application version with
+// a local package, e.g. before the local package is uploaded as the next
+// version. The exported version is the old side of the diff.
+func (c *clientImpl) DiffApplicationVersion(ctx context.Context, id string, version int, packagePath string) (*packages.PackageDiff, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	if len(packagePath) == 0 {
+		return nil, errs.NewInvalidArgument("packagePath")
+	}
+
+	dir, err := os.MkdirTemp("", "ams-diff")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	exportPath := filepath.Join(dir, id+"-"+strconv.Itoa(version))
+	if err := c.ExportApplicationToFile(ctx, id, version, exportPath); err != nil {
+		return nil, err
+	}
+	return packages.DiffPackages(exportPath, packagePath)
+}
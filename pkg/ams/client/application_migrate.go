@@ -0,0 +1,427 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const defaultMigratePollInterval = 5 * time.Second
+
+// ApplicationMigrateArgs provides details on how to migrate an application
+// between clusters
+type ApplicationMigrateArgs struct {
+	// UseRegistry transfers the application through the application
+	// registry both clusters are connected to instead of streaming its
+	// packages. The registry decides which versions are transferred, so
+	// no versions can be selected.
+	UseRegistry bool
+	// PollInterval is how often the target application is checked while a
+	// version is being built. Defaults to 5 seconds.
+	PollInterval time.Duration
+	// Progress is called after each version was migrated, if set
+	Progress func(version int, err error)
+}
+
+// ApplicationMigrateResult describes an application migrated to another
+// cluster
+type ApplicationMigrateResult struct {
+	// ID of the application in the target cluster
+	ID string
+	// Versions maps the migrated versions to the numbers they got in the
+	// target cluster
+	Versions map[int]int
+}
+
+// MigrateApplication copies versions of an application from one cluster to
+// another. See MigrateApplicationWithArgs.
+func MigrateApplication(ctx context.Context, src, dst Client, id string, versions []int) (*ApplicationMigrateResult, error) {
+	return MigrateApplicationWithArgs(ctx, src, dst, id, versions, nil)
+}
+
+// MigrateApplicationWithArgs copies versions of an application from one
+// cluster to another. If no versions are given, all versions which built
+// successfully are copied. Each version is streamed from the source into
+// the target without intermediate files, creating the application in the
+// target if it doesn't exist yet and adding new versions otherwise. The
+// published state of every version is carried over.
+//
+// Versions the target application already has are not copied again. As the
+// target numbers versions on its own, they are matched by position: the
+// n-th selected version corresponds to the n-th version of the target. A
+// migration which was interrupted can thus be run again with the same
+// versions selected.
+//
+// The addons and parent images the application needs must exist in the
+// target cluster already; nothing is migrated if any of them is missing.
+func MigrateApplicationWithArgs(ctx context.Context, src, dst Client, id string, versions []int, args *ApplicationMigrateArgs) (*ApplicationMigrateResult, error) {
+	if src == nil {
+		return nil, errs.NewInvalidArgument("src")
+	}
+	if dst == nil {
+		return nil, errs.NewInvalidArgument("dst")
+	}
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	if args == nil {
+		args = &ApplicationMigrateArgs{}
+	}
+	if args.PollInterval == 0 {
+		args.PollInterval = defaultMigratePollInterval
+	}
+	if args.UseRegistry && len(versions) > 0 {
+		return nil, errs.NewInvalidArgument("versions")
+	}
+
+	app, _, err := src.RetrieveApplicationByID(id)
+	if err != nil {
+		return nil, err
+	}
+	selected, err := selectApplicationVersions(app, versions)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrationDependencies(src, dst, app, selected); err != nil {
+		return nil, err
+	}
+
+	m := &applicationMigrator{src: src, dst: dst, app: app, args: args}
+	if args.UseRegistry {
+		return m.migrateViaRegistry(ctx, selected)
+	}
+	return m.migrate(ctx, selected)
+}
+
+// selectApplicationVersions returns the requested versions ordered by
+// number, or all versions which can be exported if none were requested
+func selectApplicationVersions(app *api.Application, numbers []int) ([]api.ApplicationVersion, error) {
+	var selected []api.ApplicationVersion
+	if len(numbers) == 0 {
+		for _, v := range app.Versions {
+			if v.StatusCode != api.ImageStatusError {
+				selected = append(selected, v)
+			}
+		}
+	} else {
+		for _, n := range numbers {
+			found := false
+			for _, v := range app.Versions {
+				if v.Number == n && v.StatusCode != api.ImageStatusError {
+					selected = append(selected, v)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, errs.NewErrNotFound(fmt.Sprintf("version %d of application %s", n, app.Name))
+			}
+		}
+	}
+	if len(selected) == 0 {
+		return nil, errs.NewErrNotFound(fmt.Sprintf("versions of application %s", app.Name))
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Number < selected[j].Number })
+	return selected, nil
+}
+
+// checkMigrationDependencies verifies the target cluster has all addons and
+// parent images the selected versions need. Images are matched by name as
+// their IDs differ between clusters.
+func checkMigrationDependencies(src, dst Client, app *api.Application, versions []api.ApplicationVersion) error {
+	addons := append([]string{}, app.Addons...)
+	imageIDs := []string{}
+	if len(app.ParentImageID) > 0 {
+		imageIDs = append(imageIDs, app.ParentImageID)
+	}
+	for _, v := range versions {
+		for _, a := range v.Addons {
+			addons = append(addons, a.Name)
+		}
+		if len(v.ParentImageID) > 0 {
+			imageIDs = append(imageIDs, v.ParentImageID)
+		}
+	}
+
+	var missing []error
+	checked := map[string]bool{}
+	if len(addons) > 0 {
+		dstAddons, err := dst.ListAddons()
+		if err != nil {
+			return err
+		}
+		present := map[string]bool{}
+		for _, a := range dstAddons {
+			present[a.Name] = true
+		}
+		for _, name := range addons {
+			if !present[name] && !checked["addon/"+name] {
+				missing = append(missing, errs.NewErrNotFound(fmt.Sprintf("addon %s", name)))
+			}
+			checked["addon/"+name] = true
+		}
+	}
+
+	if len(imageIDs) > 0 {
+		srcImages, err := src.ListImages()
+		if err != nil {
+			return err
+		}
+		dstImages, err := dst.ListImages()
+		if err != nil {
+			return err
+		}
+		names := map[string]string{}
+		for _, img := range srcImages {
+			names[img.ID] = img.Name
+		}
+		present := map[string]bool{}
+		for _, img := range dstImages {
+			present[img.Name] = true
+		}
+		for _, id := range imageIDs {
+			name, ok := names[id]
+			if !ok {
+				name = id
+			}
+			if !present[name] && !checked["image/"+name] {
+				missing = append(missing, errs.NewErrNotFound(fmt.Sprintf("image %s", name)))
+			}
+			checked["image/"+name] = true
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("target cluster lacks dependencies of application %s: %w", app.Name, errors.Join(missing...))
+	}
+	return nil
+}
+
+type applicationMigrator struct {
+	src  Client
+	dst  Client
+	app  *api.Application
+	args *ApplicationMigrateArgs
+}
+
+func (m *applicationMigrator) migrate(ctx context.Context, versions []api.ApplicationVersion) (*ApplicationMigrateResult, error) {
+	target, err := findApplicationByName(m.dst, m.app.Name)
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplicationMigrateResult{Versions: map[int]int{}}
+	var existing []api.ApplicationVersion
+	if target != nil {
+		result.ID = target.ID
+		existing = append(existing, target.Versions...)
+		sort.Slice(existing, func(i, j int) bool { return existing[i].Number < existing[j].Number })
+	}
+	for n, v := range versions {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		var tv *api.ApplicationVersion
+		if n < len(existing) {
+			tv = &existing[n]
+		} else {
+			target, err = m.migrateVersion(ctx, target, &v)
+			if target != nil {
+				result.ID = target.ID
+			}
+			if err == nil {
+				tv = latestApplicationVersion(target)
+			}
+		}
+		if err == nil {
+			result.Versions[v.Number] = tv.Number
+			err = setApplicationVersionPublished(ctx, m.dst, target.ID, tv, v.Published)
+		}
+		if m.args.Progress != nil {
+			m.args.Progress(v.Number, err)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to migrate version %d of application %s: %w", v.Number, m.app.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// migrateVersion streams a single version into the target application,
+// creating it if it doesn't exist yet, and waits for the version to be built
+func (m *applicationMigrator) migrateVersion(ctx context.Context, target *api.Application, v *api.ApplicationVersion) (*api.Application, error) {
+	var op client.Operation
+	err := m.src.ExportApplicationByVersion(m.app.ID, v.Number, func(header *http.Header, body io.ReadCloser) error {
+		defer body.Close()
+		// Without a fingerprint the upload would spool the package to
+		// compute one
+		fingerprint := header.Get("X-AMS-Fingerprint")
+		if len(fingerprint) == 0 {
+			return fmt.Errorf("source cluster did not provide a fingerprint for the package")
+		}
+		size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		upload := &UploadSource{Reader: body, Size: size, Fingerprint: fingerprint}
+
+		var err error
+		if target == nil {
+			op, err = m.dst.CreateApplicationWithArgs(&ApplicationCreateArgs{VM: m.app.VM, Source: upload})
+		} else {
+			op, err = m.dst.UpdateApplicationFromReader(target.ID, upload)
+		}
+		return err
+	})
+	if err != nil {
+		return target, err
+	}
+	if err := op.Wait(ctx); err != nil {
+		return target, err
+	}
+
+	count := 1
+	if target != nil {
+		count = len(target.Versions) + 1
+	} else {
+		target, err = findApplicationByName(m.dst, m.app.Name)
+		if err != nil {
+			return nil, err
+		}
+		if target == nil {
+			return nil, errs.NewErrNotFound(fmt.Sprintf("application %s after creating it", m.app.Name))
+		}
+	}
+	app, err := WaitForApplicationVersions(ctx, m.dst, target.ID, count, m.args.PollInterval)
+	if err != nil {
+		return target, err
+	}
+	return app, nil
+}
+
+// migrateViaRegistry pushes the application to the registry and pulls it
+// into the target cluster. Versions keep their numbers in the registry.
+func (m *applicationMigrator) migrateViaRegistry(ctx context.Context, versions []api.ApplicationVersion) (*ApplicationMigrateResult, error) {
+	op, err := m.src.PushApplicationToRegistry(m.app.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err := op.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("failed to push application %s to the registry: %w", m.app.Name, err)
+	}
+
+	// The target cluster only sees the application once it synchronized
+	// with the registry
+	for {
+		apps, err := m.dst.ListApplicationsFromRegistry()
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, a := range apps {
+			if a.Name == m.app.Name {
+				found = true
+				break
+			}
+		}
+		if found {
+			break
+		}
+		select {
+		case <-time.After(m.args.PollInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	op, err = m.dst.PullApplicationFromRegistry(m.app.Name)
+	if err != nil {
+		return nil, err
+	}
+	if err := op.Wait(ctx); err != nil {
+		return nil, fmt.Errorf("failed to pull application %s from the registry: %w", m.app.Name, err)
+	}
+	target, err := findApplicationByName(m.dst, m.app.Name)
+	if err != nil {
+		return nil, err
+	}
+	if target == nil {
+		return nil, errs.NewErrNotFound(fmt.Sprintf("application %s after pulling it", m.app.Name))
+	}
+	if target, err = WaitForApplicationVersions(ctx, m.dst, target.ID, 1, m.args.PollInterval); err != nil {
+		return nil, err
+	}
+
+	result := &ApplicationMigrateResult{ID: target.ID, Versions: map[int]int{}}
+	for _, v := range versions {
+		for _, tv := range target.Versions {
+			if tv.Number != v.Number {
+				continue
+			}
+			err := setApplicationVersionPublished(ctx, m.dst, target.ID, &tv, v.Published)
+			if m.args.Progress != nil {
+				m.args.Progress(v.Number, err)
+			}
+			if err != nil {
+				return result, fmt.Errorf("failed to migrate version %d of application %s: %w", v.Number, m.app.Name, err)
+			}
+			result.Versions[v.Number] = tv.Number
+		}
+	}
+	return result, nil
+}
+
+// findApplicationByName returns the application with the given name or nil
+// if there is none
+func findApplicationByName(c Client, name string) (*api.Application, error) {
+	apps, err := c.ListApplicationsWithFilters([]string{"name=" + name})
+	if err != nil {
+		return nil, err
+	}
+	for n := range apps {
+		if apps[n].Name == name {
+			return &apps[n], nil
+		}
+	}
+	return nil, nil
+}
+
+func setApplicationVersionPublished(ctx context.Context, c Client, id string, v *api.ApplicationVersion, published bool) error {
+	if v.Published == published {
+		return nil
+	}
+	var op client.Operation
+	var err error
+	if published {
+		op, err = c.PublishApplicationVersion(id, v.Number)
+	} else {
+		op, err = c.RevokeApplicationVersion(id, v.Number)
+	}
+	if err != nil {
+		return err
+	}
+	return op.Wait(ctx)
+}
@@ -0,0 +1,262 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+)
+
+var migrateTestExtensions = []string{"application_image_export", "zip_archive_support"}
+
+func newMigrateTestClient(t *testing.T, s *amstest.Server) Client {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeTestApplicationPackage writes a package for the given version of an
+// application. extraManifest is appended to the manifest.
+func writeTestApplicationPackage(t *testing.T, name string, version int, extraManifest string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	files := map[string]string{
+		"manifest.yaml": fmt.Sprintf("name: %s\ninstance-type: a2.3\n%s", name, extraManifest),
+		"app.apk":       fmt.Sprintf("%s version %d", name, version),
+	}
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(t.TempDir(), fmt.Sprintf("%s-%d.zip", name, version))
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// addTestApplication creates an application with the given number of
+// versions and returns its ID
+func addTestApplication(t *testing.T, c Client, name string, versions int, extraManifest string) string {
+	t.Helper()
+	op, err := c.CreateApplication(writeTestApplicationPackage(t, name, 0, extraManifest), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	app, err := findApplicationByName(c, name)
+	if err != nil || app == nil {
+		t.Fatalf("failed to find application %s: %v", name, err)
+	}
+	for n := 1; n < versions; n++ {
+		op, err := c.UpdateApplicationWithPackage(app.ID, writeTestApplicationPackage(t, name, n, extraManifest), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := op.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return app.ID
+}
+
+func setPublished(t *testing.T, c Client, id string, version int, published bool) {
+	t.Helper()
+	op, err := c.PublishApplicationVersion(id, version)
+	if !published {
+		op, err = c.RevokeApplicationVersion(id, version)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := op.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkPublished(t *testing.T, c Client, id string, want ...bool) {
+	t.Helper()
+	app, _, err := c.RetrieveApplicationByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Versions) != len(want) {
+		t.Fatalf("expected %d versions, got %d", len(want), len(app.Versions))
+	}
+	for n, v := range app.Versions {
+		if v.Published != want[n] {
+			t.Errorf("version %d: expected published=%v, got %v", v.Number, want[n], v.Published)
+		}
+	}
+}
+
+func TestMigrateApplicationStreamsPackages(t *testing.T) {
+	src := amstest.NewServer(migrateTestExtensions...)
+	defer src.Close()
+	dst := amstest.NewServer(migrateTestExtensions...)
+	defer dst.Close()
+	srcClient, dstClient := newMigrateTestClient(t, src), newMigrateTestClient(t, dst)
+
+	id := addTestApplication(t, srcClient, "app", 3, "")
+	setPublished(t, srcClient, id, 1, true)
+
+	args := &ApplicationMigrateArgs{PollInterval: time.Millisecond}
+	res, err := MigrateApplicationWithArgs(context.Background(), srcClient, dstClient, id, nil, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Versions) != 3 {
+		t.Fatalf("expected 3 migrated versions, got %v", res.Versions)
+	}
+	checkPublished(t, dstClient, res.ID, false, true, false)
+
+	srcPayloads, dstPayloads := src.Payloads(), dst.Payloads()
+	if len(dstPayloads) != len(srcPayloads) {
+		t.Fatalf("expected %d uploaded packages, got %d", len(srcPayloads), len(dstPayloads))
+	}
+	for n, p := range dstPayloads {
+		if !bytes.Equal(p.Data, srcPayloads[n].Data) {
+			t.Errorf("package %d differs from the original one", n)
+		}
+		// The package is streamed with the length the source announced
+		// instead of being spooled first
+		if p.Size != int64(len(p.Data)) {
+			t.Errorf("package %d was uploaded with size %d instead of %d", n, p.Size, len(p.Data))
+		}
+	}
+}
+
+func TestMigrateApplicationMissingDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		setup    func(src *amstest.Server)
+		missing  string
+	}{
+		{
+			name:     "addon",
+			manifest: "addons: [ssh]\n",
+			setup: func(src *amstest.Server) {
+				src.SetAddons(api.Addon{Name: "ssh"})
+			},
+			missing: "addon ssh",
+		},
+		{
+			name:     "parent image",
+			manifest: "image: jammy:android13:amd64\n",
+			setup: func(src *amstest.Server) {
+				src.SetImages(api.Image{ID: "img0", Name: "jammy:android13:amd64"})
+			},
+			missing: "image jammy:android13:amd64",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := amstest.NewServer(migrateTestExtensions...)
+			defer src.Close()
+			dst := amstest.NewServer(migrateTestExtensions...)
+			defer dst.Close()
+			srcClient, dstClient := newMigrateTestClient(t, src), newMigrateTestClient(t, dst)
+
+			test.setup(src)
+			id := addTestApplication(t, srcClient, "app", 1, test.manifest)
+			_, err := MigrateApplication(context.Background(), srcClient, dstClient, id, nil)
+			if err == nil || !strings.Contains(err.Error(), test.missing) {
+				t.Fatalf("expected migration to fail because of missing %s, got %v", test.missing, err)
+			}
+			if n := src.Exports(); n != 0 {
+				t.Errorf("expected no export, got %d", n)
+			}
+			if n := len(dst.Payloads()); n != 0 {
+				t.Errorf("expected no upload, got %d", n)
+			}
+		})
+	}
+}
+
+func TestMigrateApplicationRerun(t *testing.T) {
+	src := amstest.NewServer(migrateTestExtensions...)
+	defer src.Close()
+	dst := amstest.NewServer(migrateTestExtensions...)
+	defer dst.Close()
+	srcClient, dstClient := newMigrateTestClient(t, src), newMigrateTestClient(t, dst)
+
+	id := addTestApplication(t, srcClient, "app", 3, "")
+	setPublished(t, srcClient, id, 1, true)
+
+	// An interrupted migration which only copied the first version
+	args := &ApplicationMigrateArgs{PollInterval: time.Millisecond}
+	if _, err := MigrateApplicationWithArgs(context.Background(), srcClient, dstClient, id, []int{0}, args); err != nil {
+		t.Fatal(err)
+	}
+	res, err := MigrateApplicationWithArgs(context.Background(), srcClient, dstClient, id, []int{0, 1, 2}, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dst.Payloads()); n != 3 {
+		t.Fatalf("expected 3 uploaded packages, got %d", n)
+	}
+	checkPublished(t, dstClient, res.ID, false, true, false)
+
+	// Running it again only syncs the published state
+	setPublished(t, srcClient, id, 1, false)
+	setPublished(t, srcClient, id, 2, true)
+	res, err = MigrateApplicationWithArgs(context.Background(), srcClient, dstClient, id, nil, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(dst.Payloads()); n != 3 {
+		t.Errorf("expected no further uploads, got %d", n-3)
+	}
+	for v, tv := range res.Versions {
+		if tv != v {
+			t.Errorf("expected version %d to map to %d, got %d", v, v, tv)
+		}
+	}
+	checkPublished(t, dstClient, res.ID, false, false, true)
+}
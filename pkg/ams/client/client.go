@@ -19,12 +19,15 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"io"
+	"net"
 	"net/http"
 	"time"
 
 	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
 	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
 	restclient "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
 )
@@ -43,6 +46,10 @@ type Client interface {
 	RemoveNode(name string, force, keepInCluster bool) (restclient.Operation, error)
 	RetrieveNodeByName(name string) (*api.Node, string, error)
 	UpdateNode(name string, details *api.NodePatch) (restclient.Operation, error)
+	DrainNode(ctx context.Context, name string, args *DrainArgs) (*DrainResult, error)
+	UncordonNode(ctx context.Context, name string) error
+	PreflightNodes(ctx context.Context, nodes []api.NodesPost, args *NodePreflightArgs) error
+	OnboardNodes(ctx context.Context, nodes []api.NodesPost, args *NodeOnboardArgs) (*NodeOnboardResult, error)
 
 	// Certificates
 	ListCertificates() ([]restapi.Certificate, error)
@@ -69,13 +76,23 @@ type Client interface {
 	DeleteInstanceByID(id string, force bool) (restclient.Operation, error)
 	DeleteInstances(ids []string, force bool) (restclient.Operation, error)
 	RetrieveInstanceLog(id, name string, downloader func(header *http.Header, body io.ReadCloser) error) error
+	RetrieveInstanceLogToFile(ctx context.Context, id, name, path string, args *DownloadArgs) error
 	ExecuteInstance(id string, details *api.InstanceExecPost, args *InstanceExecArgs) (restclient.Operation, error)
 	PublishInstance(instanceID string, name string, force bool, makeDefault bool) (restclient.Operation, error)
+	PushFile(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error
+	PushDirectory(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error
+	PullFile(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error
+	PullDirectory(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error
+	FollowInstanceLog(ctx context.Context, id string, source InstanceLogSource) (io.ReadCloser, error)
+	FollowInstanceLogs(ctx context.Context, ids []string, source InstanceLogSource, args *InstanceLogFollowArgs) (io.ReadCloser, error)
+	DialInstance(ctx context.Context, id string, port int) (net.Conn, error)
 
 	// Shares
 	CreateInstanceShare(id string, details *api.InstanceSharesPost) (*api.InstanceSharesPostResponse, error)
 	UpdateInstanceShareByID(instanceID, shareID string, details *api.InstanceSharePatch) error
 	DeleteInstanceShareByID(instanceID, shareID string) (restclient.Operation, error)
+	ListInstanceShares(id string) ([]api.InstanceShare, error)
+	ShareInstanceADB(ctx context.Context, id string, args *ADBShareArgs) (*ADBShare, error)
 
 	// Config
 	SetConfigItem(name, value string) error
@@ -84,7 +101,9 @@ type Client interface {
 	// Applications
 	CreateApplication(packagePath string, sentBytes chan float64) (restclient.Operation, error)
 	CreateApplicationWithArgs(args *ApplicationCreateArgs) (restclient.Operation, error)
+	CreateApplicationFromReader(src *UploadSource) (restclient.Operation, error)
 	UpdateApplicationWithPackage(id, packagePath string, sentBytes chan float64) (restclient.Operation, error)
+	UpdateApplicationFromReader(id string, src *UploadSource) (restclient.Operation, error)
 	UpdateApplicationWithDetails(id string, details api.ApplicationPatch) error
 	UpdateApplication(id string) (restclient.Operation, error)
 	ListApplications() ([]api.Application, error)
@@ -94,13 +113,18 @@ type Client interface {
 	DeleteApplicationByID(id string, force bool) (restclient.Operation, error)
 	DeleteApplications(ids []string, force bool) (restclient.Operation, error)
 	ExportApplicationByVersion(id string, version int, downloader func(header *http.Header, body io.ReadCloser) error) error
+	ExportApplicationToFile(ctx context.Context, id string, version int, path string) error
+	ExportApplicationToFileWithArgs(ctx context.Context, id string, version int, path string, args *DownloadArgs) error
+	DiffApplicationVersion(ctx context.Context, id string, version int, packagePath string) (*packages.PackageDiff, error)
 	PublishApplicationVersion(id string, version int) (restclient.Operation, error)
 	RevokeApplicationVersion(id string, version int) (restclient.Operation, error)
 	DeleteApplicationVersion(id string, version int, force bool) (restclient.Operation, error)
 
 	// Addons
 	AddAddon(name string, packagePath string, sentBytes chan float64) (restclient.Operation, error)
+	AddAddonFromReader(name string, src *UploadSource) (restclient.Operation, error)
 	UpdateAddon(name, packagePath string, sentBytes chan float64) (restclient.Operation, error)
+	UpdateAddonFromReader(name string, src *UploadSource) (restclient.Operation, error)
 	RetrieveAddon(name string) (*api.Addon, string, error)
 	DeleteAddon(name string) (restclient.Operation, error)
 	DeleteAddonVersion(name string, version int) (restclient.Operation, error)
@@ -109,7 +133,9 @@ type Client interface {
 	// Images
 	ListImages() ([]api.Image, error)
 	AddImage(name, packagePath string, isDefault bool, sentBytes chan float64) (restclient.Operation, error)
+	AddImageFromReader(name string, isDefault bool, src *UploadSource) (restclient.Operation, error)
 	UpdateImage(id, packagePath string, sentBytes chan float64) (restclient.Operation, error)
+	UpdateImageFromReader(id string, src *UploadSource) (restclient.Operation, error)
 	ImportImage(name, path string, isDefault bool) (restclient.Operation, error)
 	ImportImageByType(name, path string, imgType api.ImageType, isDefault bool) (restclient.Operation, error)
 	SetDefaultImage(id string) error
@@ -119,6 +145,8 @@ type Client interface {
 	RetrieveDefaultImage() (*api.Image, string, error)
 	TriggerImageSync(id string) error
 
+	SupportedPackageFormats() ([]packages.PackageFormat, error)
+
 	// Services
 	RetrieveServiceStatus() (*api.ServiceStatus, string, error)
 	HasExtension(name string) (bool, error)
@@ -19,41 +19,42 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 
-	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
 	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
-	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
 	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
 	"github.com/gorilla/websocket"
 )
 
 func (c *clientImpl) upload(httpOp, apiPath string, params client.QueryParams, packagePath string, details interface{}, sentBytes chan float64) (client.Operation, error) {
-	hasZipSupport, err := c.HasExtension("zip_archive_support")
+	format, err := detectPackageFormat(packagePath)
 	if err != nil {
 		return nil, err
 	}
-	if !hasZipSupport {
-		if packages.IsZip(packagePath) {
-			return nil, errs.NewErrNotSupported("api extension \"zip_archive_support\"")
-		}
-		pkgType, err := packages.DetectPackageType(packagePath)
-		if err != nil {
-			return nil, err
-		}
-		if pkgType == packages.PackageTypeZip {
-			return nil, errs.NewErrNotSupported("api extension \"zip_archive_support\"")
-		}
+	if err := c.checkPackageFormat(format); err != nil {
+		return nil, err
 	}
 	f, fingerprint, err := preparePayload(packagePath)
 	if err != nil {
 		return nil, err
 	}
+
+	u := &shared.BufferedReader{Reader: f, Size: sentBytes}
+	return c.sendPayload(context.Background(), httpOp, apiPath, params, u, -1, fingerprint, details)
+}
+
+// sendPayload sends the given payload together with the request details as
+// a single stream to AMS. If size is negative the length of the payload is
+// not announced to the server.
+func (c *clientImpl) sendPayload(ctx context.Context, httpOp, apiPath string, params client.QueryParams, payload io.Reader, size int64, fingerprint string, details interface{}) (client.Operation, error) {
+	var err error
 	request := []byte{}
 	if details != nil {
 		request, err = json.Marshal(details)
@@ -67,20 +68,29 @@ func (c *clientImpl) upload(httpOp, apiPath string, params client.QueryParams, p
 		"X-AMS-Fingerprint": []string{fingerprint},
 		"X-AMS-Request":     []string{string(request)},
 	}
+	if size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
 
-	u := &shared.BufferedReader{Reader: f, Size: sentBytes}
-
-	c.SetTransportTimeout(extendedTransportTimeout)
-	op, _, err := c.QueryOperation(httpOp, apiPath, params, header, u, "")
-	c.SetTransportTimeout(client.DefaultTransportTimeout)
+	op, _, err := c.QueryOperationContext(c.transferContext(ctx), httpOp, apiPath, params, header, payload, "")
 	return op, err
 }
 
-func (c *clientImpl) download(path string, params client.QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
-	c.SetTransportTimeout(extendedTransportTimeout)
-	err := c.DownloadFile(path, params, header, downloader)
-	c.SetTransportTimeout(client.DefaultTransportTimeout)
-	return err
+func (c *clientImpl) download(ctx context.Context, path string, params client.QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
+	return c.DownloadFileContext(c.transferContext(ctx), path, params, header, downloader)
+}
+
+// transferContext returns a context for requests transferring large payloads.
+// Such requests are not limited in their overall duration but only aborted
+// when they stop making progress. Timeouts already attached to ctx are kept.
+func (c *clientImpl) transferContext(ctx context.Context) context.Context {
+	if _, ok := client.TimeoutsFromContext(ctx); ok {
+		return ctx
+	}
+	timeouts := c.Timeouts()
+	timeouts.Idle = extendedTransportTimeout
+	timeouts.Overall = 0
+	return client.WithTimeouts(ctx, timeouts)
 }
 
 func convertFiltersToParams(filters []string) (client.QueryParams, error) {
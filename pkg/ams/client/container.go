@@ -20,6 +20,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -141,6 +142,10 @@ func (c *clientImpl) DeleteContainers(ids []string, force bool) (client.Operatio
 
 // RetrieveContainerLog retrieves a specific log file of a container
 func (c *clientImpl) RetrieveContainerLog(id, name string, downloader func(header *http.Header, body io.ReadCloser) error) error {
+	return c.retrieveContainerLog(context.Background(), id, name, nil, downloader)
+}
+
+func (c *clientImpl) retrieveContainerLog(ctx context.Context, id, name string, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
 	if len(id) == 0 {
 		return errs.NewInvalidArgument("id")
 	}
@@ -155,7 +160,7 @@ func (c *clientImpl) RetrieveContainerLog(id, name string, downloader func(heade
 		return errs.NewErrNotSupported("api extension \"container_logs\"")
 	}
 
-	return c.download(client.APIPath("containers", id, "logs", name), nil, nil, downloader)
+	return c.download(ctx, client.APIPath("containers", id, "logs", name), nil, header, downloader)
 }
 
 // ExecuteContainer requests that AMS opens a shell inside a container
@@ -0,0 +1,280 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const (
+	defaultDownloadRetries = 3
+	downloadRetryDelay     = time.Second
+)
+
+// DownloadProgress describes how far a download has progressed
+type DownloadProgress struct {
+	// ReceivedBytes is the number of bytes written to the target file so far
+	ReceivedBytes int64
+	// TotalBytes is the size of the download or -1 if it is not known
+	TotalBytes int64
+}
+
+// DownloadProgressFunc is called every time a part of a download was received
+type DownloadProgressFunc func(progress DownloadProgress)
+
+// DownloadArgs provides details on how to download a file from AMS
+type DownloadArgs struct {
+	// Mode of the target file. Defaults to 0644.
+	Mode os.FileMode
+	// Retries is the number of times an interrupted download is resumed
+	// before giving up. Only network errors and server errors are retried.
+	// Defaults to 3.
+	Retries int
+	// SkipVerification accepts downloads for which AMS doesn't provide a
+	// fingerprint. Such downloads can't be verified and are never resumed.
+	SkipVerification bool
+	// Progress is called as data is received, if set
+	Progress DownloadProgressFunc
+}
+
+// errDownloadChanged is returned when a resumed download refers to different
+// content than the previous attempt, which is retried from scratch
+var errDownloadChanged = errors.New("file changed on the server while downloading")
+
+// fetchFunc requests a file from AMS with the given additional header
+type fetchFunc func(header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error
+
+// fileDownload holds the state of a download which spans multiple requests
+type fileDownload struct {
+	file               *shared.AtomicFile
+	hasher             hash.Hash
+	received           int64
+	total              int64
+	fingerprint        string
+	requireFingerprint bool
+	progress           DownloadProgressFunc
+}
+
+// ExportApplicationToFile exports the given version of an application into a
+// file at the given path
+func (c *clientImpl) ExportApplicationToFile(ctx context.Context, id string, version int, path string) error {
+	return c.ExportApplicationToFileWithArgs(ctx, id, version, path, nil)
+}
+
+// ExportApplicationToFileWithArgs exports the given version of an application
+// into a file at the given path. Interrupted downloads are resumed and the
+// file is only created once its content was verified against the fingerprint
+// AMS reports for the application.
+func (c *clientImpl) ExportApplicationToFileWithArgs(ctx context.Context, id string, version int, path string, args *DownloadArgs) error {
+	fetch := func(header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
+		return c.exportApplication(ctx, id, version, header, downloader)
+	}
+	return c.downloadToFile(ctx, fetch, path, true, args)
+}
+
+// RetrieveInstanceLogToFile stores the given log file of an instance in a file
+// at the given path. Interrupted downloads are resumed.
+func (c *clientImpl) RetrieveInstanceLogToFile(ctx context.Context, id, name, path string, args *DownloadArgs) error {
+	fetch := func(header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
+		return c.retrieveInstanceLog(ctx, id, name, header, downloader)
+	}
+	return c.downloadToFile(ctx, fetch, path, false, args)
+}
+
+// downloadToFile downloads a file from AMS into the given path. If the
+// download is interrupted it is resumed with a range request. The target file
+// is only created if the download succeeded and, if AMS provided one, the
+// content matches the fingerprint.
+func (c *clientImpl) downloadToFile(ctx context.Context, fetch fetchFunc, path string, requireFingerprint bool, args *DownloadArgs) error {
+	if len(path) == 0 {
+		return fmt.Errorf("no target path given")
+	}
+	if args == nil {
+		args = &DownloadArgs{}
+	}
+	mode := args.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	retries := args.Retries
+	if retries <= 0 {
+		retries = defaultDownloadRetries
+	}
+
+	f, err := shared.NewAtomicFile(path, mode)
+	if err != nil {
+		return err
+	}
+
+	d := &fileDownload{
+		file:               f,
+		hasher:             sha256.New(),
+		total:              -1,
+		requireFingerprint: requireFingerprint && !args.SkipVerification,
+		progress:           args.Progress,
+	}
+
+	for attempt := 0; ; attempt++ {
+		header := http.Header{}
+		if d.received > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", d.received))
+		}
+
+		err = fetch(header, d.receive)
+		if err == nil || ctx.Err() != nil || attempt >= retries || !isTransient(err) || !d.resumable() {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Duration(attempt+1) * downloadRetryDelay):
+		}
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = d.verify()
+	}
+	if err != nil {
+		f.Cancel()
+		return err
+	}
+
+	return f.Commit()
+}
+
+// receive is used as downloader for every request of the download
+func (d *fileDownload) receive(header *http.Header, body io.ReadCloser) error {
+	fingerprint := header.Get("X-AMS-Fingerprint")
+	if d.received > 0 {
+		if fingerprint != d.fingerprint {
+			// The file has changed since the download started so the
+			// next attempt has to start from scratch
+			if err := d.reset(); err != nil {
+				return err
+			}
+			return errDownloadChanged
+		}
+		if len(header.Get("Content-Range")) == 0 {
+			// The server ignored the range and sends the whole file again
+			if err := d.reset(); err != nil {
+				return err
+			}
+		}
+	}
+	d.fingerprint = fingerprint
+
+	if d.received == 0 {
+		d.total = -1
+		if length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			d.total = length
+		}
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := d.file.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			d.hasher.Write(buf[:n])
+			d.received += int64(n)
+			if d.progress != nil {
+				d.progress(DownloadProgress{ReceivedBytes: d.received, TotalBytes: d.total})
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.total >= 0 && d.received < d.total {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// isTransient reports whether a failed request may succeed when retried
+func isTransient(err error) bool {
+	if errors.Is(err, errDownloadChanged) {
+		return true
+	}
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	// A stalled transfer is cancelled by the idle timeout of the request
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// resumable reports whether a failed download can be continued
+func (d *fileDownload) resumable() bool {
+	// Without a fingerprint there is no way to tell if a resumed download
+	// still refers to the same content
+	return len(d.fingerprint) > 0 || d.received == 0
+}
+
+func (d *fileDownload) reset() error {
+	if _, err := d.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := d.file.Truncate(0); err != nil {
+		return err
+	}
+	d.hasher.Reset()
+	d.received = 0
+	return nil
+}
+
+func (d *fileDownload) verify() error {
+	if len(d.fingerprint) == 0 {
+		if d.requireFingerprint {
+			return fmt.Errorf("server did not provide a fingerprint for the download, set SkipVerification to accept it anyway")
+		}
+		return nil
+	}
+	fingerprint := fmt.Sprintf("%x", d.hasher.Sum(nil))
+	if !strings.EqualFold(fingerprint, d.fingerprint) {
+		return fmt.Errorf("fingerprint mismatch: expected %s, got %s", d.fingerprint, fingerprint)
+	}
+	return nil
+}
@@ -50,6 +50,15 @@ func (c *clientImpl) AddImage(name, packagePath string, isDefault bool, sentByte
 	return c.upload("POST", client.APIPath("images"), nil, packagePath, details, sentBytes)
 }
 
+// AddImageFromReader adds a new image with the payload read from the given source
+func (c *clientImpl) AddImageFromReader(name string, isDefault bool, src *UploadSource) (client.Operation, error) {
+	details := api.ImagesPost{
+		Name:    name,
+		Default: isDefault,
+	}
+	return c.uploadFromReader(context.Background(), "POST", client.APIPath("images"), nil, src, details)
+}
+
 // ImportImage imports a new image from the image server
 func (c *clientImpl) ImportImage(name, path string, isDefault bool) (client.Operation, error) {
 	return c.ImportImageByType(name, path, api.ImageTypeAny, isDefault)
@@ -81,6 +90,15 @@ func (c *clientImpl) UpdateImage(id, packagePath string, sentBytes chan float64)
 	return c.upload("PATCH", client.APIPath("images", id), nil, packagePath, details, sentBytes)
 }
 
+// UpdateImageFromReader updates an existing image with the payload read from the given source
+func (c *clientImpl) UpdateImageFromReader(id string, src *UploadSource) (client.Operation, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	details := api.ImagePatch{}
+	return c.uploadFromReader(context.Background(), "PATCH", client.APIPath("images", id), nil, src, details)
+}
+
 func (c *clientImpl) SetDefaultImage(id string) error {
 	d := new(bool)
 	*d = true
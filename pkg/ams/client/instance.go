@@ -20,12 +20,17 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"syscall"
 
 	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
 	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
 	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
 	"github.com/anbox-cloud/ams-sdk/pkg/network"
@@ -242,6 +247,10 @@ func (c *clientImpl) DeleteInstances(ids []string, force bool) (client.Operation
 
 // RetrieveInstanceLog retrieves a specific log file of an instance
 func (c *clientImpl) RetrieveInstanceLog(id, name string, downloader func(header *http.Header, body io.ReadCloser) error) error {
+	return c.retrieveInstanceLog(context.Background(), id, name, nil, downloader)
+}
+
+func (c *clientImpl) retrieveInstanceLog(ctx context.Context, id, name string, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
 	if len(id) == 0 {
 		return errs.NewInvalidArgument("id")
 	}
@@ -250,10 +259,10 @@ func (c *clientImpl) RetrieveInstanceLog(id, name string, downloader func(header
 	}
 
 	if !c.hasInstanceSupport {
-		return c.RetrieveContainerLog(id, name, downloader)
+		return c.retrieveContainerLog(ctx, id, name, header, downloader)
 	}
 
-	return c.download(client.APIPath("instances", id, "logs", name), nil, nil, downloader)
+	return c.download(ctx, client.APIPath("instances", id, "logs", name), nil, header, downloader)
 }
 
 // ExecuteInstance requests that AMS opens a shell inside an instance
@@ -410,6 +419,81 @@ func (c *clientImpl) ExecuteInstance(id string, details *api.InstanceExecPost, a
 	return op, nil
 }
 
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// executeInstanceCommand runs the given command non-interactively inside an
+// instance, attaches the given streams to it and waits until it has finished.
+// An error is returned when the command exits with a non-zero exit code.
+func (c *clientImpl) executeInstanceCommand(ctx context.Context, id string, command []string, stdin io.Reader, stdout io.Writer) error {
+	var stdinCloser io.ReadCloser
+	if stdin != nil {
+		stdinCloser = io.NopCloser(shared.NewCancelableReader(ctx, stdin))
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	stderr := &bytes.Buffer{}
+
+	finished := make(chan struct{})
+	defer close(finished)
+
+	// When the context is cancelled before the command has finished we ask
+	// the process inside the instance to terminate
+	control := func(conn *websocket.Conn) {
+		defer conn.Close()
+		select {
+		case <-ctx.Done():
+		case <-finished:
+		}
+		if ctx.Err() != nil {
+			conn.WriteJSON(api.InstanceExecControl{
+				Command: "signal",
+				Signal:  int(syscall.SIGTERM),
+			})
+		}
+	}
+
+	dataDone := make(chan bool)
+	op, err := c.ExecuteInstance(id, &api.InstanceExecPost{
+		Command:     command,
+		Environment: map[string]string{},
+	}, &InstanceExecArgs{
+		Stdin:    stdinCloser,
+		Stdout:   nopWriteCloser{stdout},
+		Stderr:   nopWriteCloser{stderr},
+		Control:  control,
+		DataDone: dataDone,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := op.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	select {
+	case <-dataDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	ret, ok := op.Get().Metadata["return"].(float64)
+	if ok && ret != 0 {
+		msg := strings.TrimSpace(stderr.String())
+		return fmt.Errorf("command %q exited with code %d: %s", strings.Join(command, " "), int(ret), msg)
+	}
+
+	return nil
+}
+
 // PublishInstance publishes an instance as an image
 func (c *clientImpl) PublishInstance(instanceID string, name string, force bool, makeDefault bool) (client.Operation, error) {
 	if !c.hasInstancePublishSupport {
@@ -0,0 +1,409 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// FileTransferArgs provides additional options for transferring files between
+// the local host and an instance
+type FileTransferArgs struct {
+	// Progress receives the number of bytes of file content transferred, in
+	// the same way the sentBytes channel reports progress for uploads
+	Progress chan float64
+}
+
+// PushFile copies a single local file into an instance. Mode and owner of the
+// source file are preserved and the SHA-256 checksum of the file is verified
+// on both ends after the transfer.
+func (c *clientImpl) PushFile(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error {
+	return c.pushFiles(ctx, id, sourcePath, targetPath, false, args)
+}
+
+// PushDirectory copies a local directory recursively into an instance
+func (c *clientImpl) PushDirectory(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error {
+	return c.pushFiles(ctx, id, sourcePath, targetPath, true, args)
+}
+
+// PullFile copies a single file from an instance to the local host. Mode and
+// owner of the remote file are preserved where possible and the SHA-256
+// checksum of the file is verified on both ends after the transfer.
+func (c *clientImpl) PullFile(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error {
+	return c.pullFiles(ctx, id, sourcePath, targetPath, false, args)
+}
+
+// PullDirectory copies a directory recursively from an instance to the local host
+func (c *clientImpl) PullDirectory(ctx context.Context, id, sourcePath, targetPath string, args *FileTransferArgs) error {
+	return c.pullFiles(ctx, id, sourcePath, targetPath, true, args)
+}
+
+func validateTransferPaths(id, sourcePath, targetPath string) error {
+	if len(id) == 0 {
+		return errs.NewInvalidArgument("id")
+	}
+	if len(sourcePath) == 0 {
+		return errs.NewInvalidArgument("source path")
+	}
+	if len(targetPath) == 0 {
+		return errs.NewInvalidArgument("target path")
+	}
+	return nil
+}
+
+func (c *clientImpl) pushFiles(ctx context.Context, id, sourcePath, targetPath string, recursive bool, args *FileTransferArgs) error {
+	if err := validateTransferPaths(id, sourcePath, targetPath); err != nil {
+		return err
+	}
+	if args == nil {
+		args = &FileTransferArgs{}
+	}
+
+	fi, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	if recursive && !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", sourcePath)
+	}
+	if !recursive && !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", sourcePath)
+	}
+
+	targetPath = path.Clean(targetPath)
+	targetDir, targetName := path.Split(targetPath)
+	if len(targetDir) == 0 || !isValidTransferName(targetName) {
+		return errs.NewInvalidArgument("target path")
+	}
+
+	pr, pw := io.Pipe()
+	checksums := map[string]string{}
+	go func() {
+		pw.CloseWithError(writeTarStream(pw, sourcePath, targetName, checksums, args.Progress))
+	}()
+	defer pr.Close()
+
+	cmd := []string{"sh", "-c", `mkdir -p "$1" && tar -xpf - -C "$1"`, "sh", targetDir}
+	if err := c.executeInstanceCommand(ctx, id, cmd, pr, nil); err != nil {
+		return fmt.Errorf("failed to push %s: %w", sourcePath, err)
+	}
+
+	remote, err := c.remoteChecksums(ctx, id, targetDir, targetName)
+	if err != nil {
+		return err
+	}
+	return compareChecksums(checksums, remote)
+}
+
+func (c *clientImpl) pullFiles(ctx context.Context, id, sourcePath, targetPath string, recursive bool, args *FileTransferArgs) error {
+	if err := validateTransferPaths(id, sourcePath, targetPath); err != nil {
+		return err
+	}
+	if args == nil {
+		args = &FileTransferArgs{}
+	}
+
+	sourcePath = path.Clean(sourcePath)
+	sourceDir, sourceName := path.Split(sourcePath)
+	if len(sourceDir) == 0 || !isValidTransferName(sourceName) {
+		return errs.NewInvalidArgument("source path")
+	}
+
+	if !recursive {
+		// Ensure we only fetch a single regular file
+		test := []string{"test", "-f", sourcePath}
+		if err := c.executeInstanceCommand(ctx, id, test, nil, nil); err != nil {
+			return fmt.Errorf("%s is not a regular file in instance %s", sourcePath, id)
+		}
+	}
+
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	checksums := map[string]string{}
+	go func() {
+		err := readTarStream(pr, sourceName, targetPath, checksums, args.Progress)
+		// Drain whatever is left so the remote side is never blocked
+		io.Copy(io.Discard, pr)
+		errCh <- err
+	}()
+
+	cmd := []string{"tar", "-cf", "-", "-C", sourceDir, sourceName}
+	err := c.executeInstanceCommand(ctx, id, cmd, nil, pw)
+	pw.CloseWithError(err)
+	if extractErr := <-errCh; extractErr != nil && err == nil {
+		err = extractErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", sourcePath, err)
+	}
+
+	remote, err := c.remoteChecksums(ctx, id, sourceDir, sourceName)
+	if err != nil {
+		return err
+	}
+	return compareChecksums(remote, checksums)
+}
+
+// remoteChecksums computes the SHA-256 checksums of all regular files found
+// below the given path inside the instance. The returned map is indexed by the
+// path of each file relative to dir.
+func (c *clientImpl) remoteChecksums(ctx context.Context, id, dir, name string) (map[string]string, error) {
+	var out bytes.Buffer
+	cmd := []string{"sh", "-c", `cd "$1" && find "$2" -type f -exec sha256sum {} +`, "sh", dir, name}
+	if err := c.executeInstanceCommand(ctx, id, cmd, nil, &out); err != nil {
+		return nil, fmt.Errorf("failed to compute checksums inside instance: %w", err)
+	}
+
+	checksums := map[string]string{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		checksums[path.Clean(parts[1])] = parts[0]
+	}
+	return checksums, scanner.Err()
+}
+
+func isValidTransferName(name string) bool {
+	return len(name) > 0 && name != "." && name != ".."
+}
+
+func compareChecksums(expected, actual map[string]string) error {
+	for name, sum := range expected {
+		other, ok := actual[name]
+		if !ok {
+			return errs.NewErrNotFound(name)
+		}
+		if sum != other {
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, sum, other)
+		}
+	}
+	return nil
+}
+
+// writeTarStream writes the file or directory at sourcePath as a tar stream
+// with all entries placed below name. The checksum of each regular file is
+// recorded in checksums.
+func writeTarStream(w io.Writer, sourcePath, name string, checksums map[string]string, progress chan float64) error {
+	tw := tar.NewWriter(w)
+	root := filepath.Clean(sourcePath)
+
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		entryName := path.Join(name, filepath.ToSlash(rel))
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		}
+
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		mode, uid, gid := shared.GetOwnerMode(fi)
+		hdr.Name = entryName
+		hdr.Mode = int64(mode.Perm())
+		hdr.Uid = uid
+		hdr.Gid = gid
+		hdr.Uname = ""
+		hdr.Gname = ""
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		hasher := sha256.New()
+		r := &shared.BufferedReader{Reader: io.TeeReader(f, hasher), Size: progress}
+		if _, err := io.Copy(tw, r); err != nil {
+			return err
+		}
+		checksums[entryName] = fmt.Sprintf("%x", hasher.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// readTarStream extracts a tar stream whose entries are all placed below name
+// into targetPath. The checksum of each extracted regular file is recorded in
+// checksums and the number of file content bytes written is sent to progress.
+// Symlinks pointing outside of targetPath are rejected and no entry is ever
+// written through a symlink.
+func readTarStream(r io.Reader, name, targetPath string, checksums map[string]string, progress chan float64) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		entryName := path.Clean(hdr.Name)
+		if entryName != name && !strings.HasPrefix(entryName, name+"/") {
+			return fmt.Errorf("unexpected entry %q in tar stream", hdr.Name)
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(entryName, name), "/")
+		dest := filepath.Join(targetPath, filepath.FromSlash(rel))
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		if err := checkNoSymlinkParents(targetPath, dest); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if fi, err := os.Lstat(dest); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("refusing to extract directory %q over a symlink", hdr.Name)
+			}
+			if err := os.MkdirAll(dest, mode); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if !symlinkStaysInside(targetPath, dest, hdr.Linkname) {
+				return fmt.Errorf("symlink %q points outside of %s", hdr.Name, targetPath)
+			}
+			os.Remove(dest)
+			if err := os.Symlink(hdr.Linkname, dest); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			f, err := shared.NewAtomicFile(dest, mode)
+			if err != nil {
+				return err
+			}
+			hasher := sha256.New()
+			content := &shared.BufferedReader{Reader: tr, Size: progress}
+			if _, err := io.Copy(io.MultiWriter(f, hasher), content); err != nil {
+				f.Cancel()
+				return err
+			}
+			if err := f.Commit(); err != nil {
+				f.Cancel()
+				return err
+			}
+			checksums[entryName] = fmt.Sprintf("%x", hasher.Sum(nil))
+		default:
+			// Device nodes, fifos etc. are not transferred
+			continue
+		}
+
+		// Follow the original owner only if we're allowed to
+		if os.Geteuid() == 0 && !shared.RunningAsSnap() {
+			if err := os.Lchown(dest, hdr.Uid, hdr.Gid); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkNoSymlinkParents returns an error if any directory between root and
+// dest is a symlink
+func checkNoSymlinkParents(root, dest string) error {
+	// The root itself and everything above it is chosen by the caller and
+	// may well contain symlinks
+	if dest == root {
+		return nil
+	}
+	rel, err := filepath.Rel(root, filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to extract %s outside of %s", dest, root)
+	}
+	p := root
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, elem)
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to extract %s through symlink %s", dest, p)
+		}
+	}
+	return nil
+}
+
+// symlinkStaysInside reports whether a symlink at dest with the given target
+// resolves to a path below root
+func symlinkStaysInside(root, dest, target string) bool {
+	if filepath.IsAbs(target) {
+		return false
+	}
+	resolved := filepath.Join(filepath.Dir(dest), target)
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
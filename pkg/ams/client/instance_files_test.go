@@ -0,0 +1,150 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typ      byte
+	content  string
+	linkname string
+}
+
+func writeTar(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0755, Size: int64(len(e.content)), Linkname: e.linkname}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// symlinkedDir returns a directory which is reached through a symlink, like
+// /tmp on macOS or a symlinked home directory
+func symlinkedDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("real", link); err != nil {
+		t.Fatal(err)
+	}
+	return link
+}
+
+func TestReadTarStreamSymlinkedTargetParent(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		check   string
+	}{
+		{
+			name:    "file",
+			entries: []tarEntry{{name: "app.log", typ: tar.TypeReg, content: "log"}},
+		},
+		{
+			name: "directory",
+			entries: []tarEntry{
+				{name: "data", typ: tar.TypeDir},
+				{name: "data/sub", typ: tar.TypeDir},
+				{name: "data/sub/a", typ: tar.TypeReg, content: "a"},
+			},
+			check: "sub/a",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := filepath.Join(symlinkedDir(t), "out")
+			checksums := map[string]string{}
+			err := readTarStream(writeTar(t, test.entries...), test.entries[0].name, target, checksums, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(filepath.Join(target, test.check)); err != nil {
+				t.Fatal(err)
+			}
+			if len(checksums) != 1 {
+				t.Fatalf("expected one checksum, got %v", checksums)
+			}
+		})
+	}
+}
+
+func TestReadTarStreamRefusesSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		err     string
+	}{
+		{
+			name: "write through symlink",
+			entries: []tarEntry{
+				{name: "data", typ: tar.TypeDir},
+				{name: "data/escape", typ: tar.TypeSymlink, linkname: "."},
+				{name: "data/escape/a", typ: tar.TypeReg, content: "a"},
+			},
+			err: "through symlink",
+		},
+		{
+			name: "symlink outside",
+			entries: []tarEntry{
+				{name: "data", typ: tar.TypeDir},
+				{name: "data/escape", typ: tar.TypeSymlink, linkname: "../.."},
+			},
+			err: "points outside",
+		},
+		{
+			name: "foreign entry",
+			entries: []tarEntry{
+				{name: "data", typ: tar.TypeDir},
+				{name: "other/a", typ: tar.TypeReg, content: "a"},
+			},
+			err: "unexpected entry",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			target := filepath.Join(t.TempDir(), "out")
+			err := readTarStream(writeTar(t, test.entries...), "data", target, map[string]string{}, nil)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected an error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
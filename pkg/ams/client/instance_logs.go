@@ -0,0 +1,182 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// InstanceLogSource describes a source of live log output inside an instance
+type InstanceLogSource string
+
+const (
+	// InstanceLogSourceLogcat follows the Android logcat output
+	InstanceLogSourceLogcat InstanceLogSource = "logcat"
+	// InstanceLogSourceSystem follows the system journal of the instance
+	InstanceLogSourceSystem InstanceLogSource = "system"
+	// InstanceLogSourceAnbox follows the log of the Anbox runtime
+	InstanceLogSourceAnbox InstanceLogSource = "anbox"
+)
+
+const maxLogLineSize = 1024 * 1024
+
+// command returns the command used inside the instance to follow the log source
+func (s InstanceLogSource) command() ([]string, error) {
+	switch s {
+	case InstanceLogSourceLogcat:
+		return []string{"anbox-shell", "logcat"}, nil
+	case InstanceLogSourceSystem:
+		return []string{"journalctl", "--no-pager", "--follow"}, nil
+	case InstanceLogSourceAnbox:
+		return []string{"journalctl", "--no-pager", "--follow", "--unit", "anbox"}, nil
+	}
+	return nil, errs.NewInvalidArgument("source")
+}
+
+// InstanceLogFollowArgs provides additional options when following the logs
+// of one or more instances
+type InstanceLogFollowArgs struct {
+	// Filter, if set, only lets lines matching the expression through
+	Filter *regexp.Regexp
+}
+
+type logFollower struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close stops following the logs and releases all resources
+func (l *logFollower) Close() error {
+	l.cancel()
+	return l.PipeReader.Close()
+}
+
+// FollowInstanceLog streams the given log source of an instance in real time.
+// The stream ends when the context is cancelled or the returned reader is closed.
+func (c *clientImpl) FollowInstanceLog(ctx context.Context, id string, source InstanceLogSource) (io.ReadCloser, error) {
+	return c.FollowInstanceLogs(ctx, []string{id}, source, nil)
+}
+
+// FollowInstanceLogs streams the given log source of multiple instances in real
+// time. When more than one instance is followed every line is prefixed with the
+// ID of the instance it originates from.
+func (c *clientImpl) FollowInstanceLogs(ctx context.Context, ids []string, source InstanceLogSource, args *InstanceLogFollowArgs) (io.ReadCloser, error) {
+	if len(ids) == 0 {
+		return nil, errs.NewInvalidArgument("ids")
+	}
+	for _, id := range ids {
+		if len(id) == 0 {
+			return nil, errs.NewInvalidArgument("id")
+		}
+	}
+	cmd, err := source.command()
+	if err != nil {
+		return nil, err
+	}
+	if args == nil {
+		args = &InstanceLogFollowArgs{}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		firstErr error
+	)
+	for _, id := range ids {
+		prefix := ""
+		if len(ids) > 1 {
+			prefix = fmt.Sprintf("[%s] ", id)
+		}
+
+		wg.Add(1)
+		go func(id, prefix string) {
+			defer wg.Done()
+			err := c.followLog(ctx, id, cmd, prefix, args.Filter, pw, &lock)
+			if err != nil && ctx.Err() == nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("failed to follow log of instance %s: %w", id, err)
+				}
+				lock.Unlock()
+				// A single failing instance ends the whole stream
+				cancel()
+			}
+		}(id, prefix)
+	}
+
+	go func() {
+		wg.Wait()
+		cancel()
+		pw.CloseWithError(firstErr)
+	}()
+
+	return &logFollower{PipeReader: pr, cancel: cancel}, nil
+}
+
+// followLog runs the given command inside the instance and copies every line
+// of its output, which passes the filter, into w. Writes are serialized with
+// lock so lines of different instances never interleave.
+func (c *clientImpl) followLog(ctx context.Context, id string, cmd []string, prefix string, filter *regexp.Regexp, w io.Writer, lock *sync.Mutex) error {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(pr)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLogLineSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if filter != nil && !filter.MatchString(line) {
+				continue
+			}
+
+			lock.Lock()
+			_, err := fmt.Fprintf(w, "%s%s\n", prefix, line)
+			lock.Unlock()
+			if err != nil {
+				pr.CloseWithError(err)
+				done <- err
+				return
+			}
+		}
+		// Unblock the remote side if scanning stopped early, e.g. because a
+		// line exceeded maxLogLineSize
+		if err := scanner.Err(); err != nil {
+			pr.CloseWithError(err)
+			done <- err
+			return
+		}
+		done <- nil
+	}()
+
+	err := c.executeInstanceCommand(ctx, id, cmd, nil, pw)
+	pw.Close()
+	if scanErr := <-done; scanErr != nil && err == nil {
+		err = scanErr
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+const nodeDialTimeout = 5 * time.Second
+
+// tunnelCommand relays stdin/stdout to a TCP port on the loopback interface
+// of the instance. socat is preferred and nc used as fallback.
+const tunnelCommand = `command -v socat >/dev/null && exec socat - TCP:127.0.0.1:"$1"; exec nc 127.0.0.1 "$1"`
+
+// DialInstance opens a TCP stream to the given port inside an instance. If the
+// port is exposed as a service on the node the instance runs on, the node is
+// dialed directly. Otherwise the stream is tunneled through the AMS exec
+// websocket.
+func (c *clientImpl) DialInstance(ctx context.Context, id string, port int) (net.Conn, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	if port <= 0 || port > 65535 {
+		return nil, errs.NewInvalidArgument("port")
+	}
+
+	inst, _, err := c.RetrieveInstanceByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if addr := nodeAddressForPort(inst, port); len(addr) > 0 {
+		dialer := net.Dialer{Timeout: nodeDialTimeout}
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return c.dialInstanceExec(ctx, id, port)
+}
+
+// nodeAddressForPort returns the public address of the node the given port
+// of the instance is exposed on or an empty string if it isn't exposed.
+func nodeAddressForPort(inst *api.Instance, port int) string {
+	if len(inst.PublicAddress) == 0 {
+		return ""
+	}
+	for _, svc := range inst.Services {
+		if !svc.Expose || svc.NodePort == nil {
+			continue
+		}
+		hasTCP := false
+		for _, p := range svc.Protocols {
+			if p == api.NetworkProtocolTCP {
+				hasTCP = true
+			}
+		}
+		if !hasTCP {
+			continue
+		}
+		portEnd := svc.PortEnd
+		if portEnd == 0 {
+			portEnd = svc.Port
+		}
+		if port < svc.Port || port > portEnd {
+			continue
+		}
+		nodePort := *svc.NodePort + port - svc.Port
+		return net.JoinHostPort(inst.PublicAddress, strconv.Itoa(nodePort))
+	}
+	return ""
+}
+
+func (c *clientImpl) dialInstanceExec(ctx context.Context, id string, port int) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+
+	conn := &tunnelConn{
+		id:     id,
+		port:   port,
+		r:      stdoutReader,
+		w:      stdinWriter,
+		cancel: cancel,
+	}
+
+	cmd := []string{"sh", "-c", tunnelCommand, "sh", strconv.Itoa(port)}
+	go func() {
+		err := c.executeInstanceCommand(ctx, id, cmd, stdinReader, stdoutWriter)
+		stdinReader.CloseWithError(err)
+		if err == nil || ctx.Err() != nil {
+			err = io.EOF
+		}
+		stdoutWriter.CloseWithError(err)
+	}()
+
+	return conn, nil
+}
+
+// tunnelConn implements net.Conn on top of the streams of a command executed
+// inside an instance
+type tunnelConn struct {
+	id     string
+	port   int
+	r      *io.PipeReader
+	w      *io.PipeWriter
+	cancel context.CancelFunc
+	once   sync.Once
+}
+
+func (t *tunnelConn) Read(b []byte) (int, error) {
+	return t.r.Read(b)
+}
+
+func (t *tunnelConn) Write(b []byte) (int, error) {
+	return t.w.Write(b)
+}
+
+// CloseWrite closes the input of the relay command so the port inside the
+// instance sees the end of the stream while its response can still be read
+func (t *tunnelConn) CloseWrite() error {
+	return t.w.Close()
+}
+
+// Close closes the tunnel and terminates the relay command inside the instance
+func (t *tunnelConn) Close() error {
+	t.once.Do(func() {
+		t.w.Close()
+		t.r.Close()
+		t.cancel()
+	})
+	return nil
+}
+
+func (t *tunnelConn) LocalAddr() net.Addr {
+	return tunnelAddr("local")
+}
+
+func (t *tunnelConn) RemoteAddr() net.Addr {
+	return tunnelAddr(net.JoinHostPort(t.id, strconv.Itoa(t.port)))
+}
+
+func (t *tunnelConn) SetDeadline(_ time.Time) error {
+	return errs.NewErrNotSupported("deadline")
+}
+
+func (t *tunnelConn) SetReadDeadline(_ time.Time) error {
+	return errs.NewErrNotSupported("deadline")
+}
+
+func (t *tunnelConn) SetWriteDeadline(_ time.Time) error {
+	return errs.NewErrNotSupported("deadline")
+}
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "ams-exec" }
+func (a tunnelAddr) String() string  { return string(a) }
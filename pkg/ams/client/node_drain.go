@@ -0,0 +1,486 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+const (
+	defaultDrainConcurrency  = 4
+	defaultDrainPollInterval = 10 * time.Second
+)
+
+// DrainPolicy describes how instances are evicted from a node being drained
+type DrainPolicy string
+
+const (
+	// DrainPolicyWait waits for all instances on the node to terminate on
+	// their own
+	DrainPolicyWait DrainPolicy = "wait"
+	// DrainPolicyDelete deletes all instances on the node
+	DrainPolicyDelete DrainPolicy = "delete"
+	// DrainPolicyRelaunch launches a replacement for every instance on
+	// another node before deleting it. Base instances are relaunched from
+	// the same image version, regular ones from the same application
+	// version.
+	DrainPolicyRelaunch DrainPolicy = "relaunch"
+)
+
+// DrainPhase describes which step of a node drain a progress report belongs to
+type DrainPhase string
+
+const (
+	// DrainPhaseCordoned is reported once the node is marked unschedulable
+	DrainPhaseCordoned DrainPhase = "cordoned"
+	// DrainPhaseEvicting is reported when the eviction of an instance starts
+	DrainPhaseEvicting DrainPhase = "evicting"
+	// DrainPhaseEvicted is reported when an instance has left the node
+	DrainPhaseEvicted DrainPhase = "evicted"
+	// DrainPhaseFailed is reported when an instance could not be evicted
+	DrainPhaseFailed DrainPhase = "failed"
+	// DrainPhaseDone is reported once all instances were processed
+	DrainPhaseDone DrainPhase = "done"
+)
+
+// DrainProgress describes the state of a node drain
+type DrainProgress struct {
+	Node  string
+	Phase DrainPhase
+	// Instance is the ID of the instance the report is about, if any
+	Instance string
+	// Replacement is the ID of the instance launched in place of Instance
+	// when using DrainPolicyRelaunch
+	Replacement string
+	// Err is set when Phase is DrainPhaseFailed
+	Err error
+
+	Total   int
+	Evicted int
+	Failed  int
+}
+
+// DrainArgs provides details on how to drain a node
+type DrainArgs struct {
+	// Policy used to evict instances. Defaults to DrainPolicyWait.
+	Policy DrainPolicy
+	// Concurrency is the number of instances evicted at the same time.
+	// Defaults to 4.
+	Concurrency int
+	// MaxUnavailable is the disruption budget: the maximum number of
+	// instances of a single application which are evicted at the same
+	// time. Base instances, which have no application, are limited per
+	// image instead. Zero means no limit.
+	MaxUnavailable int
+	// PollInterval is how often the node is checked for remaining
+	// instances when waiting for them to terminate. Defaults to 10 seconds.
+	PollInterval time.Duration
+	// Timeout limits how long the drain waits for instances to terminate
+	// on their own. Instances still running afterwards are reported as
+	// failed. Zero means no limit.
+	Timeout time.Duration
+	// Force deletes instances even if they are still in use
+	Force bool
+	// PrepareRelaunch, if set, is called with the launch request of every
+	// replacement when using DrainPolicyRelaunch. AMS doesn't return the
+	// user data, features or addons an instance was launched with, so they
+	// must be filled in here if the replacement needs them.
+	PrepareRelaunch func(inst *api.Instance, details *api.InstancesPost)
+	// Progress, if set, is called for every step of the drain. Calls
+	// are serialized.
+	Progress func(DrainProgress)
+}
+
+// DrainResult summarizes a node drain
+type DrainResult struct {
+	// Evicted lists the IDs of all instances which left the node
+	Evicted []string
+	// Replacements maps IDs of relaunched instances to the IDs of the
+	// instances launched in their place. A replacement is listed even if
+	// the original instance could not be deleted afterwards.
+	Replacements map[string]string
+	// Failed maps IDs of instances which could not be evicted to the
+	// reason why
+	Failed map[string]error
+}
+
+type nodeDrainer struct {
+	c    *clientImpl
+	node string
+	args DrainArgs
+
+	lock     sync.Mutex
+	progress DrainProgress
+	result   DrainResult
+	// Number of evictions in flight per application
+	inFlight map[string]int
+	cond     *sync.Cond
+}
+
+// DrainNode takes a node out of service. The node is cordoned so no new
+// instances are scheduled on it and all instances running on it are evicted
+// according to the given policy. The node stays cordoned afterwards until
+// UncordonNode is called, which allows it to be removed with RemoveNode.
+func (c *clientImpl) DrainNode(ctx context.Context, name string, args *DrainArgs) (*DrainResult, error) {
+	if len(name) == 0 {
+		return nil, errs.NewInvalidArgument("name")
+	}
+	if args == nil {
+		args = &DrainArgs{}
+	}
+	d := &nodeDrainer{
+		c:        c,
+		node:     name,
+		args:     *args,
+		inFlight: map[string]int{},
+		progress: DrainProgress{Node: name},
+		result: DrainResult{
+			Replacements: map[string]string{},
+			Failed:       map[string]error{},
+		},
+	}
+	d.cond = sync.NewCond(&d.lock)
+	switch d.args.Policy {
+	case "":
+		d.args.Policy = DrainPolicyWait
+	case DrainPolicyWait, DrainPolicyDelete, DrainPolicyRelaunch:
+	default:
+		return nil, errs.NewInvalidArgument("policy")
+	}
+	if d.args.Concurrency == 0 {
+		d.args.Concurrency = defaultDrainConcurrency
+	}
+	if d.args.PollInterval == 0 {
+		d.args.PollInterval = defaultDrainPollInterval
+	}
+	if d.args.Concurrency < 0 || d.args.MaxUnavailable < 0 || d.args.PollInterval < 0 || d.args.Timeout < 0 {
+		return nil, errs.NewInvalidArgument("args")
+	}
+
+	if err := c.setNodeSchedulable(ctx, name, false); err != nil {
+		return nil, err
+	}
+	d.report(DrainPhaseCordoned, "", "", nil)
+
+	instances, err := c.ListInstancesWithFilters([]string{"node=" + name})
+	if err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	d.progress.Total = len(instances)
+	d.lock.Unlock()
+
+	err = d.run(ctx, instances)
+	sort.Strings(d.result.Evicted)
+	if err != nil {
+		return &d.result, err
+	}
+	d.report(DrainPhaseDone, "", "", nil)
+
+	if len(d.result.Failed) > 0 {
+		return &d.result, errs.NewErrFailed(fmt.Sprintf("eviction of %d instances from node %s", len(d.result.Failed), name))
+	}
+	return &d.result, nil
+}
+
+// UncordonNode marks a node as schedulable again after it was drained
+func (c *clientImpl) UncordonNode(ctx context.Context, name string) error {
+	if len(name) == 0 {
+		return errs.NewInvalidArgument("name")
+	}
+	return c.setNodeSchedulable(ctx, name, true)
+}
+
+func (c *clientImpl) setNodeSchedulable(ctx context.Context, name string, schedulable bool) error {
+	unschedulable := !schedulable
+	op, err := c.UpdateNode(name, &api.NodePatch{Unschedulable: &unschedulable})
+	if err != nil {
+		return err
+	}
+	return op.Wait(ctx)
+}
+
+func (d *nodeDrainer) run(ctx context.Context, instances []api.Instance) error {
+	var waitFor []api.Instance
+	sem := make(chan struct{}, d.args.Concurrency)
+	var wg sync.WaitGroup
+	for _, inst := range instances {
+		if d.args.Policy == DrainPolicyWait {
+			waitFor = append(waitFor, inst)
+			continue
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		key := disruptionBudgetKey(&inst)
+		if !d.acquire(ctx, key) {
+			<-sem
+			wg.Wait()
+			return ctx.Err()
+		}
+		wg.Add(1)
+		go func(inst api.Instance) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer d.release(key)
+			d.evict(ctx, inst)
+		}(inst)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.waitForTermination(ctx, waitFor)
+}
+
+// disruptionBudgetKey returns the key instances share a disruption budget
+// by: their application or, for base instances, their image
+func disruptionBudgetKey(inst *api.Instance) string {
+	if len(inst.AppID) > 0 {
+		return "application/" + inst.AppID
+	}
+	return "image/" + inst.ImageID
+}
+
+// acquire blocks until evicting another instance with the given budget key
+// stays within the disruption budget
+func (d *nodeDrainer) acquire(ctx context.Context, key string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.args.MaxUnavailable > 0 {
+		stop := context.AfterFunc(ctx, func() {
+			d.lock.Lock()
+			d.cond.Broadcast()
+			d.lock.Unlock()
+		})
+		defer stop()
+		for d.inFlight[key] >= d.args.MaxUnavailable && ctx.Err() == nil {
+			d.cond.Wait()
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+	}
+	d.inFlight[key]++
+	return true
+}
+
+func (d *nodeDrainer) release(key string) {
+	d.lock.Lock()
+	d.inFlight[key]--
+	d.cond.Broadcast()
+	d.lock.Unlock()
+}
+
+func (d *nodeDrainer) evict(ctx context.Context, inst api.Instance) {
+	d.report(DrainPhaseEvicting, inst.ID, "", nil)
+
+	var replacement string
+	if d.args.Policy == DrainPolicyRelaunch {
+		var err error
+		replacement, err = d.relaunch(ctx, &inst)
+		if err != nil {
+			d.report(DrainPhaseFailed, inst.ID, "", err)
+			return
+		}
+	}
+
+	op, err := d.c.DeleteInstanceByID(inst.ID, d.args.Force)
+	if err == nil {
+		err = op.Wait(ctx)
+	}
+	if err != nil {
+		d.report(DrainPhaseFailed, inst.ID, replacement, err)
+		return
+	}
+	d.report(DrainPhaseEvicted, inst.ID, replacement, nil)
+}
+
+// relaunch launches a copy of the instance on another node and waits for it
+// to be running. It returns the ID of the new instance.
+func (d *nodeDrainer) relaunch(ctx context.Context, inst *api.Instance) (string, error) {
+	details := instancesPostFromInstance(inst)
+	if d.args.PrepareRelaunch != nil {
+		d.args.PrepareRelaunch(inst, details)
+	}
+	op, err := d.c.LaunchInstance(details, false)
+	if err != nil {
+		return "", err
+	}
+	if err := op.Wait(ctx); err != nil {
+		return "", err
+	}
+	if r := op.Get().Resources["instances"]; len(r) > 0 {
+		return path.Base(r[0]), nil
+	}
+	return "", errs.NewErrNotFound("replacement instance")
+}
+
+// waitForTermination polls the node until none of the given instances is
+// left on it or the timeout expires
+func (d *nodeDrainer) waitForTermination(ctx context.Context, instances []api.Instance) error {
+	pending := map[string]bool{}
+	for _, inst := range instances {
+		pending[inst.ID] = true
+	}
+	var timeout <-chan time.Time
+	if d.args.Timeout > 0 {
+		timer := time.NewTimer(d.args.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for len(pending) > 0 {
+		remaining, err := d.c.ListInstancesWithFilters([]string{"node=" + d.node})
+		if err != nil {
+			return err
+		}
+		left := map[string]bool{}
+		for _, inst := range remaining {
+			if pending[inst.ID] && inst.StatusCode != api.InstanceStatusDeleted {
+				left[inst.ID] = true
+			}
+		}
+		for id := range pending {
+			if !left[id] {
+				delete(pending, id)
+				d.report(DrainPhaseEvicted, id, "", nil)
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		select {
+		case <-time.After(d.args.PollInterval):
+		case <-timeout:
+			for id := range pending {
+				d.report(DrainPhaseFailed, id, "", errs.NewErrTimeout(fmt.Sprintf("termination of instance %s", id)))
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (d *nodeDrainer) report(phase DrainPhase, id, replacement string, err error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(replacement) > 0 {
+		d.result.Replacements[id] = replacement
+	}
+	switch phase {
+	case DrainPhaseEvicted:
+		d.progress.Evicted++
+		d.result.Evicted = append(d.result.Evicted, id)
+	case DrainPhaseFailed:
+		d.progress.Failed++
+		d.result.Failed[id] = err
+	}
+	if d.args.Progress == nil {
+		return
+	}
+	p := d.progress
+	p.Phase = phase
+	p.Instance = id
+	p.Replacement = replacement
+	p.Err = err
+	d.args.Progress(p)
+}
+
+// instancesPostFromInstance returns the launch request which recreates the
+// given instance on any node. All fields AMS returns for an instance are
+// carried over.
+func instancesPostFromInstance(inst *api.Instance) *api.InstancesPost {
+	details := &api.InstancesPost{
+		Name:    inst.Name,
+		Type:    inst.Type,
+		Tags:    inst.Tags,
+		NoStart: inst.StatusCode == api.InstanceStatusStopped,
+	}
+	if len(inst.AppID) > 0 {
+		details.ApplicationID = inst.AppID
+		version := inst.AppVersion
+		details.ApplicationVersion = &version
+	} else {
+		details.ImageID = inst.ImageID
+		version := inst.ImageVersion
+		details.ImageVersion = &version
+	}
+
+	for _, s := range inst.Services {
+		details.Services = append(details.Services, api.NetworkServiceSpec{
+			Port:      s.Port,
+			PortEnd:   s.PortEnd,
+			Protocols: s.Protocols,
+			Expose:    s.Expose,
+			Name:      s.Name,
+		})
+	}
+
+	r := inst.Resources
+	if r.CPUs > 0 {
+		details.Resources.CPUs = &r.CPUs
+	}
+	if r.Memory > 0 {
+		details.Resources.Memory = &r.Memory
+	}
+	if r.DiskSize > 0 {
+		details.Resources.DiskSize = &r.DiskSize
+	}
+	if r.GPUSlots > 0 {
+		details.Resources.GPUSlots = &r.GPUSlots
+	}
+	if len(r.GPUType) > 0 {
+		details.Resources.GPUType = &r.GPUType
+	}
+	if r.VPUSlots > 0 {
+		details.Resources.VPUSlots = &r.VPUSlots
+	}
+	if r.NoDiskReserve {
+		details.Resources.NoDiskReserve = &r.NoDiskReserve
+	}
+
+	details.Config.Platform = inst.Config.Platform
+	details.Config.BootPackage = inst.Config.BootPackage
+	details.Config.BootActivity = inst.Config.BootActivity
+	details.Config.MetricsServer = inst.Config.MetricsServer
+	details.Config.DisableWatchdog = inst.Config.DisableWatchdog
+	details.Config.DevMode = inst.Config.DevMode
+	details.Config.EnableStreaming = inst.Config.EnableStreaming
+	details.Config.Display = inst.Config.Display
+	if inst.Config.Security.DeleteProtected {
+		protected := true
+		details.Config.Security.DeleteProtected = &protected
+	}
+	return details
+}
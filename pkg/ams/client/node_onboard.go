@@ -0,0 +1,371 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+const (
+	defaultLXDPort              = 8443
+	defaultPreflightDialTimeout = 5 * time.Second
+	defaultOnboardConcurrency   = 2
+
+	minNetworkBridgeMTU = 1280
+	maxNetworkBridgeMTU = 9000
+)
+
+// NodePreflightArgs provides details on how to check nodes before adding them
+type NodePreflightArgs struct {
+	// SkipReachability disables checking whether the LXD API of the nodes
+	// can be reached
+	SkipReachability bool
+	// Port the LXD API listens on. Defaults to 8443.
+	Port int
+	// DialTimeout limits how long connecting to a node may take. Defaults
+	// to 5 seconds.
+	DialTimeout time.Duration
+	// ExistingSubnets lists subnets already in use by the bridges of the
+	// nodes in the cluster. AMS doesn't report them so they have to be
+	// supplied to detect conflicts.
+	ExistingSubnets []string
+}
+
+// NodeOnboardArgs provides details on how to add a batch of nodes
+type NodeOnboardArgs struct {
+	NodePreflightArgs
+	// Concurrency is the number of nodes added at the same time. Defaults
+	// to 2.
+	Concurrency int
+	// NoRollback keeps the nodes which were added successfully if adding
+	// another node fails
+	NoRollback bool
+	// Progress, if set, is called once a node was added or failed to be
+	// added. Calls are serialized.
+	Progress func(name string, err error)
+}
+
+// NodeOnboardResult summarizes a batch of added nodes
+type NodeOnboardResult struct {
+	// Added lists the nodes which were added and are still part of the
+	// cluster
+	Added []string
+	// Failed maps names of nodes which could not be added to the reason
+	Failed map[string]error
+	// RolledBack lists the nodes which were removed again because adding
+	// a node failed. This includes failed nodes AMS kept in the cluster.
+	RolledBack []string
+}
+
+type nodeInventory struct {
+	Nodes []api.NodesPost `yaml:"nodes"`
+}
+
+// LoadNodeInventory reads the nodes to add from a YAML inventory with a
+// single `nodes` list using the fields of the node creation request
+func LoadNodeInventory(path string) ([]api.NodesPost, error) {
+	inv := &nodeInventory{}
+	if err := shared.LoadFromFile(path, inv); err != nil {
+		return nil, err
+	}
+	return inv.Nodes, nil
+}
+
+// PreflightNodes checks nodes locally before they are added. It returns
+// packages.FieldErrors describing all problems found, with paths like
+// nodes[0].address. Bridge subnets are only checked against each other, the
+// node addresses and args.ExistingSubnets, as AMS doesn't report the subnets
+// of the nodes already in the cluster. Conflicts with those go unnoticed
+// unless they are listed in args.ExistingSubnets.
+func (c *clientImpl) PreflightNodes(ctx context.Context, nodes []api.NodesPost, args *NodePreflightArgs) error {
+	if args == nil {
+		args = &NodePreflightArgs{}
+	}
+	existing, err := c.ListNodes()
+	if err != nil {
+		return err
+	}
+
+	var fe packages.FieldErrors
+	names := map[string]bool{}
+	addresses := map[string]bool{}
+	for _, n := range existing {
+		names[n.Name] = true
+		addresses[n.Address] = true
+	}
+
+	var subnets []*net.IPNet
+	for n, s := range args.ExistingSubnets {
+		_, subnet, err := net.ParseCIDR(s)
+		if err != nil {
+			fe.Addf(fmt.Sprintf("existing-subnets[%d]", n), "invalid CIDR %q", s)
+			continue
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	for n := range nodes {
+		node := &nodes[n]
+		field := func(name string) string {
+			return fmt.Sprintf("nodes[%d].%s", n, name)
+		}
+
+		switch {
+		case len(node.Name) == 0:
+			fe.Add(field("name"), errs.NewErrRequired("name"))
+		case names[node.Name]:
+			fe.Add(field("name"), errs.NewErrAlreadyExists(node.Name))
+		}
+		names[node.Name] = true
+
+		addressValid := false
+		switch {
+		case len(node.Address) == 0:
+			fe.Add(field("address"), errs.NewErrRequired("address"))
+		case net.ParseIP(node.Address) == nil:
+			fe.Add(field("address"), errs.NewErrInvalidFormat(node.Address))
+		case addresses[node.Address]:
+			fe.Add(field("address"), errs.NewErrAlreadyExists(node.Address))
+		default:
+			addressValid = true
+		}
+		addresses[node.Address] = true
+		if len(node.PublicAddress) > 0 && net.ParseIP(node.PublicAddress) == nil {
+			fe.Add(field("public_address"), errs.NewErrInvalidFormat(node.PublicAddress))
+		}
+
+		if !node.Unmanaged && len(node.TrustPassword) == 0 && len(node.TrustToken) == 0 {
+			fe.Addf(field("trust_token"), "either a trust token or a trust password is required")
+		}
+		if node.NetworkBridgeMTU != 0 && (node.NetworkBridgeMTU < minNetworkBridgeMTU || node.NetworkBridgeMTU > maxNetworkBridgeMTU) {
+			fe.Addf(field("network_bridge_mtu"), "must be between %d and %d", minNetworkBridgeMTU, maxNetworkBridgeMTU)
+		}
+		if len(node.StorageDevice) > 0 && !filepath.IsAbs(node.StorageDevice) {
+			fe.Addf(field("storage_device"), "must be an absolute path")
+		}
+		if _, err := shared.ParseByteSizeString(node.Memory); err != nil {
+			fe.Add(field("memory"), err)
+		}
+		if node.CPUs < 0 {
+			fe.Addf(field("cpus"), "must not be negative")
+		}
+		if node.CPUAllocationRate < 0 {
+			fe.Addf(field("cpu_allocation_rate"), "must not be negative")
+		}
+		if node.MemoryAllocationRate < 0 {
+			fe.Addf(field("memory_allocation_rate"), "must not be negative")
+		}
+		if node.GPUSlots < 0 {
+			fe.Addf(field("gpu_slots"), "must not be negative")
+		}
+		if node.GPUEncoderSlots < 0 {
+			fe.Addf(field("gpu_encoder_slots"), "must not be negative")
+		}
+
+		if len(node.NetworkSubnet) > 0 {
+			_, subnet, err := net.ParseCIDR(node.NetworkSubnet)
+			if err != nil || subnet.IP.To4() == nil {
+				fe.Add(field("network_subnet"), errs.NewErrInvalidFormat(node.NetworkSubnet))
+			} else {
+				for _, other := range subnets {
+					if subnet.Contains(other.IP) || other.Contains(subnet.IP) {
+						fe.Addf(field("network_subnet"), "overlaps with subnet %s", other)
+					}
+				}
+				for _, a := range existing {
+					if ip := net.ParseIP(a.Address); ip != nil && subnet.Contains(ip) {
+						fe.Addf(field("network_subnet"), "contains the address of node %s", a.Name)
+					}
+				}
+				if addressValid && subnet.Contains(net.ParseIP(node.Address)) {
+					fe.Addf(field("network_subnet"), "contains the address of the node")
+				}
+				subnets = append(subnets, subnet)
+			}
+		}
+	}
+
+	if !args.SkipReachability {
+		for n, err := range checkReachability(ctx, nodes, args) {
+			fe.Add(fmt.Sprintf("nodes[%d].address", n), err)
+		}
+	}
+	return fe.Err()
+}
+
+// checkReachability connects to the LXD API of all nodes with a valid
+// address and returns the errors indexed by node
+func checkReachability(ctx context.Context, nodes []api.NodesPost, args *NodePreflightArgs) map[int]error {
+	port := args.Port
+	if port == 0 {
+		port = defaultLXDPort
+	}
+	timeout := args.DialTimeout
+	if timeout == 0 {
+		timeout = defaultPreflightDialTimeout
+	}
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	failures := map[int]error{}
+	for n := range nodes {
+		if net.ParseIP(nodes[n].Address) == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(n int, address string) {
+			defer wg.Done()
+			d := net.Dialer{Timeout: timeout}
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+				return
+			}
+			lock.Lock()
+			failures[n] = fmt.Errorf("not reachable: %w", err)
+			lock.Unlock()
+		}(n, nodes[n].Address)
+	}
+	wg.Wait()
+	return failures
+}
+
+// OnboardNodes adds a batch of nodes after checking them with
+// PreflightNodes. If adding any node fails, all nodes of the batch which AMS
+// knows about, including the failed ones, are removed again unless rollback
+// is disabled.
+func (c *clientImpl) OnboardNodes(ctx context.Context, nodes []api.NodesPost, args *NodeOnboardArgs) (*NodeOnboardResult, error) {
+	if len(nodes) == 0 {
+		return nil, errs.NewInvalidArgument("nodes")
+	}
+	if args == nil {
+		args = &NodeOnboardArgs{}
+	}
+	concurrency := args.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultOnboardConcurrency
+	}
+	if concurrency < 0 {
+		return nil, errs.NewInvalidArgument("concurrency")
+	}
+
+	if err := c.PreflightNodes(ctx, nodes, &args.NodePreflightArgs); err != nil {
+		return nil, err
+	}
+
+	result := &NodeOnboardResult{Failed: map[string]error{}}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for n := range nodes {
+		node := &nodes[n]
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			op, err := c.AddNode(node)
+			if err == nil {
+				err = op.Wait(ctx)
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				result.Failed[node.Name] = err
+			} else {
+				result.Added = append(result.Added, node.Name)
+			}
+			if args.Progress != nil {
+				args.Progress(node.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+	sort.Strings(result.Added)
+
+	err := ctx.Err()
+	if err == nil && len(result.Failed) > 0 {
+		err = errs.NewErrFailed(fmt.Sprintf("adding %d of %d nodes", len(result.Failed), len(nodes)))
+	}
+	if err == nil || args.NoRollback {
+		return result, err
+	}
+
+	// The context might be cancelled already so the rollback must not
+	// depend on it
+	var rollbackErrs []error
+	added := result.Added
+	result.Added = nil
+
+	// A node which failed to be added may still be known to AMS, e.g. if
+	// it failed while being set up. As PreflightNodes rejects names which
+	// already exist, such a node was created by this batch.
+	var failed []string
+	existing, lerr := c.ListNodes()
+	if lerr != nil {
+		rollbackErrs = append(rollbackErrs, fmt.Errorf("failed to list nodes: %w", lerr))
+	}
+	for _, n := range existing {
+		if _, ok := result.Failed[n.Name]; ok {
+			failed = append(failed, n.Name)
+		}
+	}
+	sort.Strings(failed)
+
+	for _, name := range append(added, failed...) {
+		op, rerr := c.RemoveNode(name, true, false)
+		if rerr == nil {
+			rerr = op.Wait(context.Background())
+		}
+		if rerr != nil {
+			rollbackErrs = append(rollbackErrs, fmt.Errorf("failed to remove node %s: %w", name, rerr))
+			if _, ok := result.Failed[name]; !ok {
+				result.Added = append(result.Added, name)
+			}
+			continue
+		}
+		result.RolledBack = append(result.RolledBack, name)
+	}
+	sort.Strings(result.RolledBack)
+	if len(rollbackErrs) > 0 {
+		return result, errors.Join(append([]error{err}, rollbackErrs...)...)
+	}
+	return result, err
+}
@@ -0,0 +1,340 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// newOnboardTestServer returns a server with a single node lxd0 at 10.0.0.1
+// and a client connected to it
+func newOnboardTestServer(t *testing.T) (*amstest.Server, Client) {
+	t.Helper()
+	s := amstest.NewServer()
+	t.Cleanup(s.Close)
+	s.SetNodes(api.Node{Name: "lxd0", Address: "10.0.0.1", StatusCode: api.NodeStatusOnline})
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func testNodesPost(name, address string) api.NodesPost {
+	return api.NodesPost{Name: name, Address: address, TrustToken: "token"}
+}
+
+// fieldErrorPaths returns the paths of all field errors in err
+func fieldErrorPaths(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	var fe packages.FieldErrors
+	if !errors.As(err, &fe) {
+		t.Fatalf("expected field errors, got %v", err)
+	}
+	var paths []string
+	for _, e := range fe {
+		paths = append(paths, e.Path)
+	}
+	return paths
+}
+
+func TestPreflightNodes(t *testing.T) {
+	withNode := func(change func(n *api.NodesPost)) []api.NodesPost {
+		n := testNodesPost("lxd1", "10.0.0.2")
+		change(&n)
+		return []api.NodesPost{n}
+	}
+	tests := []struct {
+		name     string
+		nodes    []api.NodesPost
+		existing []string
+		paths    []string
+	}{
+		{
+			name: "valid",
+			nodes: withNode(func(n *api.NodesPost) {
+				n.NetworkSubnet = "192.168.100.1/24"
+				n.NetworkBridgeMTU = 9000
+				n.StorageDevice = "/dev/sdb"
+				n.Memory = "64GB"
+			}),
+		},
+		{
+			name:  "name required",
+			nodes: withNode(func(n *api.NodesPost) { n.Name = "" }),
+			paths: []string{"nodes[0].name"},
+		},
+		{
+			name:  "name exists in cluster",
+			nodes: withNode(func(n *api.NodesPost) { n.Name = "lxd0" }),
+			paths: []string{"nodes[0].name"},
+		},
+		{
+			name:  "name repeated in batch",
+			nodes: []api.NodesPost{testNodesPost("lxd1", "10.0.0.2"), testNodesPost("lxd1", "10.0.0.3")},
+			paths: []string{"nodes[1].name"},
+		},
+		{
+			name:  "address required",
+			nodes: withNode(func(n *api.NodesPost) { n.Address = "" }),
+			paths: []string{"nodes[0].address"},
+		},
+		{
+			name:  "address invalid",
+			nodes: withNode(func(n *api.NodesPost) { n.Address = "lxd1.example.com" }),
+			paths: []string{"nodes[0].address"},
+		},
+		{
+			name:  "address exists in cluster",
+			nodes: withNode(func(n *api.NodesPost) { n.Address = "10.0.0.1" }),
+			paths: []string{"nodes[0].address"},
+		},
+		{
+			name:  "address repeated in batch",
+			nodes: []api.NodesPost{testNodesPost("lxd1", "10.0.0.2"), testNodesPost("lxd2", "10.0.0.2")},
+			paths: []string{"nodes[1].address"},
+		},
+		{
+			name:  "public address invalid",
+			nodes: withNode(func(n *api.NodesPost) { n.PublicAddress = "public" }),
+			paths: []string{"nodes[0].public_address"},
+		},
+		{
+			name:  "trust token missing",
+			nodes: withNode(func(n *api.NodesPost) { n.TrustToken = "" }),
+			paths: []string{"nodes[0].trust_token"},
+		},
+		{
+			name: "unmanaged without trust token",
+			nodes: withNode(func(n *api.NodesPost) {
+				n.TrustToken = ""
+				n.Unmanaged = true
+			}),
+		},
+		{
+			name:  "bridge mtu too small",
+			nodes: withNode(func(n *api.NodesPost) { n.NetworkBridgeMTU = 1000 }),
+			paths: []string{"nodes[0].network_bridge_mtu"},
+		},
+		{
+			name:  "storage device relative",
+			nodes: withNode(func(n *api.NodesPost) { n.StorageDevice = "sdb" }),
+			paths: []string{"nodes[0].storage_device"},
+		},
+		{
+			name:  "memory invalid",
+			nodes: withNode(func(n *api.NodesPost) { n.Memory = "lots" }),
+			paths: []string{"nodes[0].memory"},
+		},
+		{
+			name: "negative resources",
+			nodes: withNode(func(n *api.NodesPost) {
+				n.CPUs = -1
+				n.GPUSlots = -1
+			}),
+			paths: []string{"nodes[0].cpus", "nodes[0].gpu_slots"},
+		},
+		{
+			name:  "subnet invalid",
+			nodes: withNode(func(n *api.NodesPost) { n.NetworkSubnet = "fd42::1/64" }),
+			paths: []string{"nodes[0].network_subnet"},
+		},
+		{
+			name: "subnets overlap in batch",
+			nodes: []api.NodesPost{
+				withNode(func(n *api.NodesPost) { n.NetworkSubnet = "192.168.100.1/24" })[0],
+				{Name: "lxd2", Address: "10.0.0.3", TrustToken: "token", NetworkSubnet: "192.168.0.1/16"},
+			},
+			paths: []string{"nodes[1].network_subnet"},
+		},
+		{
+			name:  "subnet contains node addresses",
+			nodes: withNode(func(n *api.NodesPost) { n.NetworkSubnet = "10.0.0.1/24" }),
+			paths: []string{"nodes[0].network_subnet", "nodes[0].network_subnet"},
+		},
+		{
+			name:     "subnet overlaps existing subnet",
+			nodes:    withNode(func(n *api.NodesPost) { n.NetworkSubnet = "192.168.100.1/24" }),
+			existing: []string{"192.168.100.0/24"},
+			paths:    []string{"nodes[0].network_subnet"},
+		},
+		{
+			name:     "existing subnet invalid",
+			nodes:    withNode(func(n *api.NodesPost) {}),
+			existing: []string{"192.168.100.0"},
+			paths:    []string{"existing-subnets[0]"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, c := newOnboardTestServer(t)
+			err := c.PreflightNodes(context.Background(), test.nodes, &NodePreflightArgs{
+				SkipReachability: true,
+				ExistingSubnets:  test.existing,
+			})
+			if paths := fieldErrorPaths(t, err); !reflect.DeepEqual(paths, test.paths) {
+				t.Fatalf("expected errors for %v, got %v", test.paths, err)
+			}
+		})
+	}
+}
+
+func TestPreflightNodesReachability(t *testing.T) {
+	tests := []struct {
+		name      string
+		listening bool
+		paths     []string
+	}{
+		{name: "reachable", listening: true},
+		{name: "unreachable", paths: []string{"nodes[0].address"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, c := newOnboardTestServer(t)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			port := l.Addr().(*net.TCPAddr).Port
+			if test.listening {
+				defer l.Close()
+			} else {
+				l.Close()
+			}
+
+			nodes := []api.NodesPost{testNodesPost("lxd1", "127.0.0.1")}
+			err = c.PreflightNodes(context.Background(), nodes, &NodePreflightArgs{Port: port})
+			if paths := fieldErrorPaths(t, err); !reflect.DeepEqual(paths, test.paths) {
+				t.Fatalf("expected errors for %v, got %v", test.paths, err)
+			}
+		})
+	}
+}
+
+func TestOnboardNodes(t *testing.T) {
+	tests := []struct {
+		name       string
+		keepFailed bool
+		noRollback bool
+		fail       bool
+		added      []string
+		rolledBack []string
+		remaining  []string
+	}{
+		{
+			name:      "all added",
+			added:     []string{"lxd1", "lxd2", "lxd3"},
+			remaining: []string{"lxd0", "lxd1", "lxd2", "lxd3"},
+		},
+		{
+			name:       "rollback",
+			fail:       true,
+			rolledBack: []string{"lxd1", "lxd3"},
+			remaining:  []string{"lxd0"},
+		},
+		{
+			name:       "rollback of failed node kept by AMS",
+			fail:       true,
+			keepFailed: true,
+			rolledBack: []string{"lxd1", "lxd2", "lxd3"},
+			remaining:  []string{"lxd0"},
+		},
+		{
+			name:       "no rollback",
+			fail:       true,
+			keepFailed: true,
+			noRollback: true,
+			added:      []string{"lxd1", "lxd3"},
+			remaining:  []string{"lxd0", "lxd1", "lxd2", "lxd3"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, c := newOnboardTestServer(t)
+			if test.fail {
+				s.FailNodeAdd("lxd2", "failed to set up node", test.keepFailed)
+			}
+			var nodes []api.NodesPost
+			for n := 1; n <= 3; n++ {
+				nodes = append(nodes, testNodesPost("lxd"+strconv.Itoa(n), "10.0.0."+strconv.Itoa(n+1)))
+			}
+			progress := map[string]bool{}
+			result, err := c.OnboardNodes(context.Background(), nodes, &NodeOnboardArgs{
+				NodePreflightArgs: NodePreflightArgs{SkipReachability: true},
+				NoRollback:        test.noRollback,
+				Progress: func(name string, err error) {
+					progress[name] = err == nil
+				},
+			})
+
+			if test.fail {
+				if !errors.As(err, &errs.ErrFailed{}) {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if _, ok := result.Failed["lxd2"]; !ok || len(result.Failed) != 1 {
+					t.Fatalf("expected lxd2 to fail, got %v", result.Failed)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.Added, test.added) {
+				t.Fatalf("expected %v to be added, got %v", test.added, result.Added)
+			}
+			if !reflect.DeepEqual(result.RolledBack, test.rolledBack) {
+				t.Fatalf("expected %v to be rolled back, got %v", test.rolledBack, result.RolledBack)
+			}
+			expectedProgress := map[string]bool{"lxd1": true, "lxd2": !test.fail, "lxd3": true}
+			if !reflect.DeepEqual(progress, expectedProgress) {
+				t.Fatalf("expected progress %v, got %v", expectedProgress, progress)
+			}
+
+			existing, err := c.ListNodes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var remaining []string
+			for _, n := range existing {
+				remaining = append(remaining, n.Name)
+			}
+			sort.Strings(remaining)
+			if !reflect.DeepEqual(remaining, test.remaining) {
+				t.Fatalf("expected nodes %v to remain, got %v", test.remaining, remaining)
+			}
+		})
+	}
+}
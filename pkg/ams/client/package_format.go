@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"fmt"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// SupportedPackageFormats returns the package formats the AMS service accepts
+// for addons, applications and images
+func (c *clientImpl) SupportedPackageFormats() ([]packages.PackageFormat, error) {
+	formats := []packages.PackageFormat{}
+	for _, format := range packages.PackageFormats {
+		supported, err := c.supportsPackageFormat(format)
+		if err != nil {
+			return nil, err
+		}
+		if supported {
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+func (c *clientImpl) supportsPackageFormat(format packages.PackageFormat) (bool, error) {
+	ext := format.RequiredExtension()
+	if len(ext) == 0 {
+		return true, nil
+	}
+	return c.HasExtension(ext)
+}
+
+// checkPackageFormat returns an error if the AMS service doesn't accept
+// packages in the given format. Unknown formats are left for the service to
+// reject as it may know formats the client doesn't.
+func (c *clientImpl) checkPackageFormat(format packages.PackageFormat) error {
+	if format == packages.PackageFormatUnknown {
+		return nil
+	}
+	supported, err := c.supportsPackageFormat(format)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return errs.NewErrNotSupported(fmt.Sprintf("api extension %q", format.RequiredExtension()))
+	}
+	return nil
+}
+
+// detectPackageFormat determines the format of the package at the given path
+// and falls back to its file extension if the content gives no hint
+func detectPackageFormat(packagePath string) (packages.PackageFormat, error) {
+	format, err := packages.DetectPackageFormat(packagePath)
+	if err != nil {
+		return packages.PackageFormatUnknown, err
+	}
+	if format == packages.PackageFormatUnknown {
+		format = packages.PackageFormatFromExtension(packagePath)
+	}
+	return format, nil
+}
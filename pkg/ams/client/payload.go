@@ -19,6 +19,7 @@
 package client
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io"
@@ -69,3 +70,64 @@ func preparePayload(filepath string) (io.Reader, string, error) {
 
 	return f, fingerprint, nil
 }
+
+// maxInMemorySpoolSize is the size up to which streamed payloads are kept in
+// memory while their fingerprint is computed. Larger payloads are spooled to
+// a temporary file.
+const maxInMemorySpoolSize = 32 * 1024 * 1024
+
+// spooledPayload is a payload which was read completely from a stream
+type spooledPayload struct {
+	io.Reader
+	file *os.File
+	size int64
+}
+
+// Close releases the temporary file backing the payload, if any
+func (p *spooledPayload) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	p.file.Close()
+	return os.Remove(p.file.Name())
+}
+
+// spoolPayload reads the given stream until EOF and computes its SHA-256
+// fingerprint. The returned payload replays the stream from the beginning.
+func spoolPayload(r io.Reader) (*spooledPayload, string, error) {
+	hasher := sha256.New()
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(io.MultiWriter(&buf, hasher), r, maxInMemorySpoolSize+1)
+	if err == io.EOF {
+		fingerprint := fmt.Sprintf("%x", hasher.Sum(nil))
+		return &spooledPayload{Reader: bytes.NewReader(buf.Bytes()), size: n}, fingerprint, nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	f, err := os.CreateTemp("", "ams-payload-")
+	if err != nil {
+		return nil, "", err
+	}
+	p := &spooledPayload{Reader: f, file: f}
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		p.Close()
+		return nil, "", err
+	}
+	rest, err := io.Copy(io.MultiWriter(f, hasher), r)
+	if err != nil {
+		p.Close()
+		return nil, "", err
+	}
+	p.size = n + rest
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		p.Close()
+		return nil, "", err
+	}
+
+	return p, fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
@@ -117,3 +117,25 @@ func (c *clientImpl) DeleteInstanceShareByID(instanceID, shareID string) (restcl
 		nil, nil, nil, "")
 	return op, err
 }
+
+// ListInstanceShares lists all active shares of an instance
+func (c *clientImpl) ListInstanceShares(id string) ([]api.InstanceShare, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+
+	supported, err := c.hasSharesSupport()
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		return nil, errSharesNotSupported
+	}
+
+	shares := []api.InstanceShare{}
+	params := restclient.QueryParams{
+		"recursion": "1",
+	}
+	_, err = c.QueryStruct("GET", restclient.APIPath("instances", id, "shares"), params, nil, nil, "", &shares)
+	return shares, err
+}
@@ -0,0 +1,282 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	"github.com/anbox-cloud/ams-sdk/pkg/network"
+)
+
+const (
+	adbShareType       = "adb"
+	defaultADBShareTTL = time.Hour
+	// The share is renewed once this fraction of its TTL is left
+	adbShareRenewFactor = 5
+	// Maximum time Close waits for the share to be deleted
+	adbShareDeleteTimeout = 30 * time.Second
+)
+
+// ADBShareArgs provides details on how to share an instance over ADB
+type ADBShareArgs struct {
+	// Description of the share
+	Description string
+	// TTL of the share. The share is renewed automatically before it
+	// expires as long as it is open. Defaults to one hour.
+	TTL time.Duration
+	// LocalAddr is the address the local ADB endpoint listens on. If no
+	// port is given a free one is allocated.
+	LocalAddr string
+}
+
+// ADBShare represents an ADB share of an instance together with a local
+// endpoint `adb connect` can be pointed at
+type ADBShare struct {
+	// ID of the share
+	ID string
+	// InstanceID is the ID of the shared instance
+	InstanceID string
+	// URL of the share
+	URL string
+
+	c         *clientImpl
+	ttl       time.Duration
+	listener  net.Listener
+	expiryAt  time.Time
+	lock      sync.Mutex
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// ShareInstanceADB creates an ADB share for an instance and starts a local
+// listener which proxies every connection to the share over a websocket. The
+// share is kept alive until the context is cancelled or Close is called, at
+// which point it is deleted.
+func (c *clientImpl) ShareInstanceADB(ctx context.Context, id string, args *ADBShareArgs) (*ADBShare, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	if args == nil {
+		args = &ADBShareArgs{}
+	}
+	ttl := args.TTL
+	if ttl == 0 {
+		ttl = defaultADBShareTTL
+	}
+	if ttl < 0 {
+		return nil, errs.NewInvalidArgument("ttl")
+	}
+
+	addr, err := localShareAddress(args.LocalAddr)
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	expiryAt := time.Now().Add(ttl).Unix()
+	resp, err := c.CreateInstanceShare(id, &api.InstanceSharesPost{
+		Type:        adbShareType,
+		Description: args.Description,
+		ExpiryAt:    &expiryAt,
+	})
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &ADBShare{
+		ID:         resp.Metadata.ID,
+		InstanceID: id,
+		URL:        resp.Metadata.URL,
+		c:          c,
+		ttl:        ttl,
+		listener:   l,
+		expiryAt:   time.Unix(resp.Metadata.ExpiryAt, 0),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	s.wg.Add(2)
+	go s.serve()
+	go s.renew()
+
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+
+	return s, nil
+}
+
+func localShareAddress(addr string) (string, error) {
+	if len(addr) == 0 {
+		addr = "127.0.0.1:0"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if len(port) == 0 || port == "0" {
+		p, err := network.AllocatePort()
+		if err != nil {
+			return "", err
+		}
+		port = strconv.Itoa(p)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// Addr returns the local address to use with `adb connect`
+func (s *ADBShare) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// ExpiryAt returns the time the share currently expires at
+func (s *ADBShare) ExpiryAt() time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.expiryAt
+}
+
+// Close stops the local listener and deletes the share. If the deletion
+// doesn't finish within 30 seconds a timeout error is returned while AMS
+// keeps deleting the share.
+func (s *ADBShare) Close() error {
+	s.closeOnce.Do(func() {
+		s.cancel()
+		s.listener.Close()
+		s.wg.Wait()
+
+		op, err := s.c.DeleteInstanceShareByID(s.InstanceID, s.ID)
+		if err != nil {
+			s.closeErr = err
+			return
+		}
+		// Waiting with a timeout context would cancel the deletion once it
+		// expires. Stop waiting instead and let the deletion finish.
+		done := make(chan error, 1)
+		go func() { done <- op.Wait(context.Background()) }()
+		select {
+		case s.closeErr = <-done:
+		case <-time.After(adbShareDeleteTimeout):
+			s.closeErr = errs.NewErrTimeout(fmt.Sprintf("deletion of share %s", s.ID))
+		}
+	})
+	return s.closeErr
+}
+
+func (s *ADBShare) renew() {
+	defer s.wg.Done()
+	for {
+		s.lock.Lock()
+		next := time.Until(s.expiryAt) - s.ttl/adbShareRenewFactor
+		s.lock.Unlock()
+		if next < 0 {
+			next = 0
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(next):
+		}
+
+		expiryAt := time.Now().Add(s.ttl).Unix()
+		err := s.c.UpdateInstanceShareByID(s.InstanceID, s.ID, &api.InstanceSharePatch{
+			ExpiryAt: &expiryAt,
+		})
+		if err != nil {
+			log.Printf("Failed to renew share %s of instance %s: %v", s.ID, s.InstanceID, err)
+			// Retry a bit later, still well ahead of the current expiry
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(s.ttl / (2 * adbShareRenewFactor)):
+			}
+			continue
+		}
+
+		s.lock.Lock()
+		s.expiryAt = time.Unix(expiryAt, 0)
+		s.lock.Unlock()
+	}
+}
+
+func (s *ADBShare) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.proxy(conn)
+		}()
+	}
+}
+
+// proxy forwards a single local ADB connection to the share
+func (s *ADBShare) proxy(conn net.Conn) {
+	defer conn.Close()
+
+	wsURL := s.URL
+	if strings.HasPrefix(wsURL, "https://") {
+		wsURL = "wss://" + strings.TrimPrefix(wsURL, "https://")
+	} else if strings.HasPrefix(wsURL, "http://") {
+		wsURL = "ws://" + strings.TrimPrefix(wsURL, "http://")
+	}
+
+	ws, err := s.c.rawWebsocket(wsURL)
+	if err != nil {
+		log.Printf("Failed to connect to share %s: %v", s.ID, err)
+		return
+	}
+	defer ws.Close()
+
+	readDone, writeDone := network.WebsocketMirror(ws, conn, conn, nil, nil)
+	select {
+	case <-readDone:
+	case <-writeDone:
+	case <-s.ctx.Done():
+	}
+}
+
+// String returns a human readable description of the share
+func (s *ADBShare) String() string {
+	return fmt.Sprintf("adb share %s of instance %s on %s", s.ID, s.InstanceID, s.Addr())
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+// UploadProgress describes how far an upload has progressed
+type UploadProgress struct {
+	// SentBytes is the number of bytes of the payload sent so far
+	SentBytes int64
+	// TotalBytes is the size of the payload or -1 if it is not known
+	TotalBytes int64
+}
+
+// UploadProgressFunc is called every time a part of the payload was sent
+type UploadProgressFunc func(progress UploadProgress)
+
+// UploadSource describes a payload which is uploaded from a stream rather than
+// from a file on disk
+type UploadSource struct {
+	// Reader provides the payload
+	Reader io.Reader
+	// Size of the payload in bytes. A value of zero or less means the size
+	// is not known upfront.
+	Size int64
+	// Fingerprint is the SHA-256 checksum of the payload. If empty, the
+	// payload is spooled before the upload to compute it.
+	Fingerprint string
+	// Progress is called as the payload is sent, if set
+	Progress UploadProgressFunc
+}
+
+// progressReader reports the bytes read through a typed callback and ensures
+// the stream matches the announced size
+type progressReader struct {
+	r        io.Reader
+	sent     int64
+	total    int64
+	progress UploadProgressFunc
+}
+
+// Read implements io.Reader interface
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.sent += int64(n)
+	if p.total >= 0 {
+		if p.sent > p.total {
+			return n, fmt.Errorf("payload is larger than the announced size of %d bytes", p.total)
+		}
+		if err == io.EOF && p.sent < p.total {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+	if n > 0 && p.progress != nil {
+		p.progress(UploadProgress{SentBytes: p.sent, TotalBytes: p.total})
+	}
+	return n, err
+}
+
+func (c *clientImpl) uploadFromReader(ctx context.Context, httpOp, apiPath string, params client.QueryParams, src *UploadSource, details interface{}) (client.Operation, error) {
+	if src == nil || src.Reader == nil {
+		return nil, errs.NewInvalidArgument("source")
+	}
+
+	// The stream can't be rewound, so only peek at the magic bytes
+	r := bufio.NewReader(src.Reader)
+	head, err := r.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	if err := c.checkPackageFormat(packages.DetectPackageFormatFromHeader(head)); err != nil {
+		return nil, err
+	}
+
+	size := int64(-1)
+	if src.Size > 0 {
+		size = src.Size
+	}
+
+	var payload io.Reader = r
+	fingerprint := src.Fingerprint
+	if len(fingerprint) == 0 {
+		spooled, sum, err := spoolPayload(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute fingerprint of payload: %w", err)
+		}
+		defer spooled.Close()
+
+		if size >= 0 && spooled.size != size {
+			return nil, fmt.Errorf("payload has %d bytes but %d were announced", spooled.size, size)
+		}
+		size = spooled.size
+		payload = spooled
+		fingerprint = sum
+	}
+
+	u := &progressReader{r: payload, total: size, progress: src.Progress}
+	return c.sendPayload(ctx, httpOp, apiPath, params, u, size, fingerprint, details)
+}
@@ -30,4 +30,8 @@ const (
 	// AndroidPackageNamePattern describes the regular expression to validate an
 	// Android package name
 	AndroidPackageNamePattern = `^([A-Za-z]{1}[A-Za-z\d_]*\.){1,}[A-Za-z][A-Za-z\d_]*$`
+
+	// InstanceTypePattern describes the regular expression to validate an
+	// instance type
+	InstanceTypePattern = `^[ag]\d+\.\d+$`
 )
@@ -0,0 +1,486 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package uploads is an experimental client for resumable chunked image
+// uploads. AMS doesn't implement the protocol yet, so the endpoints, headers
+// and types of this package may change or go away once it does. Until then
+// the package can only be used against servers implementing the protocol
+// described below, such as the fake server in amstest.
+//
+// The protocol is announced with the "chunked_upload_support" API extension:
+//
+//   - POST /1.0/uploads with an UploadsPost starts a new upload or returns
+//     the incomplete upload for the same fingerprint and size, including the
+//     chunks already received. The server decides on the final chunk size.
+//   - PUT /1.0/uploads/<id> sends a single chunk. The X-AMS-Upload-Offset
+//     header carries the byte offset of the chunk in the payload and the
+//     X-AMS-Chunk-Fingerprint header its SHA-256 checksum.
+//   - GET /1.0/uploads/<id> returns the state of the upload and DELETE
+//     /1.0/uploads/<id> discards it.
+//   - Once complete, the upload is consumed by sending the regular image
+//     request without a body but with the X-AMS-Upload-ID header set.
+//
+// If the extension isn't announced the payload is sent in a single request.
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	restclient "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const (
+	// Extension is the API extension a server announces when it supports
+	// chunked uploads
+	Extension = "chunked_upload_support"
+
+	defaultChunkSize   = 16 * 1024 * 1024
+	defaultParallelism = 4
+	defaultRetries     = 3
+	retryDelay         = time.Second
+	// Chunks are only aborted when they stop making progress for this long
+	transferIdleTimeout = 300 * time.Second
+)
+
+// UploadsPost represents the fields to start or resume a chunked upload
+type UploadsPost struct {
+	// SHA-256 fingerprint of the complete payload. An incomplete upload with
+	// the same fingerprint is resumed instead of starting a new one.
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	// Size of the complete payload in bytes
+	Size int64 `json:"size" yaml:"size"`
+	// Size of a single chunk in bytes as requested by the client. The server
+	// may choose a different chunk size.
+	ChunkSize int64 `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty"`
+}
+
+// Upload represents the state of a chunked upload
+type Upload struct {
+	// ID of the upload
+	ID string `json:"id" yaml:"id"`
+	// SHA-256 fingerprint of the complete payload
+	Fingerprint string `json:"fingerprint" yaml:"fingerprint"`
+	// Size of the complete payload in bytes
+	Size int64 `json:"size" yaml:"size"`
+	// Size of a single chunk in bytes. Only the last chunk may be smaller.
+	ChunkSize int64 `json:"chunk_size" yaml:"chunk_size"`
+	// Indexes of all chunks the server has received so far
+	ReceivedChunks []int64 `json:"received_chunks" yaml:"received_chunks"`
+	// Complete is set once all chunks were received and the assembled payload
+	// matches the fingerprint
+	Complete bool `json:"complete" yaml:"complete"`
+	// Unix timestamp (UTC) after which an incomplete upload is discarded
+	ExpiryAt int64 `json:"expiry_at" yaml:"expiry_at"`
+}
+
+// Args provides details on how to upload a payload in chunks
+type Args struct {
+	// ChunkSize is the preferred size of a single chunk in bytes. The server
+	// may choose a different one. Defaults to 16 MiB.
+	ChunkSize int64
+	// Parallelism is the number of chunks uploaded at the same time.
+	// Defaults to 4.
+	Parallelism int
+	// Retries is the number of times a single chunk is retried before the
+	// upload fails. Defaults to 3.
+	Retries int
+	// Progress is called as chunks are sent, if set
+	Progress client.UploadProgressFunc
+}
+
+// uploader holds everything needed to upload a single payload
+type uploader struct {
+	c    client.Client
+	rest restclient.Client
+	args Args
+}
+
+func newUploader(c client.Client, args *Args) (*uploader, error) {
+	rest, ok := c.(restclient.Client)
+	if !ok {
+		return nil, errs.NewErrNotSupported("chunked uploads with this client")
+	}
+	u := &uploader{c: c, rest: rest}
+	if args != nil {
+		u.args = *args
+	}
+	return u, nil
+}
+
+// AddImage adds a new image with the given payload. The payload is uploaded
+// in chunks which can be resumed after a failure by calling AddImage again
+// with the same payload. If the server doesn't support chunked uploads the
+// payload is sent as a single stream.
+func AddImage(ctx context.Context, c client.Client, name, packagePath string, isDefault bool, args *Args) (restclient.Operation, error) {
+	u, err := newUploader(c, args)
+	if err != nil {
+		return nil, err
+	}
+	details := api.ImagesPost{
+		Name:    name,
+		Default: isDefault,
+	}
+	fallback := func(src *client.UploadSource) (restclient.Operation, error) {
+		return c.AddImageFromReader(name, isDefault, src)
+	}
+	return u.upload(ctx, "POST", restclient.APIPath("images"), packagePath, details, fallback)
+}
+
+// UpdateImage updates an existing image with the given payload which is
+// uploaded in chunks in the same way as for AddImage
+func UpdateImage(ctx context.Context, c client.Client, id, packagePath string, args *Args) (restclient.Operation, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	u, err := newUploader(c, args)
+	if err != nil {
+		return nil, err
+	}
+	fallback := func(src *client.UploadSource) (restclient.Operation, error) {
+		return c.UpdateImageFromReader(id, src)
+	}
+	return u.upload(ctx, "PATCH", restclient.APIPath("images", id), packagePath, api.ImagePatch{}, fallback)
+}
+
+// Retrieve returns the current state of a chunked upload
+func Retrieve(ctx context.Context, c client.Client, id string) (*Upload, error) {
+	if len(id) == 0 {
+		return nil, errs.NewInvalidArgument("id")
+	}
+	u, err := newUploader(c, nil)
+	if err != nil {
+		return nil, err
+	}
+	return u.retrieve(ctx, id)
+}
+
+// Delete aborts a chunked upload and discards all chunks received so far
+func Delete(ctx context.Context, c client.Client, id string) error {
+	if len(id) == 0 {
+		return errs.NewInvalidArgument("id")
+	}
+	u, err := newUploader(c, nil)
+	if err != nil {
+		return err
+	}
+	_, err = u.rest.QueryStructContext(ctx, "DELETE", restclient.APIPath("uploads", id), nil, nil, nil, "", nil)
+	return err
+}
+
+func (u *uploader) upload(ctx context.Context, httpOp, apiPath, packagePath string, details interface{}, fallback func(src *client.UploadSource) (restclient.Operation, error)) (restclient.Operation, error) {
+	if err := u.checkPackageFormat(packagePath); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(packagePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	fingerprint := fmt.Sprintf("%x", hasher.Sum(nil))
+
+	supported, err := u.c.HasExtension(Extension)
+	if err != nil {
+		return nil, err
+	}
+	if !supported {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return fallback(&client.UploadSource{
+			Reader:      file,
+			Size:        fi.Size(),
+			Fingerprint: fingerprint,
+			Progress:    u.args.Progress,
+		})
+	}
+
+	upload, err := u.start(ctx, fingerprint, fi.Size())
+	if err != nil {
+		return nil, err
+	}
+	if err := u.uploadChunks(ctx, upload, file); err != nil {
+		return nil, err
+	}
+
+	// Let the server confirm it has assembled the payload correctly before
+	// the upload is consumed
+	if err := u.verify(ctx, upload.ID, fingerprint, fi.Size()); err != nil {
+		return nil, err
+	}
+
+	request, err := json.Marshal(details)
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal request metadata: %v", err)
+	}
+	header := http.Header{
+		"X-AMS-Fingerprint": []string{fingerprint},
+		"X-AMS-Request":     []string{string(request)},
+		"X-AMS-Upload-ID":   []string{upload.ID},
+	}
+	op, _, err := u.rest.QueryOperationContext(ctx, httpOp, apiPath, nil, header, nil, "")
+	return op, err
+}
+
+// checkPackageFormat returns an error if the server doesn't accept packages
+// in the format of the given one. Unknown formats are left for the server to
+// reject.
+func (u *uploader) checkPackageFormat(packagePath string) error {
+	format, err := packages.DetectPackageFormat(packagePath)
+	if err != nil {
+		return err
+	}
+	if format == packages.PackageFormatUnknown {
+		format = packages.PackageFormatFromExtension(packagePath)
+	}
+	if format == packages.PackageFormatUnknown {
+		return nil
+	}
+	ext := format.RequiredExtension()
+	if len(ext) == 0 {
+		return nil
+	}
+	supported, err := u.c.HasExtension(ext)
+	if err != nil {
+		return err
+	}
+	if !supported {
+		return errs.NewErrNotSupported(fmt.Sprintf("api extension %q", ext))
+	}
+	return nil
+}
+
+// start starts a new chunked upload or resumes an existing one with the same
+// fingerprint
+func (u *uploader) start(ctx context.Context, fingerprint string, size int64) (*Upload, error) {
+	chunkSize := u.args.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+
+	b, err := json.Marshal(UploadsPost{
+		Fingerprint: fingerprint,
+		Size:        size,
+		ChunkSize:   chunkSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	upload := &Upload{}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+	_, err = u.rest.QueryStructContext(ctx, "POST", restclient.APIPath("uploads"), nil, header, bytes.NewReader(b), "", upload)
+	if err != nil {
+		return nil, err
+	}
+	if upload.ChunkSize <= 0 {
+		return nil, fmt.Errorf("server returned invalid chunk size %d", upload.ChunkSize)
+	}
+	if upload.Fingerprint != fingerprint || upload.Size != size {
+		return nil, fmt.Errorf("server returned upload %s for a different payload", upload.ID)
+	}
+	return upload, nil
+}
+
+// uploadChunks uploads all chunks the server hasn't received yet
+func (u *uploader) uploadChunks(ctx context.Context, upload *Upload, f io.ReaderAt) error {
+	parallelism := u.args.Parallelism
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	retries := u.args.Retries
+	if retries <= 0 {
+		retries = defaultRetries
+	}
+
+	numChunks := (upload.Size + upload.ChunkSize - 1) / upload.ChunkSize
+	received := map[int64]bool{}
+	for _, idx := range upload.ReceivedChunks {
+		received[idx] = true
+	}
+
+	var (
+		lock sync.Mutex
+		sent int64
+	)
+	reportProgress := func(n int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		sent += n
+		if u.args.Progress != nil {
+			u.args.Progress(client.UploadProgress{SentBytes: sent, TotalBytes: upload.Size})
+		}
+	}
+
+	pending := make(chan int64)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		firstErr error
+	)
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range pending {
+				if ctx.Err() != nil {
+					continue
+				}
+				err := u.uploadChunkWithRetry(ctx, upload, f, idx, retries)
+				if err != nil {
+					lock.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					lock.Unlock()
+					cancel()
+					continue
+				}
+				reportProgress(chunkLength(upload, idx))
+			}
+		}()
+	}
+
+	for idx := int64(0); idx < numChunks; idx++ {
+		if received[idx] {
+			reportProgress(chunkLength(upload, idx))
+			continue
+		}
+		select {
+		case pending <- idx:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(pending)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+func chunkLength(upload *Upload, idx int64) int64 {
+	offset := idx * upload.ChunkSize
+	if offset+upload.ChunkSize > upload.Size {
+		return upload.Size - offset
+	}
+	return upload.ChunkSize
+}
+
+func (u *uploader) uploadChunkWithRetry(ctx context.Context, upload *Upload, f io.ReaderAt, idx int64, retries int) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * retryDelay):
+			}
+		}
+
+		err = u.uploadChunk(ctx, upload, f, idx)
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to upload chunk %d of upload %s: %w", idx, upload.ID, err)
+}
+
+func (u *uploader) uploadChunk(ctx context.Context, upload *Upload, f io.ReaderAt, idx int64) error {
+	offset := idx * upload.ChunkSize
+	length := chunkLength(upload, idx)
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, io.NewSectionReader(f, offset, length)); err != nil {
+		return err
+	}
+
+	header := http.Header{
+		"Content-Type":            []string{"application/octet-stream"},
+		"Content-Length":          []string{strconv.FormatInt(length, 10)},
+		"X-AMS-Upload-Offset":     []string{strconv.FormatInt(offset, 10)},
+		"X-AMS-Chunk-Fingerprint": []string{fmt.Sprintf("%x", hasher.Sum(nil))},
+	}
+	body := io.NewSectionReader(f, offset, length)
+	_, err := u.rest.QueryStructContext(u.transferContext(ctx), "PUT", restclient.APIPath("uploads", upload.ID), nil, header, body, "", nil)
+	return err
+}
+
+// transferContext returns a context for requests transferring chunks. Such
+// requests are not limited in their overall duration but only aborted when
+// they stop making progress. Timeouts already attached to ctx are kept.
+func (u *uploader) transferContext(ctx context.Context) context.Context {
+	if _, ok := restclient.TimeoutsFromContext(ctx); ok {
+		return ctx
+	}
+	timeouts := u.rest.Timeouts()
+	timeouts.Idle = transferIdleTimeout
+	timeouts.Overall = 0
+	return restclient.WithTimeouts(ctx, timeouts)
+}
+
+// verify ensures the server received the complete payload and successfully
+// verified it against its fingerprint
+func (u *uploader) verify(ctx context.Context, id, fingerprint string, size int64) error {
+	upload, err := u.retrieve(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !upload.Complete {
+		return fmt.Errorf("upload %s is incomplete: server received only %d chunks", id, len(upload.ReceivedChunks))
+	}
+	if upload.Fingerprint != fingerprint || upload.Size != size {
+		return fmt.Errorf("upload %s does not match the local payload", id)
+	}
+	return nil
+}
+
+func (u *uploader) retrieve(ctx context.Context, id string) (*Upload, error) {
+	upload := &Upload{}
+	_, err := u.rest.QueryStructContext(ctx, "GET", restclient.APIPath("uploads", id), nil, nil, nil, "", upload)
+	return upload, err
+}
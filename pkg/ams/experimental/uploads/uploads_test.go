@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package uploads
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+const testChunkSize = 4096
+
+// newTestClient returns a client talking to the given fake server
+func newTestClient(t *testing.T, s *amstest.Server) client.Client {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// writeTestImage writes a gzip compressed tarball with incompressible content
+// so the payload spans several chunks
+func writeTestImage(t *testing.T, numChunks int) (string, []byte) {
+	t.Helper()
+	content := make([]byte, numChunks*testChunkSize)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	hdr := &tar.Header{Name: "rootfs.img", Mode: 0644, Size: int64(len(content))}
+	if err := tw.WriteHeader(hdr); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(t.TempDir(), "image.tar.gz")
+	if err := os.WriteFile(p, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p, buf.Bytes()
+}
+
+func numChunksOf(data []byte) int {
+	return (len(data) + testChunkSize - 1) / testChunkSize
+}
+
+func checkPayload(t *testing.T, s *amstest.Server, data []byte, chunked bool) {
+	t.Helper()
+	payloads := s.Payloads()
+	if len(payloads) != 1 {
+		t.Fatalf("expected 1 payload, got %d", len(payloads))
+	}
+	if payloads[0].Chunked != chunked {
+		t.Fatalf("expected chunked=%v, got %v", chunked, payloads[0].Chunked)
+	}
+	if !bytes.Equal(payloads[0].Data, data) {
+		t.Fatal("received payload does not match the local one")
+	}
+}
+
+func TestAddImageParallel(t *testing.T) {
+	s := amstest.NewServer(Extension)
+	defer s.Close()
+	s.SetMaxChunkSize(testChunkSize)
+
+	c := newTestClient(t, s)
+	p, data := writeTestImage(t, 8)
+
+	var sent int64
+	args := &Args{
+		Parallelism: 4,
+		Progress:    func(p client.UploadProgress) { sent = p.SentBytes },
+	}
+	if _, err := AddImage(context.Background(), c, "test", p, false, args); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPayload(t, s, data, true)
+	if n := s.ChunkRequests(); n != numChunksOf(data) {
+		t.Fatalf("expected %d chunk requests, got %d", numChunksOf(data), n)
+	}
+	if sent != int64(len(data)) {
+		t.Fatalf("expected progress to report %d bytes, got %d", len(data), sent)
+	}
+}
+
+func TestAddImageRetry(t *testing.T) {
+	s := amstest.NewServer(Extension)
+	defer s.Close()
+	s.SetMaxChunkSize(testChunkSize)
+	s.FailNextChunks(1)
+
+	c := newTestClient(t, s)
+	p, data := writeTestImage(t, 4)
+
+	args := &Args{Parallelism: 1, Retries: 1}
+	if _, err := AddImage(context.Background(), c, "test", p, false, args); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPayload(t, s, data, true)
+	if n := s.ChunkRequests(); n != numChunksOf(data)+1 {
+		t.Fatalf("expected %d chunk requests, got %d", numChunksOf(data)+1, n)
+	}
+}
+
+func TestAddImageResume(t *testing.T) {
+	s := amstest.NewServer(Extension)
+	defer s.Close()
+	s.SetMaxChunkSize(testChunkSize)
+
+	c := newTestClient(t, s)
+	p, data := writeTestImage(t, 6)
+
+	// Start an upload which fails for good after the first chunk arrived
+	f, err := os.Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	u, err := newUploader(c, &Args{ChunkSize: testChunkSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := u.start(context.Background(), fmt.Sprintf("%x", sha256.Sum256(data)), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := u.uploadChunk(context.Background(), upload, f, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.FailNextChunks(2)
+	err = u.uploadChunkWithRetry(context.Background(), upload, f, 1, 1)
+	if err == nil {
+		t.Fatal("expected chunk upload to fail")
+	}
+
+	retrieved, err := Retrieve(context.Background(), c, upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retrieved.ReceivedChunks) != 1 || retrieved.Complete {
+		t.Fatalf("expected 1 received chunk of an incomplete upload, got %v", retrieved.ReceivedChunks)
+	}
+
+	// The second attempt only sends the chunks the server is still missing
+	before := s.ChunkRequests()
+	args := &Args{Parallelism: 2}
+	if _, err := AddImage(context.Background(), c, "test", p, false, args); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPayload(t, s, data, true)
+	if n := s.ChunkRequests() - before; n != numChunksOf(data)-1 {
+		t.Fatalf("expected %d chunk requests after resuming, got %d", numChunksOf(data)-1, n)
+	}
+}
+
+func TestAddImageFallback(t *testing.T) {
+	s := amstest.NewServer()
+	defer s.Close()
+
+	c := newTestClient(t, s)
+	p, data := writeTestImage(t, 4)
+
+	if _, err := AddImage(context.Background(), c, "test", p, false, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	checkPayload(t, s, data, false)
+	if n := s.ChunkRequests(); n != 0 {
+		t.Fatalf("expected no chunk requests, got %d", n)
+	}
+}
+
+func TestRetrieveCanceled(t *testing.T) {
+	s := amstest.NewServer(Extension)
+	defer s.Close()
+
+	c := newTestClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Retrieve(ctx, c, "unknown"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled request, got %v", err)
+	}
+}
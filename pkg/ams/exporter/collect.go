@@ -0,0 +1,164 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"fmt"
+	"io"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+)
+
+// WriteMetrics writes the current state in the Prometheus text format
+func (e *Exporter) WriteMetrics(w io.Writer) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	families := []*family{}
+	families = append(families, e.instanceMetrics()...)
+	families = append(families, e.nodeMetrics()...)
+	families = append(families, e.imageMetrics()...)
+	families = append(families, e.applicationMetrics()...)
+	families = append(families, e.operationMetrics()...)
+	families = append(families, e.taskMetrics()...)
+	families = append(families, e.exporterMetrics()...)
+	return writeFamilies(w, families...)
+}
+
+func (e *Exporter) instanceMetrics() []*family {
+	instances := newGauge("ams_instances", "Number of instances by status, application and node.",
+		"status", "application", "node")
+	for _, inst := range e.instances {
+		instances.add(1, inst.Status, inst.AppName, inst.Node)
+	}
+	return []*family{instances}
+}
+
+func (e *Exporter) nodeMetrics() []*family {
+	status := newGauge("ams_node_status", "Status of the node, 1 for the current status.", "node", "status")
+	schedulable := newGauge("ams_node_schedulable", "Whether new instances can be scheduled on the node.", "node")
+	nodeInstances := newGauge("ams_node_instances", "Number of instances on the node.", "node")
+	cpus := newGauge("ams_node_cpus", "CPUs available for instances including overcommitment.", "node")
+	cpusAllocated := newGauge("ams_node_cpus_allocated", "CPUs allocated by instances.", "node")
+	memory := newGauge("ams_node_memory_bytes", "Memory available for instances including overcommitment.", "node")
+	memoryAllocated := newGauge("ams_node_memory_allocated_bytes", "Memory allocated by instances.", "node")
+	disk := newGauge("ams_node_disk_bytes", "Disk space available for instances.", "node")
+	diskAllocated := newGauge("ams_node_disk_allocated_bytes", "Disk space allocated by instances.", "node")
+	gpuSlots := newGauge("ams_node_gpu_slots", "GPU slots available on the node.", "node")
+	gpuSlotsAllocated := newGauge("ams_node_gpu_slots_allocated", "GPU slots allocated by instances.", "node")
+	vpuSlots := newGauge("ams_node_vpu_slots", "VPU slots available on the node.", "node")
+	vpuSlotsAllocated := newGauge("ams_node_vpu_slots_allocated", "VPU slots allocated by instances.", "node")
+	families := []*family{
+		status, schedulable, nodeInstances,
+		cpus, cpusAllocated, memory, memoryAllocated, disk, diskAllocated,
+		gpuSlots, gpuSlotsAllocated, vpuSlots, vpuSlotsAllocated,
+	}
+
+	instances := make([]api.Instance, 0, len(e.instances))
+	for _, inst := range e.instances {
+		instances = append(instances, inst)
+	}
+	cluster, err := capacity.Compute(e.nodes, instances)
+	if err != nil {
+		e.opts.OnError(fmt.Errorf("failed to compute node capacity: %w", err))
+		return families
+	}
+
+	for n := range cluster.Nodes {
+		node := &cluster.Nodes[n]
+		status.set(1, node.Node, node.Status.String())
+		schedulable.set(boolValue(node.Schedulable()), node.Node)
+		nodeInstances.set(float64(node.Instances), node.Node)
+		cpus.set(float64(node.Total.CPUs), node.Node)
+		cpusAllocated.set(float64(node.Committed.CPUs), node.Node)
+		memory.set(float64(node.Total.Memory), node.Node)
+		memoryAllocated.set(float64(node.Committed.Memory), node.Node)
+		disk.set(float64(node.Total.DiskSize), node.Node)
+		diskAllocated.set(float64(node.Committed.DiskSize), node.Node)
+		gpuSlots.set(float64(node.Total.GPUSlots), node.Node)
+		gpuSlotsAllocated.set(float64(node.Committed.GPUSlots), node.Node)
+		vpuSlots.set(float64(node.Total.VPUSlots), node.Node)
+		vpuSlotsAllocated.set(float64(node.Committed.VPUSlots), node.Node)
+	}
+	return families
+}
+
+func (e *Exporter) imageMetrics() []*family {
+	images := newGauge("ams_images", "Number of images by status.", "status")
+	versions := newGauge("ams_image_versions", "Number of image versions by image and status.", "image", "status")
+	for _, img := range e.images {
+		images.add(1, img.Status)
+		for _, v := range img.Versions {
+			versions.add(1, img.Name, v.Status)
+		}
+	}
+	return []*family{images, versions}
+}
+
+func (e *Exporter) applicationMetrics() []*family {
+	applications := newGauge("ams_applications", "Number of applications by status.", "status")
+	versions := newGauge("ams_application_versions", "Number of application versions by application and status.",
+		"application", "status")
+	for _, app := range e.applications {
+		applications.add(1, app.Status)
+		for _, v := range app.Versions {
+			versions.add(1, app.Name, v.Status)
+		}
+	}
+	return []*family{applications, versions}
+}
+
+func (e *Exporter) operationMetrics() []*family {
+	operations := newGauge("ams_operations", "Number of operations in flight by class and status.", "class", "status")
+	for _, op := range e.operations {
+		operations.add(1, op.Class, op.Status)
+	}
+	return []*family{operations}
+}
+
+func (e *Exporter) taskMetrics() []*family {
+	tasks := newGauge("ams_tasks", "Number of tasks by status and object type.", "status", "object_type")
+	for _, t := range e.tasks {
+		tasks.add(1, t.Status, t.ObjectType)
+	}
+	return []*family{tasks}
+}
+
+func (e *Exporter) exporterMetrics() []*family {
+	synced := newGauge("ams_exporter_synced", "Whether instances and operations have been listed at least once.")
+	synced.set(boolValue(e.synced))
+	lastRefresh := newGauge("ams_exporter_last_refresh_timestamp_seconds",
+		"Time nodes, applications, images and tasks were last listed.")
+	if !e.lastSync.IsZero() {
+		lastRefresh.set(float64(e.lastSync.Unix()))
+	}
+	events := newCounter("ams_exporter_events_total", "Number of events received by type.", "type")
+	for typ, n := range e.events {
+		events.set(n, typ)
+	}
+	return []*family{synced, lastRefresh, events}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,337 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package exporter exposes the state of an AMS cluster as Prometheus metrics.
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+	restclient "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const (
+	defaultRefreshInterval = time.Minute
+	defaultResyncInterval  = 10 * time.Minute
+)
+
+// Options configures an Exporter
+type Options struct {
+	// RefreshInterval is how often nodes, applications, images and tasks
+	// are listed again. These have no lifecycle events. Defaults to one
+	// minute.
+	RefreshInterval time.Duration
+	// ResyncInterval is how often instances and operations are listed
+	// again to correct events which got lost. Defaults to ten minutes.
+	ResyncInterval time.Duration
+	// OnError is called for errors which don't stop the exporter. Errors
+	// are logged by default.
+	OnError func(error)
+}
+
+// Exporter keeps a copy of the cluster state and renders it as metrics.
+// Instances and operations are updated from events, everything else is
+// listed periodically.
+type Exporter struct {
+	c    client.Client
+	opts Options
+
+	lock         sync.Mutex
+	nodes        []api.Node
+	applications []api.Application
+	images       []api.Image
+	tasks        []api.Task
+	instances    map[string]api.Instance
+	operations   map[string]*restapi.Operation
+	// finished holds operations which completed since the last resync so
+	// that events arriving out of order don't bring them back
+	finished map[string]bool
+	synced   bool
+	lastSync time.Time
+	events   map[string]float64
+}
+
+// New returns an exporter for the cluster the client is connected to
+func New(c client.Client, opts *Options) (*Exporter, error) {
+	if c == nil {
+		return nil, errs.NewInvalidArgument("client")
+	}
+	e := &Exporter{
+		c:          c,
+		instances:  map[string]api.Instance{},
+		operations: map[string]*restapi.Operation{},
+		finished:   map[string]bool{},
+		events:     map[string]float64{},
+	}
+	if opts != nil {
+		e.opts = *opts
+	}
+	if e.opts.RefreshInterval == 0 {
+		e.opts.RefreshInterval = defaultRefreshInterval
+	}
+	if e.opts.ResyncInterval == 0 {
+		e.opts.ResyncInterval = defaultResyncInterval
+	}
+	if e.opts.OnError == nil {
+		e.opts.OnError = func(err error) { log.Print(err) }
+	}
+	return e, nil
+}
+
+// Run keeps the exporter up to date until the context is cancelled. When
+// the event stream drops, the exporter subscribes again and lists all
+// instances and operations to catch up on what it missed.
+func (e *Exporter) Run(ctx context.Context) error {
+	messages := make(chan map[string]interface{}, 64)
+	refresh := time.NewTicker(e.opts.RefreshInterval)
+	defer refresh.Stop()
+	resync := time.NewTicker(e.opts.ResyncInterval)
+	defer resync.Stop()
+
+	var listener *restclient.EventListener
+	defer func() {
+		if listener != nil {
+			listener.Disconnect()
+		}
+	}()
+
+	if err := e.Refresh(); err != nil {
+		e.opts.OnError(err)
+	}
+
+	for {
+		if listener == nil || !listener.IsActive() {
+			var err error
+			listener, err = e.subscribe(ctx, messages)
+			if err != nil {
+				listener = nil
+				e.opts.OnError(fmt.Errorf("failed to listen for events: %w", err))
+			} else if err := e.Resync(); err != nil {
+				e.opts.OnError(err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-messages:
+			e.handleEvent(msg)
+		case <-refresh.C:
+			if err := e.Refresh(); err != nil {
+				e.opts.OnError(err)
+			}
+		case <-resync.C:
+			if err := e.Resync(); err != nil {
+				e.opts.OnError(err)
+			}
+		}
+	}
+}
+
+func (e *Exporter) subscribe(ctx context.Context, messages chan map[string]interface{}) (*restclient.EventListener, error) {
+	listener, err := e.c.GetEvents()
+	if err != nil {
+		return nil, err
+	}
+	types := []string{string(api.EventTypeLifecycle), string(api.EventTypeOperation)}
+	_, err = listener.AddHandler(types, func(msg interface{}) {
+		m, ok := msg.(map[string]interface{})
+		if !ok {
+			return
+		}
+		select {
+		case messages <- m:
+		case <-ctx.Done():
+		}
+	})
+	if err != nil {
+		listener.Disconnect()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Refresh lists nodes, applications, images and tasks
+func (e *Exporter) Refresh() error {
+	nodes, err := e.c.ListNodes()
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	applications, err := e.c.ListApplications()
+	if err != nil {
+		return fmt.Errorf("failed to list applications: %w", err)
+	}
+	images, err := e.c.ListImages()
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+	tasks, err := e.c.ListTasks()
+	if err != nil {
+		return fmt.Errorf("failed to list tasks: %w", err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.nodes = nodes
+	e.applications = applications
+	e.images = images
+	e.tasks = tasks
+	e.lastSync = time.Now()
+	return nil
+}
+
+// Resync lists all instances and operations, replacing what was built up
+// from events
+func (e *Exporter) Resync() error {
+	instances, err := e.c.ListInstances()
+	if err != nil {
+		return fmt.Errorf("failed to list instances: %w", err)
+	}
+	operations, err := e.c.ListOperations()
+	if err != nil {
+		return fmt.Errorf("failed to list operations: %w", err)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.instances = make(map[string]api.Instance, len(instances))
+	for _, inst := range instances {
+		e.instances[inst.ID] = inst
+	}
+	e.operations = map[string]*restapi.Operation{}
+	e.finished = map[string]bool{}
+	for _, ops := range operations {
+		for _, op := range ops {
+			if op != nil && !op.StatusCode.IsFinal() {
+				e.operations[op.ID] = op
+			}
+		}
+	}
+	e.synced = true
+	return nil
+}
+
+// handleEvent applies a single event to the state
+func (e *Exporter) handleEvent(msg map[string]interface{}) {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	var event struct {
+		Type     api.EventType   `json:"type"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(b, &event); err != nil {
+		return
+	}
+
+	e.lock.Lock()
+	e.events[string(event.Type)]++
+	e.lock.Unlock()
+
+	switch event.Type {
+	case api.EventTypeLifecycle:
+		var lifecycle api.LifecycleEvent
+		if err := json.Unmarshal(event.Metadata, &lifecycle); err != nil {
+			return
+		}
+		e.handleLifecycleEvent(&lifecycle)
+	case api.EventTypeOperation:
+		var op restapi.Operation
+		if err := json.Unmarshal(event.Metadata, &op); err != nil || len(op.ID) == 0 {
+			return
+		}
+		e.handleOperation(&op)
+	}
+}
+
+func (e *Exporter) handleLifecycleEvent(event *api.LifecycleEvent) {
+	action := string(event.Action)
+	if !strings.HasPrefix(action, "instance-") && !strings.HasPrefix(action, "container-") {
+		return
+	}
+	id := path.Base(event.Source)
+	if len(id) == 0 || id == "." || id == "/" {
+		return
+	}
+
+	switch event.Action {
+	case api.LifecycleEventActionInstanceRemoved, api.LifecycleEventActionContainerRemoved:
+		e.lock.Lock()
+		delete(e.instances, id)
+		e.lock.Unlock()
+		return
+	}
+
+	inst, _, err := e.c.RetrieveInstanceByID(id)
+	if isNotFound(err) {
+		// The instance was removed right after the event
+		e.lock.Lock()
+		delete(e.instances, id)
+		e.lock.Unlock()
+		return
+	} else if err != nil {
+		// Leave correcting the state to the next resync rather than listing
+		// everything on every failure
+		e.opts.OnError(fmt.Errorf("failed to retrieve instance %s: %w", id, err))
+		return
+	}
+	e.lock.Lock()
+	e.instances[inst.ID] = *inst
+	e.lock.Unlock()
+}
+
+func isNotFound(err error) bool {
+	var statusErr *restclient.StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound
+}
+
+func (e *Exporter) handleOperation(op *restapi.Operation) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if op.StatusCode.IsFinal() {
+		delete(e.operations, op.ID)
+		e.finished[op.ID] = true
+		return
+	}
+	if e.finished[op.ID] {
+		return
+	}
+	e.operations[op.ID] = op
+}
+
+// ServeHTTP renders the metrics in the Prometheus text format
+func (e *Exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := e.WriteMetrics(w); err != nil {
+		e.opts.OnError(fmt.Errorf("failed to write metrics: %w", err))
+	}
+}
@@ -0,0 +1,185 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+func newTestExporter(t *testing.T, s *amstest.Server) *Exporter {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(c, &Options{OnError: func(err error) { t.Errorf("unexpected error: %v", err) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func instance(id, status, app, node string) api.Instance {
+	return api.Instance{ID: id, Status: status, AppName: app, Node: node}
+}
+
+func operation(id string, code restapi.StatusCode) *restapi.Operation {
+	return &restapi.Operation{ID: id, Class: "task", Status: code.String(), StatusCode: code}
+}
+
+// sendEvent passes an event to the exporter the way the event listener does
+func sendEvent(t *testing.T, e *Exporter, typ api.EventType, metadata interface{}) {
+	t.Helper()
+	b, err := json.Marshal(api.Event{Type: typ, Metadata: metadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := map[string]interface{}{}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		t.Fatal(err)
+	}
+	e.handleEvent(msg)
+}
+
+func lifecycle(action api.LifecycleEventAction, id string) api.LifecycleEvent {
+	return api.LifecycleEvent{Action: action, Source: "/1.0/instances/" + id}
+}
+
+// samples returns the rendered samples of the given metric
+func samples(t *testing.T, e *Exporter, name string) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := e.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(line, name+"{") || strings.HasPrefix(line, name+" ") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func checkSamples(t *testing.T, e *Exporter, name string, want ...string) {
+	t.Helper()
+	got := samples(t, e, name)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("unexpected %s samples:\n%s\nexpected:\n%s", name, strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInstancesFromLifecycleEvents(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetInstances(
+		instance("a", "running", "foo", "lxd0"),
+		instance("b", "running", "foo", "lxd0"),
+	)
+
+	e := newTestExporter(t, s)
+	if err := e.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, e, "ams_instances",
+		`ams_instances{status="running",application="foo",node="lxd0"} 2`)
+
+	// An instance changing its status and a new one showing up
+	s.SetInstances(
+		instance("a", "running", "foo", "lxd0"),
+		instance("b", "stopped", "foo", "lxd0"),
+		instance("c", "created", "bar", "lxd1"),
+	)
+	sendEvent(t, e, api.EventTypeLifecycle, lifecycle(api.LifecycleEventActionInstanceStopped, "b"))
+	sendEvent(t, e, api.EventTypeLifecycle, lifecycle(api.LifecycleEventActionInstanceCreated, "c"))
+	checkSamples(t, e, "ams_instances",
+		`ams_instances{status="created",application="bar",node="lxd1"} 1`,
+		`ams_instances{status="running",application="foo",node="lxd0"} 1`,
+		`ams_instances{status="stopped",application="foo",node="lxd0"} 1`)
+
+	// A removed instance and one deleted right after its event are both
+	// dropped. Instance d appearing without an event proves that nothing
+	// was listed again.
+	s.SetInstances(
+		instance("b", "stopped", "foo", "lxd0"),
+		instance("d", "running", "baz", "lxd1"),
+	)
+	sendEvent(t, e, api.EventTypeLifecycle, lifecycle(api.LifecycleEventActionInstanceRemoved, "a"))
+	sendEvent(t, e, api.EventTypeLifecycle, lifecycle(api.LifecycleEventActionInstanceStopped, "c"))
+	checkSamples(t, e, "ams_instances",
+		`ams_instances{status="stopped",application="foo",node="lxd0"} 1`)
+	checkSamples(t, e, "ams_exporter_events_total",
+		`ams_exporter_events_total{type="lifecycle"} 4`)
+}
+
+func TestOperationsFromEvents(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetOperations(operation("op0", restapi.Running), operation("op1", restapi.Success))
+
+	e := newTestExporter(t, s)
+	if err := e.Resync(); err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, e, "ams_operations",
+		`ams_operations{class="task",status="Running"} 1`)
+	checkSamples(t, e, "ams_exporter_synced", "ams_exporter_synced 1")
+
+	sendEvent(t, e, api.EventTypeOperation, operation("op2", restapi.Pending))
+	sendEvent(t, e, api.EventTypeOperation, operation("op0", restapi.Success))
+	checkSamples(t, e, "ams_operations",
+		`ams_operations{class="task",status="Pending"} 1`)
+
+	// An update arriving after the operation finished doesn't bring it back
+	sendEvent(t, e, api.EventTypeOperation, operation("op0", restapi.Running))
+	sendEvent(t, e, api.EventTypeOperation, operation("op2", restapi.Running))
+	checkSamples(t, e, "ams_operations",
+		`ams_operations{class="task",status="Running"} 1`)
+}
+
+func TestRefresh(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetTasks(
+		api.Task{ID: "t0", Status: "running", ObjectType: "instance"},
+		api.Task{ID: "t1", Status: "running", ObjectType: "instance"},
+		api.Task{ID: "t2", Status: "pending", ObjectType: "image"},
+	)
+
+	e := newTestExporter(t, s)
+	if err := e.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, e, "ams_tasks",
+		`ams_tasks{status="pending",object_type="image"} 1`,
+		`ams_tasks{status="running",object_type="instance"} 2`)
+}
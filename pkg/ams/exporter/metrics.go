@@ -0,0 +1,109 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// family is a group of samples sharing a name in the Prometheus text format
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newGauge(name, help string, labels ...string) *family {
+	return newFamily(name, help, "gauge", labels)
+}
+
+func newCounter(name, help string, labels ...string) *family {
+	return newFamily(name, help, "counter", labels)
+}
+
+func newFamily(name, help, typ string, labels []string) *family {
+	return &family{
+		name:   name,
+		help:   help,
+		typ:    typ,
+		labels: labels,
+		values: map[string]float64{},
+		keys:   map[string][]string{},
+	}
+}
+
+// set sets the sample with the given label values
+func (f *family) set(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	f.values[key] = v
+	f.keys[key] = labelValues
+}
+
+// add adds to the sample with the given label values
+func (f *family) add(v float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	f.values[key] += v
+	f.keys[key] = labelValues
+}
+
+// write renders the family in the Prometheus text exposition format
+func (f *family) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+	keys := make([]string, 0, len(f.values))
+	for k := range f.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		w.WriteString(f.name)
+		if len(f.labels) > 0 {
+			w.WriteString("{")
+			for n, l := range f.labels {
+				if n > 0 {
+					w.WriteString(",")
+				}
+				fmt.Fprintf(w, "%s=\"%s\"", l, escapeLabelValue(f.keys[k][n]))
+			}
+			w.WriteString("}")
+		}
+		fmt.Fprintf(w, " %s\n", strconv.FormatFloat(f.values[k], 'g', -1, 64))
+	}
+}
+
+func writeFamilies(out io.Writer, families ...*family) error {
+	w := bufio.NewWriter(out)
+	for _, f := range families {
+		f.write(w)
+	}
+	return w.Flush()
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
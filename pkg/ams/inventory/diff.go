@@ -0,0 +1,282 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Kind is the kind of resource a change refers to
+type Kind string
+
+// Kinds of resources stored in a snapshot
+const (
+	KindNode        Kind = "node"
+	KindApplication Kind = "application"
+	KindImage       Kind = "image"
+	KindAddon       Kind = "addon"
+	KindConfig      Kind = "config"
+	KindIdentity    Kind = "identity"
+	KindGroup       Kind = "group"
+	KindCertificate Kind = "certificate"
+)
+
+// ChangeType describes what happened to a resource between two snapshots
+type ChangeType string
+
+// Ways a resource can differ between two snapshots
+const (
+	ChangeAdded   ChangeType = "added"
+	ChangeRemoved ChangeType = "removed"
+	ChangeChanged ChangeType = "changed"
+)
+
+// FieldChange is a single field which differs between two snapshots. Old
+// is nil when the field was added and New is nil when it was removed.
+type FieldChange struct {
+	Path string      `json:"path" yaml:"path"`
+	Old  interface{} `json:"old" yaml:"old"`
+	New  interface{} `json:"new" yaml:"new"`
+}
+
+// Change describes a resource which was added, removed or changed
+type Change struct {
+	Kind Kind       `json:"kind" yaml:"kind"`
+	Name string     `json:"name" yaml:"name"`
+	Type ChangeType `json:"type" yaml:"type"`
+	// Fields lists the fields which changed, only set for ChangeChanged
+	Fields []FieldChange `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// Diff lists the changes between two snapshots
+type Diff struct {
+	Changes []Change `json:"changes" yaml:"changes"`
+}
+
+// Empty returns true if both snapshots describe the same state
+func (d *Diff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders the diff with one line per added or removed resource and
+// one line per changed field
+func (d *Diff) String() string {
+	var b strings.Builder
+	for _, c := range d.Changes {
+		label := strings.TrimSpace(fmt.Sprintf("%s %s", c.Kind, c.Name))
+		switch c.Type {
+		case ChangeAdded:
+			fmt.Fprintf(&b, "+ %s\n", label)
+		case ChangeRemoved:
+			fmt.Fprintf(&b, "- %s\n", label)
+		case ChangeChanged:
+			fmt.Fprintf(&b, "~ %s\n", label)
+			for _, f := range c.Fields {
+				fmt.Fprintf(&b, "    %s: %s -> %s\n", f.Path, formatValue(f.Old), formatValue(f.New))
+			}
+		}
+	}
+	return b.String()
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "<none>"
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+// Compare returns the changes needed to get from one snapshot to another.
+// Resources are matched by name, identities by ID and certificates by
+// fingerprint. Config is compared as a single resource with one field per
+// item.
+func Compare(from, to *Snapshot) (*Diff, error) {
+	d := &Diff{}
+	kinds := []struct {
+		kind     Kind
+		from, to map[string]interface{}
+	}{
+		{KindNode, keyed(from.Nodes, "name"), keyed(to.Nodes, "name")},
+		{KindApplication, keyed(from.Applications, "name"), keyed(to.Applications, "name")},
+		{KindImage, keyed(from.Images, "name"), keyed(to.Images, "name")},
+		{KindAddon, keyed(from.Addons, "name"), keyed(to.Addons, "name")},
+		{KindConfig, keyed([]interface{}{from.Config}, ""), keyed([]interface{}{to.Config}, "")},
+		{KindIdentity, keyed(from.Identities, "id"), keyed(to.Identities, "id")},
+		{KindGroup, keyed(from.Groups, "name"), keyed(to.Groups, "name")},
+		{KindCertificate, keyed(from.Certificates, "fingerprint"), keyed(to.Certificates, "fingerprint")},
+	}
+	for _, k := range kinds {
+		if k.from == nil || k.to == nil {
+			return nil, fmt.Errorf("failed to encode %s resources", k.kind)
+		}
+		d.Changes = append(d.Changes, compareResources(k.kind, k.from, k.to)...)
+	}
+	return d, nil
+}
+
+// keyed converts resources into their generic JSON form indexed by the
+// given field. An empty field puts the single resource under an empty key.
+func keyed(resources interface{}, field string) map[string]interface{} {
+	b, err := json.Marshal(resources)
+	if err != nil {
+		return nil
+	}
+	var list []interface{}
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil
+	}
+	m := make(map[string]interface{}, len(list))
+	for _, r := range list {
+		if len(field) == 0 {
+			if r != nil {
+				m[""] = r
+			}
+			continue
+		}
+		if obj, ok := r.(map[string]interface{}); ok {
+			m[fmt.Sprint(obj[field])] = r
+		}
+	}
+	return m
+}
+
+func compareResources(kind Kind, from, to map[string]interface{}) []Change {
+	names := map[string]bool{}
+	for name := range from {
+		names[name] = true
+	}
+	for name := range to {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []Change
+	for _, name := range sorted {
+		old, hadOld := from[name]
+		cur, hasNew := to[name]
+		switch {
+		case !hadOld:
+			changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeAdded})
+		case !hasNew:
+			changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeRemoved})
+		default:
+			if fields := compareValues("", old, cur); len(fields) > 0 {
+				changes = append(changes, Change{Kind: kind, Name: name, Type: ChangeChanged, Fields: fields})
+			}
+		}
+	}
+	return changes
+}
+
+// compareValues walks two generic JSON values and returns the leaves which
+// differ. Lists of objects are compared element by element, any other list
+// is reported as a whole.
+func compareValues(path string, old, cur interface{}) []FieldChange {
+	if isEmpty(old) && isEmpty(cur) {
+		return nil
+	}
+	switch o := old.(type) {
+	case map[string]interface{}:
+		c, ok := cur.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := map[string]bool{}
+		for k := range o {
+			keys[k] = true
+		}
+		for k := range c {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		var fields []FieldChange
+		for _, k := range sorted {
+			fields = append(fields, compareValues(joinPath(path, k), o[k], c[k])...)
+		}
+		return fields
+	case []interface{}:
+		c, ok := cur.([]interface{})
+		if !ok || !objectList(o) || !objectList(c) {
+			break
+		}
+		var fields []FieldChange
+		for n := 0; n < len(o) || n < len(c); n++ {
+			p := fmt.Sprintf("%s[%d]", path, n)
+			switch {
+			case n >= len(c):
+				fields = append(fields, FieldChange{Path: p, Old: o[n]})
+			case n >= len(o):
+				fields = append(fields, FieldChange{Path: p, New: c[n]})
+			default:
+				fields = append(fields, compareValues(p, o[n], c[n])...)
+			}
+		}
+		return fields
+	}
+	if reflect.DeepEqual(old, cur) {
+		return nil
+	}
+	return []FieldChange{{Path: path, Old: old, New: cur}}
+}
+
+// isEmpty treats missing, null and empty values alike as encoders disagree
+// on how to write them
+func isEmpty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func objectList(l []interface{}) bool {
+	for _, v := range l {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func joinPath(path, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,204 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package inventory
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		Version: Version,
+		TakenAt: time.Date(2024, 3, 12, 10, 0, 0, 0, time.UTC),
+		Nodes: []api.Node{
+			{Name: "lxd0", Address: "10.0.0.1", CPUs: 32, Memory: "64GB", Tags: []string{"gpu"}},
+			{Name: "lxd1", Address: "10.0.0.2", CPUs: 32, Memory: "64GB"},
+		},
+		Applications: []api.Application{{
+			ID:           "app0",
+			Name:         "game",
+			InstanceType: "a4.3",
+			Versions: []api.ApplicationVersion{
+				{Number: 0, Published: true},
+				{Number: 1},
+			},
+		}},
+		Config: map[string]interface{}{
+			"scheduler.strategy":  "spread",
+			"gpu.allocation_mode": "all",
+			"instance.limits": map[string]interface{}{
+				"cpus":    4,
+				"enabled": true,
+			},
+		},
+		Identities:   []api.Identity{{ID: "id0", Name: "admin", Groups: []string{"admins"}}},
+		Certificates: []restapi.Certificate{{Fingerprint: "abc", Certificate: "PEM"}},
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *Snapshot)
+		diff   string
+	}{
+		{
+			name:   "unchanged",
+			change: func(s *Snapshot) {},
+		},
+		{
+			name: "empty and missing lists",
+			change: func(s *Snapshot) {
+				s.Nodes[1].Tags = []string{}
+				s.Groups = []api.AuthGroup{}
+			},
+		},
+		{
+			name: "resources added and removed",
+			change: func(s *Snapshot) {
+				s.Nodes = append(s.Nodes[:1], api.Node{Name: "lxd2"})
+				s.Certificates = nil
+			},
+			diff: "- node lxd1\n+ node lxd2\n- certificate abc\n",
+		},
+		{
+			name: "field changed",
+			change: func(s *Snapshot) {
+				s.Nodes[0].CPUs = 64
+				s.Nodes[0].Tags = []string{"gpu", "arm"}
+			},
+			diff: "~ node lxd0\n    cpus: 32 -> 64\n    tags: [\"gpu\"] -> [\"gpu\",\"arm\"]\n",
+		},
+		{
+			name: "nested field changed",
+			change: func(s *Snapshot) {
+				s.Applications[0].Versions[1].Published = true
+			},
+			diff: "~ application game\n    versions[1].published: false -> true\n",
+		},
+		{
+			name: "list element removed",
+			change: func(s *Snapshot) {
+				s.Applications[0].Versions = s.Applications[0].Versions[:1]
+				s.Applications[0].Versions[0] = api.ApplicationVersion{Number: 0, Published: true}
+			},
+			diff: "~ application game\n    versions[1]: " + formatValue(genericVersion(t, 1)) + " -> <none>\n",
+		},
+		{
+			name: "config items added, removed and changed",
+			change: func(s *Snapshot) {
+				delete(s.Config, "gpu.allocation_mode")
+				s.Config["scheduler.strategy"] = "binpack"
+				s.Config["registry.mode"] = "client"
+				s.Config["instance.limits"] = map[string]interface{}{"cpus": 8, "enabled": true}
+			},
+			diff: "~ config\n" +
+				"    gpu.allocation_mode: \"all\" -> <none>\n" +
+				"    instance.limits.cpus: 4 -> 8\n" +
+				"    registry.mode: <none> -> \"client\"\n" +
+				"    scheduler.strategy: \"spread\" -> \"binpack\"\n",
+		},
+		{
+			name: "identity matched by ID",
+			change: func(s *Snapshot) {
+				s.Identities[0].Name = "root"
+			},
+			diff: "~ identity id0\n    name: \"admin\" -> \"root\"\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			to := testSnapshot()
+			test.change(to)
+			d, err := Compare(testSnapshot(), to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.String() != test.diff {
+				t.Fatalf("expected diff\n%s\ngot\n%s", test.diff, d)
+			}
+			if d.Empty() != (len(test.diff) == 0) {
+				t.Fatalf("expected empty to be %v", len(test.diff) == 0)
+			}
+		})
+	}
+}
+
+// genericVersion returns a version of the test application the way
+// Compare sees it
+func genericVersion(t *testing.T, number int) interface{} {
+	t.Helper()
+	apps := keyed(testSnapshot().Applications, "name")
+	return apps["game"].(map[string]interface{})["versions"].([]interface{})[number]
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	original := testSnapshot()
+	tests := []struct {
+		path   string
+		format Format
+	}{
+		{path: "snapshot.json", format: FormatJSON},
+		{path: "snapshot.JSON", format: FormatJSON},
+		{path: "snapshot.yaml", format: FormatYAML},
+		{path: "snapshot", format: FormatYAML},
+	}
+	var loaded []*Snapshot
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if f := formatFromPath(test.path); f != test.format {
+				t.Fatalf("expected format %s, got %s", test.format, f)
+			}
+			path := filepath.Join(dir, test.path)
+			if err := original.Save(path); err != nil {
+				t.Fatal(err)
+			}
+			s, err := Load(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := Compare(original, s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !d.Empty() {
+				t.Fatalf("snapshot changed when written as %s:\n%s", test.format, d)
+			}
+			loaded = append(loaded, s)
+		})
+	}
+
+	// Snapshots loaded from different formats compare equal with each
+	// other as well
+	for n := 1; n < len(loaded); n++ {
+		d, err := Compare(loaded[0], loaded[n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Empty() {
+			t.Fatalf("%s and %s differ:\n%s", tests[0].path, tests[n].path, d)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package inventory captures the configuration of an AMS cluster so it can
+// be archived and compared later.
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Version is the version of the snapshot format written by this package
+const Version = 1
+
+// maxAttempts is how often a snapshot is captured before giving up on
+// getting two identical captures in a row
+const maxAttempts = 3
+
+// Format is the encoding of a snapshot
+type Format string
+
+const (
+	// FormatJSON encodes snapshots as JSON
+	FormatJSON Format = "json"
+	// FormatYAML encodes snapshots as YAML
+	FormatYAML Format = "yaml"
+)
+
+// Snapshot is the inventory of a cluster at a point in time
+type Snapshot struct {
+	Version int       `json:"version" yaml:"version"`
+	TakenAt time.Time `json:"taken_at" yaml:"taken_at"`
+	// Consistent is set when two captures in a row returned the same
+	// state, meaning nothing changed while the snapshot was taken
+	Consistent bool `json:"consistent" yaml:"consistent"`
+
+	Nodes        []api.Node             `json:"nodes" yaml:"nodes"`
+	Applications []api.Application      `json:"applications" yaml:"applications"`
+	Images       []api.Image            `json:"images" yaml:"images"`
+	Addons       []api.Addon            `json:"addons" yaml:"addons"`
+	Config       map[string]interface{} `json:"config" yaml:"config"`
+	Identities   []api.Identity         `json:"identities" yaml:"identities"`
+	Groups       []api.AuthGroup        `json:"groups" yaml:"groups"`
+	Certificates []restapi.Certificate  `json:"certificates" yaml:"certificates"`
+}
+
+// Take captures the inventory of the cluster the client is connected to.
+// AMS has no way to read everything at once, so the inventory is captured
+// until two captures in a row match. If the cluster keeps changing the last
+// capture is returned with Consistent unset.
+func Take(c client.Client) (*Snapshot, error) {
+	prev, err := capture(c)
+	if err != nil {
+		return nil, err
+	}
+	for n := 1; n < maxAttempts; n++ {
+		s, err := capture(c)
+		if err != nil {
+			return nil, err
+		}
+		d, err := Compare(prev, s)
+		if err != nil {
+			return nil, err
+		}
+		if d.Empty() {
+			s.Consistent = true
+			return s, nil
+		}
+		prev = s
+	}
+	return prev, nil
+}
+
+func capture(c client.Client) (*Snapshot, error) {
+	s := &Snapshot{Version: Version, TakenAt: time.Now().UTC()}
+	var err error
+	if s.Nodes, err = c.ListNodes(); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	if s.Applications, err = c.ListApplications(); err != nil {
+		return nil, fmt.Errorf("failed to list applications: %w", err)
+	}
+	if s.Images, err = c.ListImages(); err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	if s.Addons, err = c.ListAddons(); err != nil {
+		return nil, fmt.Errorf("failed to list addons: %w", err)
+	}
+	if s.Config, err = c.RetrieveConfigItems(); err != nil {
+		return nil, fmt.Errorf("failed to retrieve config: %w", err)
+	}
+	// Older AMS versions have neither identities nor groups
+	if s.Identities, err = c.ListIdentitiesWithFilters(nil); err != nil && !errs.IsErrNotSupported(err) {
+		return nil, fmt.Errorf("failed to list identities: %w", err)
+	}
+	if s.Groups, err = c.ListAuthGroupsWithFilters(nil); err != nil && !errs.IsErrNotSupported(err) {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	if s.Certificates, err = c.ListCertificates(); err != nil {
+		return nil, fmt.Errorf("failed to list certificates: %w", err)
+	}
+	s.sort()
+	return s, nil
+}
+
+// sort orders all resources by their key so snapshots diff and read well
+func (s *Snapshot) sort() {
+	sort.Slice(s.Nodes, func(i, j int) bool { return s.Nodes[i].Name < s.Nodes[j].Name })
+	sort.Slice(s.Applications, func(i, j int) bool { return s.Applications[i].Name < s.Applications[j].Name })
+	for n := range s.Applications {
+		versions := s.Applications[n].Versions
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	}
+	sort.Slice(s.Images, func(i, j int) bool { return s.Images[i].Name < s.Images[j].Name })
+	for n := range s.Images {
+		versions := s.Images[n].Versions
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Number < versions[j].Number })
+	}
+	sort.Slice(s.Addons, func(i, j int) bool { return s.Addons[i].Name < s.Addons[j].Name })
+	sort.Slice(s.Identities, func(i, j int) bool { return s.Identities[i].ID < s.Identities[j].ID })
+	sort.Slice(s.Groups, func(i, j int) bool { return s.Groups[i].Name < s.Groups[j].Name })
+	sort.Slice(s.Certificates, func(i, j int) bool {
+		return s.Certificates[i].Fingerprint < s.Certificates[j].Fingerprint
+	})
+}
+
+// Write encodes the snapshot in the given format
+func (s *Snapshot) Write(w io.Writer, format Format) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(s)
+	case FormatYAML:
+		b, err := yaml.Marshal(s)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(b)
+		return err
+	}
+	return errs.NewErrNotSupported(fmt.Sprintf("format %q", format))
+}
+
+// Read decodes a snapshot in the given format
+func Read(r io.Reader, format Format) (*Snapshot, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{}
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(b, s)
+	case FormatYAML:
+		err = yaml.Unmarshal(b, s)
+		for k, v := range s.Config {
+			s.Config[k] = normalizeYAML(v)
+		}
+	default:
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("format %q", format))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Version != Version {
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("snapshot version %d", s.Version))
+	}
+	return s, nil
+}
+
+// Save writes the snapshot to a file. Files ending in .json are written as
+// JSON, everything else as YAML.
+func (s *Snapshot) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.Write(f, formatFromPath(path)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a snapshot written by Save
+func Load(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f, formatFromPath(path))
+}
+
+func formatFromPath(path string) Format {
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return FormatJSON
+	}
+	return FormatYAML
+}
+
+// normalizeYAML turns the maps yaml.v2 decodes into the ones encoding/json
+// produces so config values compare equal whatever they were loaded from
+func normalizeYAML(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = normalizeYAML(value)
+		}
+		return m
+	case []interface{}:
+		for n := range v {
+			v[n] = normalizeYAML(v[n])
+		}
+	}
+	return v
+}
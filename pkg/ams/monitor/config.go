@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package monitor
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// Config describes the checks and sinks of a monitor in YAML
+type Config struct {
+	PollInterval time.Duration `yaml:"poll-interval"`
+	Rules        []RuleConfig  `yaml:"rules"`
+	Sinks        []SinkConfig  `yaml:"sinks"`
+}
+
+// RuleConfig describes a single check
+type RuleConfig struct {
+	// Type of the rule: node-status, instance-errors, image-sync or
+	// api-status
+	Type     string        `yaml:"type"`
+	For      time.Duration `yaml:"for"`
+	Severity Severity      `yaml:"severity"`
+	// Threshold of the instance-errors rule
+	Threshold int `yaml:"threshold"`
+	// Statuses of the node-status rule, e.g. offline
+	Statuses []string `yaml:"statuses"`
+	// Allowed statuses of the api-status rule
+	Allowed []string `yaml:"allowed"`
+}
+
+// SinkConfig describes a single sink
+type SinkConfig struct {
+	// Type of the sink: log, webhook or exec
+	Type string `yaml:"type"`
+	// URL of the webhook sink
+	URL string `yaml:"url"`
+	// Headers sent by the webhook sink
+	Headers map[string]string `yaml:"headers"`
+	// Command and Args run by the exec sink
+	Command string        `yaml:"command"`
+	Args    []string      `yaml:"args"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// LoadConfig reads a monitor configuration from a YAML file
+func LoadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	if err := shared.LoadFromFile(path, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Options converts the configuration into monitor options
+func (c *Config) Options() (*Options, error) {
+	opts := &Options{PollInterval: c.PollInterval}
+	for n, rc := range c.Rules {
+		rule, err := rc.rule()
+		if err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", n, err)
+		}
+		switch rc.Severity {
+		case "", SeverityWarning, SeverityCritical:
+		default:
+			return nil, fmt.Errorf("rules[%d]: %w", n, errs.NewInvalidArgument("severity"))
+		}
+		opts.Checks = append(opts.Checks, Check{Rule: rule, For: rc.For, Severity: rc.Severity})
+	}
+	for n, sc := range c.Sinks {
+		sink, err := sc.sink()
+		if err != nil {
+			return nil, fmt.Errorf("sinks[%d]: %w", n, err)
+		}
+		opts.Sinks = append(opts.Sinks, sink)
+	}
+	return opts, nil
+}
+
+func (c *RuleConfig) rule() (Rule, error) {
+	switch c.Type {
+	case "node-status":
+		r := &NodeStatusRule{}
+		for _, name := range c.Statuses {
+			status, err := nodeStatusFromString(name)
+			if err != nil {
+				return nil, err
+			}
+			r.Statuses = append(r.Statuses, status)
+		}
+		return r, nil
+	case "instance-errors":
+		return &InstanceErrorRule{Threshold: c.Threshold}, nil
+	case "image-sync":
+		return &ImageSyncRule{}, nil
+	case "api-status":
+		return &APIStatusRule{Allowed: c.Allowed}, nil
+	}
+	return nil, errs.NewErrNotSupported(fmt.Sprintf("rule type %q", c.Type))
+}
+
+func (c *SinkConfig) sink() (Sink, error) {
+	switch c.Type {
+	case "log":
+		return &LogSink{}, nil
+	case "webhook":
+		if len(c.URL) == 0 {
+			return nil, errs.NewErrRequired("url")
+		}
+		s := &WebhookSink{URL: c.URL, Header: http.Header{}}
+		for k, v := range c.Headers {
+			s.Header.Set(k, v)
+		}
+		if c.Timeout > 0 {
+			s.Client = &http.Client{Timeout: c.Timeout}
+		}
+		return s, nil
+	case "exec":
+		if len(c.Command) == 0 {
+			return nil, errs.NewErrRequired("command")
+		}
+		return &ExecSink{Command: c.Command, Args: c.Args, Timeout: c.Timeout}, nil
+	}
+	return nil, errs.NewErrNotSupported(fmt.Sprintf("sink type %q", c.Type))
+}
+
+func nodeStatusFromString(name string) (api.NodeStatus, error) {
+	for _, s := range []api.NodeStatus{
+		api.NodeStatusError, api.NodeStatusUnknown, api.NodeStatusCreated,
+		api.NodeStatusInitializing, api.NodeStatusInitialized, api.NodeStatusOnline,
+		api.NodeStatusOffline, api.NodeStatusDeleted,
+	} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return api.NodeStatusUnknown, errs.NewInvalidArgument(fmt.Sprintf("node status %q", name))
+}
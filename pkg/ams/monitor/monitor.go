@@ -0,0 +1,310 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package monitor watches an AMS cluster and raises alerts when its health
+// degrades. It polls the cluster periodically, re-evaluates early on
+// lifecycle events and passes alerts on to pluggable sinks.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	restclient "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/client"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+// Severity of an alert
+type Severity string
+
+const (
+	// SeverityWarning is used for alerts which need attention eventually
+	SeverityWarning Severity = "warning"
+	// SeverityCritical is used for alerts which need attention right away
+	SeverityCritical Severity = "critical"
+)
+
+// AlertState describes whether an alert started or ended
+type AlertState string
+
+const (
+	// AlertStateFiring is sent when a rule was violated for long enough
+	AlertStateFiring AlertState = "firing"
+	// AlertStateResolved is sent when a firing rule isn't violated anymore
+	AlertStateResolved AlertState = "resolved"
+)
+
+// Alert is sent to the sinks when an alert fires or resolves
+type Alert struct {
+	Rule     string     `json:"rule"`
+	Severity Severity   `json:"severity"`
+	Subject  string     `json:"subject"`
+	Message  string     `json:"message"`
+	State    AlertState `json:"state"`
+	StartsAt time.Time  `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+// String returns a single line describing the alert
+func (a *Alert) String() string {
+	return fmt.Sprintf("[%s] %s %s/%s: %s", a.Severity, a.State, a.Rule, a.Subject, a.Message)
+}
+
+// Check configures how a rule is evaluated
+type Check struct {
+	Rule Rule
+	// For is how long the rule must be violated before the alert fires
+	For time.Duration
+	// Severity of the alerts. Defaults to warning.
+	Severity Severity
+}
+
+// Options configure a monitor
+type Options struct {
+	Checks []Check
+	Sinks  []Sink
+	// PollInterval is how often the cluster is polled. Defaults to 30
+	// seconds.
+	PollInterval time.Duration
+	// OnError is called for errors which don't stop the monitor, like a
+	// sink failing to deliver an alert. Errors are logged by default.
+	OnError func(error)
+}
+
+type alertKey struct {
+	check   int
+	subject string
+}
+
+type pendingAlert struct {
+	since time.Time
+	alert *Alert
+}
+
+// Monitor watches the health of a cluster
+type Monitor struct {
+	c       client.Client
+	opts    Options
+	lock    sync.Mutex
+	pending map[alertKey]*pendingAlert
+}
+
+// New returns a monitor for the cluster the client is connected to
+func New(c client.Client, opts *Options) (*Monitor, error) {
+	if c == nil {
+		return nil, errs.NewInvalidArgument("client")
+	}
+	if opts == nil || len(opts.Checks) == 0 {
+		return nil, errs.NewErrRequired("checks")
+	}
+	m := &Monitor{c: c, opts: *opts, pending: map[alertKey]*pendingAlert{}}
+	if m.opts.PollInterval == 0 {
+		m.opts.PollInterval = defaultPollInterval
+	}
+	if m.opts.OnError == nil {
+		m.opts.OnError = func(err error) { log.Print(err) }
+	}
+	for n := range m.opts.Checks {
+		if m.opts.Checks[n].Rule == nil {
+			return nil, errs.NewInvalidArgument(fmt.Sprintf("checks[%d].rule", n))
+		}
+		if len(m.opts.Checks[n].Severity) == 0 {
+			m.opts.Checks[n].Severity = SeverityWarning
+		}
+	}
+	return m, nil
+}
+
+// Run watches the cluster until the context is cancelled. Lifecycle events
+// trigger an evaluation right away. If the event stream is not available
+// the monitor keeps polling and subscribes again later.
+func (m *Monitor) Run(ctx context.Context) error {
+	trigger := make(chan struct{}, 1)
+	ticker := time.NewTicker(m.opts.PollInterval)
+	defer ticker.Stop()
+
+	var listener *restclient.EventListener
+	defer func() {
+		if listener != nil {
+			listener.Disconnect()
+		}
+	}()
+
+	for {
+		if listener == nil || !listener.IsActive() {
+			var err error
+			listener, err = m.subscribe(trigger)
+			if err != nil {
+				m.opts.OnError(fmt.Errorf("failed to listen for events: %w", err))
+			}
+		}
+
+		if err := m.Check(ctx); err != nil {
+			m.opts.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-trigger:
+		}
+	}
+}
+
+// subscribe listens for lifecycle events and signals the trigger channel
+// for every event received
+func (m *Monitor) subscribe(trigger chan struct{}) (*restclient.EventListener, error) {
+	listener, err := m.c.GetEvents()
+	if err != nil {
+		return nil, err
+	}
+	_, err = listener.AddHandler([]string{string(api.EventTypeLifecycle)}, func(interface{}) {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		listener.Disconnect()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Check polls the cluster once, evaluates all rules and sends the alerts
+// which fired or resolved. Errors of the sinks are returned.
+func (m *Monitor) Check(ctx context.Context) error {
+	return m.Evaluate(ctx, m.collect())
+}
+
+// collect retrieves the current state of the cluster
+func (m *Monitor) collect() *State {
+	s := &State{Time: time.Now()}
+	s.ServiceStatus, _, s.ServiceErr = m.c.RetrieveServiceStatus()
+	if s.ServiceErr != nil {
+		s.ServiceStatus = nil
+		s.Partial = true
+		return s
+	}
+
+	var err error
+	if s.Nodes, err = m.c.ListNodes(); err != nil {
+		m.opts.OnError(fmt.Errorf("failed to list nodes: %w", err))
+		s.Partial = true
+	}
+	if s.Instances, err = m.c.ListInstances(); err != nil {
+		m.opts.OnError(fmt.Errorf("failed to list instances: %w", err))
+		s.Partial = true
+	}
+	if s.Images, err = m.c.ListImages(); err != nil {
+		m.opts.OnError(fmt.Errorf("failed to list images: %w", err))
+		s.Partial = true
+	}
+	return s
+}
+
+// Evaluate evaluates all rules against the given state and sends the alerts
+// which fired or resolved. It allows feeding states collected elsewhere.
+func (m *Monitor) Evaluate(ctx context.Context, s *State) error {
+	m.lock.Lock()
+	var alerts []*Alert
+	seen := map[alertKey]bool{}
+	for n, check := range m.opts.Checks {
+		for _, f := range check.Rule.Evaluate(s) {
+			key := alertKey{n, f.Subject}
+			seen[key] = true
+			p := m.pending[key]
+			if p == nil {
+				p = &pendingAlert{since: s.Time}
+				m.pending[key] = p
+			}
+			if p.alert == nil && s.Time.Sub(p.since) >= check.For {
+				p.alert = &Alert{
+					Rule:     check.Rule.Name(),
+					Severity: check.Severity,
+					Subject:  f.Subject,
+					Message:  f.Message,
+					State:    AlertStateFiring,
+					StartsAt: p.since,
+				}
+				alerts = append(alerts, p.alert)
+			}
+		}
+	}
+
+	if !s.Partial {
+		for key, p := range m.pending {
+			if seen[key] {
+				continue
+			}
+			delete(m.pending, key)
+			if p.alert == nil {
+				continue
+			}
+			resolved := *p.alert
+			resolved.State = AlertStateResolved
+			endsAt := s.Time
+			resolved.EndsAt = &endsAt
+			alerts = append(alerts, &resolved)
+		}
+	}
+	m.lock.Unlock()
+
+	return m.send(ctx, alerts)
+}
+
+// Firing returns all alerts which are currently firing
+func (m *Monitor) Firing() []Alert {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	var alerts []Alert
+	for _, p := range m.pending {
+		if p.alert != nil {
+			alerts = append(alerts, *p.alert)
+		}
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Subject < alerts[j].Subject
+	})
+	return alerts
+}
+
+func (m *Monitor) send(ctx context.Context, alerts []*Alert) error {
+	var errList []error
+	for _, a := range alerts {
+		for _, sink := range m.opts.Sinks {
+			if err := sink.Send(ctx, a); err != nil {
+				errList = append(errList, fmt.Errorf("failed to send alert %s/%s: %w", a.Rule, a.Subject, err))
+			}
+		}
+	}
+	return errors.Join(errList...)
+}
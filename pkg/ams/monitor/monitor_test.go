@@ -0,0 +1,355 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/amstest"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+)
+
+// recordingSink collects all alerts it receives
+type recordingSink struct {
+	lock   sync.Mutex
+	alerts []Alert
+	sent   chan struct{}
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{sent: make(chan struct{}, 16)}
+}
+
+func (s *recordingSink) Send(_ context.Context, alert *Alert) error {
+	s.lock.Lock()
+	s.alerts = append(s.alerts, *alert)
+	s.lock.Unlock()
+	s.sent <- struct{}{}
+	return nil
+}
+
+// take returns the alerts received since the last call
+func (s *recordingSink) take() []Alert {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	alerts := s.alerts
+	s.alerts = nil
+	return alerts
+}
+
+func newTestMonitor(t *testing.T, s *amstest.Server, opts *Options) *Monitor {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := client.New(u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.OnError == nil {
+		opts.OnError = func(err error) { t.Log(err) }
+	}
+	m, err := New(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func offlineNode(name string) api.Node {
+	return api.Node{Name: name, StatusCode: api.NodeStatusOffline}
+}
+
+func onlineNode(name string) api.Node {
+	return api.Node{Name: name, StatusCode: api.NodeStatusOnline}
+}
+
+// evaluateAt collects the state of the fake server and evaluates it as if it
+// was collected at the given time
+func evaluateAt(t *testing.T, m *Monitor, at time.Time) {
+	t.Helper()
+	s := m.collect()
+	s.Time = at
+	if err := m.Evaluate(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkAlerts(t *testing.T, got []Alert, want ...AlertState) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d alerts, got %v", len(want), got)
+	}
+	for n, a := range got {
+		if a.State != want[n] {
+			t.Fatalf("expected alert %d to be %s, got %s", n, want[n], a.State)
+		}
+	}
+}
+
+func TestEvaluateFor(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetNodes(offlineNode("lxd0"), onlineNode("lxd1"))
+
+	sink := newRecordingSink()
+	m := newTestMonitor(t, s, &Options{
+		Checks: []Check{{Rule: &NodeStatusRule{}, For: time.Minute, Severity: SeverityCritical}},
+		Sinks:  []Sink{sink},
+	})
+
+	start := time.Now()
+	steps := []struct {
+		offset time.Duration
+		want   []AlertState
+	}{
+		{0, nil},
+		{30 * time.Second, nil},
+		{time.Minute, []AlertState{AlertStateFiring}},
+		// A firing alert is sent only once
+		{2 * time.Minute, nil},
+	}
+	for _, step := range steps {
+		evaluateAt(t, m, start.Add(step.offset))
+		checkAlerts(t, sink.take(), step.want...)
+	}
+
+	firing := m.Firing()
+	if len(firing) != 1 {
+		t.Fatalf("expected 1 firing alert, got %v", firing)
+	}
+	a := firing[0]
+	if a.Rule != "node-status" || a.Subject != "lxd0" || a.Severity != SeverityCritical || !a.StartsAt.Equal(start) {
+		t.Fatalf("unexpected alert %v", a)
+	}
+}
+
+func TestEvaluateResolve(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetNodes(offlineNode("lxd0"))
+
+	sink := newRecordingSink()
+	m := newTestMonitor(t, s, &Options{
+		Checks: []Check{{Rule: &NodeStatusRule{}}},
+		Sinks:  []Sink{sink},
+	})
+
+	start := time.Now()
+	evaluateAt(t, m, start)
+	checkAlerts(t, sink.take(), AlertStateFiring)
+
+	s.SetNodes(onlineNode("lxd0"))
+	end := start.Add(time.Minute)
+	evaluateAt(t, m, end)
+	alerts := sink.take()
+	checkAlerts(t, alerts, AlertStateResolved)
+	if alerts[0].EndsAt == nil || !alerts[0].EndsAt.Equal(end) {
+		t.Fatalf("expected resolved alert to end at %v, got %v", end, alerts[0].EndsAt)
+	}
+	if firing := m.Firing(); len(firing) != 0 {
+		t.Fatalf("expected no firing alerts, got %v", firing)
+	}
+
+	// A violation which recovers before firing sends nothing
+	m.opts.Checks[0].For = time.Hour
+	s.SetNodes(offlineNode("lxd0"))
+	evaluateAt(t, m, end.Add(time.Minute))
+	s.SetNodes(onlineNode("lxd0"))
+	evaluateAt(t, m, end.Add(2*time.Minute))
+	checkAlerts(t, sink.take())
+}
+
+func TestEvaluatePartialStateDoesNotResolve(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	s.SetNodes(offlineNode("lxd0"))
+
+	sink := newRecordingSink()
+	m := newTestMonitor(t, s, &Options{
+		Checks: []Check{{Rule: &NodeStatusRule{}}},
+		Sinks:  []Sink{sink},
+	})
+
+	start := time.Now()
+	evaluateAt(t, m, start)
+	checkAlerts(t, sink.take(), AlertStateFiring)
+
+	// Without the API no nodes are listed, which must not be mistaken for
+	// the node having recovered
+	s.Close()
+	evaluateAt(t, m, start.Add(time.Minute))
+	checkAlerts(t, sink.take())
+	if firing := m.Firing(); len(firing) != 1 {
+		t.Fatalf("expected the alert to keep firing, got %v", firing)
+	}
+}
+
+func TestEvaluateAPIStatus(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+
+	sink := newRecordingSink()
+	m := newTestMonitor(t, s, &Options{
+		Checks: []Check{{Rule: &APIStatusRule{}}},
+		Sinks:  []Sink{sink},
+	})
+
+	start := time.Now()
+	s.SetAPIStatus("degraded")
+	evaluateAt(t, m, start)
+	alerts := sink.take()
+	checkAlerts(t, alerts, AlertStateFiring)
+	if !strings.Contains(alerts[0].Message, "degraded") {
+		t.Fatalf("unexpected message %q", alerts[0].Message)
+	}
+
+	s.SetAPIStatus("stable")
+	evaluateAt(t, m, start.Add(time.Minute))
+	checkAlerts(t, sink.take(), AlertStateResolved)
+}
+
+func TestRunEvaluatesOnEvents(t *testing.T) {
+	s := amstest.NewServer("instance_support")
+	defer s.Close()
+	s.SetNodes(onlineNode("lxd0"))
+
+	sink := newRecordingSink()
+	m := newTestMonitor(t, s, &Options{
+		Checks: []Check{{Rule: &NodeStatusRule{}}},
+		Sinks:  []Sink{sink},
+		// Only events can trigger an evaluation within the test
+		PollInterval: time.Hour,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- m.Run(ctx) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for s.EventListeners() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("monitor did not subscribe to events")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.SetNodes(offlineNode("lxd0"))
+	err := s.SendEvent(api.Event{Type: api.EventTypeLifecycle, Timestamp: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sink.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no alert was sent after the event")
+	}
+	checkAlerts(t, sink.take(), AlertStateFiring)
+}
+
+func TestWebhookSink(t *testing.T) {
+	var (
+		received Alert
+		header   http.Header
+	)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		header = r.Header
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer hook.Close()
+
+	cfg := &SinkConfig{Type: "webhook", URL: hook.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	sink, err := cfg.sink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	alert := &Alert{Rule: "node-status", Severity: SeverityWarning, Subject: "lxd0", Message: "node lxd0 is offline", State: AlertStateFiring}
+	if err := sink.Send(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+	if received.Subject != "lxd0" || received.State != AlertStateFiring {
+		t.Fatalf("unexpected alert %v", received)
+	}
+	if header.Get("Authorization") != "Bearer token" || header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+
+	failing := &WebhookSink{URL: hook.URL + "/missing"}
+	if err := failing.Send(context.Background(), alert); err == nil {
+		t.Fatal("expected an error for a failing webhook")
+	}
+}
+
+func TestExecSink(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "alert")
+	sink := &ExecSink{
+		Command: "sh",
+		Args:    []string{"-c", `cat > "$0.json" && printf '%s %s' "$AMS_ALERT_STATE" "$AMS_ALERT_SUBJECT" > "$0.env"`, out},
+	}
+	alert := &Alert{Rule: "image-sync", Severity: SeverityWarning, Subject: "default/1", State: AlertStateResolved}
+	if err := sink.Send(context.Background(), alert); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(out + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received Alert
+	if err := json.Unmarshal(b, &received); err != nil {
+		t.Fatal(err)
+	}
+	if received.Rule != "image-sync" || received.Subject != "default/1" {
+		t.Fatalf("unexpected alert on stdin %v", received)
+	}
+	env, err := os.ReadFile(out + ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(env) != "resolved default/1" {
+		t.Fatalf("unexpected environment %q", env)
+	}
+
+	failing := &ExecSink{Command: "sh", Args: []string{"-c", "echo broken; exit 1"}}
+	err = failing.Send(context.Background(), alert)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("expected the command output in the error, got %v", err)
+	}
+}
@@ -0,0 +1,186 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package monitor
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+)
+
+// State is what the monitor knows about the cluster at a point in time
+type State struct {
+	Time          time.Time
+	Nodes         []api.Node
+	Instances     []api.Instance
+	Images        []api.Image
+	ServiceStatus *api.ServiceStatus
+	// ServiceErr is set if the service status could not be retrieved
+	ServiceErr error
+	// Partial is set if not all resources could be listed. Alerts are not
+	// resolved based on a partial state.
+	Partial bool
+}
+
+// Finding describes a subject currently violating a rule
+type Finding struct {
+	// Subject identifies what violates the rule, e.g. a node name
+	Subject string
+	Message string
+}
+
+// Rule evaluates the state of the cluster
+type Rule interface {
+	// Name identifies the rule in alerts
+	Name() string
+	// Evaluate returns a finding for every subject violating the rule
+	Evaluate(s *State) []Finding
+}
+
+// NodeStatusRule reports nodes in one of the given states
+type NodeStatusRule struct {
+	// Statuses considered unhealthy. Defaults to offline and error.
+	Statuses []api.NodeStatus
+}
+
+// Name returns the name of the rule
+func (r *NodeStatusRule) Name() string {
+	return "node-status"
+}
+
+// Evaluate returns a finding per unhealthy node
+func (r *NodeStatusRule) Evaluate(s *State) []Finding {
+	statuses := r.Statuses
+	if len(statuses) == 0 {
+		statuses = []api.NodeStatus{api.NodeStatusOffline, api.NodeStatusError}
+	}
+	var findings []Finding
+	for _, n := range s.Nodes {
+		for _, status := range statuses {
+			if n.StatusCode == status {
+				findings = append(findings, Finding{
+					Subject: n.Name,
+					Message: fmt.Sprintf("node %s is %s", n.Name, status.String()),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+// InstanceErrorRule reports applications with too many instances in error
+// state
+type InstanceErrorRule struct {
+	// Threshold is the number of instances in error state of a single
+	// application which triggers the rule. Defaults to one.
+	Threshold int
+}
+
+// Name returns the name of the rule
+func (r *InstanceErrorRule) Name() string {
+	return "instance-errors"
+}
+
+// Evaluate returns a finding per application with too many failed
+// instances. Instances not created from an application are counted per
+// image.
+func (r *InstanceErrorRule) Evaluate(s *State) []Finding {
+	threshold := max(r.Threshold, 1)
+	counts := map[string]int{}
+	for _, inst := range s.Instances {
+		if inst.StatusCode != api.InstanceStatusError {
+			continue
+		}
+		subject := inst.AppName
+		if len(subject) == 0 {
+			subject = shared.ValueOrDefault(inst.AppID, inst.ImageID)
+		}
+		counts[subject]++
+	}
+	var findings []Finding
+	for subject, count := range counts {
+		if count >= threshold {
+			findings = append(findings, Finding{
+				Subject: subject,
+				Message: fmt.Sprintf("%d instances of %s are in error state", count, subject),
+			})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Subject < findings[j].Subject
+	})
+	return findings
+}
+
+// ImageSyncRule reports image versions which failed to synchronize
+type ImageSyncRule struct{}
+
+// Name returns the name of the rule
+func (r *ImageSyncRule) Name() string {
+	return "image-sync"
+}
+
+// Evaluate returns a finding per image version with an error
+func (r *ImageSyncRule) Evaluate(s *State) []Finding {
+	var findings []Finding
+	for _, img := range s.Images {
+		for _, v := range img.Versions {
+			if len(v.ErrorMessage) == 0 {
+				continue
+			}
+			findings = append(findings, Finding{
+				Subject: fmt.Sprintf("%s/%d", img.Name, v.Number),
+				Message: fmt.Sprintf("version %d of image %s failed to sync: %s", v.Number, img.Name, v.ErrorMessage),
+			})
+		}
+	}
+	return findings
+}
+
+// APIStatusRule reports an unreachable or degraded API
+type APIStatusRule struct {
+	// Allowed lists the healthy API statuses. Defaults to stable.
+	Allowed []string
+}
+
+// Name returns the name of the rule
+func (r *APIStatusRule) Name() string {
+	return "api-status"
+}
+
+// Evaluate returns a finding if the API is unhealthy
+func (r *APIStatusRule) Evaluate(s *State) []Finding {
+	if s.ServiceErr != nil {
+		return []Finding{{Subject: "api", Message: fmt.Sprintf("API is unreachable: %v", s.ServiceErr)}}
+	}
+	if s.ServiceStatus == nil {
+		return nil
+	}
+	allowed := r.Allowed
+	if len(allowed) == 0 {
+		allowed = []string{"stable"}
+	}
+	if !shared.StringInSlice(s.ServiceStatus.APIStatus, allowed) {
+		return []Finding{{Subject: "api", Message: fmt.Sprintf("API status is %q", s.ServiceStatus.APIStatus)}}
+	}
+	return nil
+}
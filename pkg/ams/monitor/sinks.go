@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const defaultSinkTimeout = 30 * time.Second
+
+// Sink receives alerts
+type Sink interface {
+	Send(ctx context.Context, alert *Alert) error
+}
+
+// SinkFunc allows using a function as a Sink
+type SinkFunc func(ctx context.Context, alert *Alert) error
+
+// Send calls f(ctx, alert)
+func (f SinkFunc) Send(ctx context.Context, alert *Alert) error {
+	return f(ctx, alert)
+}
+
+// LogSink writes alerts to a logger
+type LogSink struct {
+	// Logger to write to. The standard logger is used if not set.
+	Logger *log.Logger
+}
+
+// Send logs the alert
+func (s *LogSink) Send(_ context.Context, alert *Alert) error {
+	if s.Logger == nil {
+		log.Print(alert)
+		return nil
+	}
+	s.Logger.Print(alert)
+	return nil
+}
+
+// WebhookSink posts alerts as JSON to a URL
+type WebhookSink struct {
+	URL string
+	// Header is added to every request
+	Header http.Header
+	// Client used for the requests. Defaults to a client with a 30 seconds
+	// timeout.
+	Client *http.Client
+}
+
+// Send posts the alert to the webhook
+func (s *WebhookSink) Send(ctx context.Context, alert *Alert) error {
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	for k, v := range s.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultSinkTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// ExecSink runs a command for every alert. The alert is passed as JSON on
+// stdin and its fields as AMS_ALERT_* environment variables.
+type ExecSink struct {
+	Command string
+	Args    []string
+	// Timeout for the command. Defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// Send runs the command
+func (s *ExecSink) Send(ctx context.Context, alert *Alert) error {
+	b, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultSinkTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, s.Command, s.Args...)
+	cmd.Stdin = bytes.NewReader(b)
+	cmd.Env = append(os.Environ(),
+		"AMS_ALERT_RULE="+alert.Rule,
+		"AMS_ALERT_SEVERITY="+string(alert.Severity),
+		"AMS_ALERT_SUBJECT="+alert.Subject,
+		"AMS_ALERT_STATE="+string(alert.State),
+		"AMS_ALERT_MESSAGE="+alert.Message,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", s.Command, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package openapi
+
+import (
+	"strings"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+// endpoint describes an AMS endpoint the SDK calls
+type endpoint struct {
+	method  string
+	path    string
+	id      string
+	summary string
+	query   []string
+	// request is the type of the JSON request body, if any
+	request interface{}
+	// response is the type of the metadata of a sync response, if any
+	response interface{}
+	// async marks endpoints which return an operation
+	async bool
+	// payload marks endpoints which accept a binary payload
+	payload bool
+	// download marks endpoints which return binary content
+	download bool
+}
+
+// header describes a request header
+type header struct {
+	name        string
+	description string
+	required    bool
+}
+
+// payloadHeaders are sent along with every package or image payload
+var payloadHeaders = []header{
+	{name: "X-AMS-Fingerprint", description: "SHA-256 fingerprint of the payload", required: true},
+	{name: "X-AMS-Request", description: "JSON encoded request details"},
+}
+
+// tag groups endpoints by the first element of their path
+func (e endpoint) tag() string {
+	parts := strings.Split(strings.TrimPrefix(e.path, "/"), "/")
+	if len(parts[0]) == 0 {
+		return "server"
+	}
+	return parts[0]
+}
+
+// endpoints lists all published AMS endpoints the SDK calls. Only types and
+// headers documented in api/ams may be referenced here, which the tests
+// cross-check against the swagger annotations.
+var endpoints = []endpoint{
+	// Server
+	{method: "GET", path: "", id: "server_get", summary: "Get the server environment and API extensions", response: api.ServiceStatus{}},
+	{method: "GET", path: "/version", id: "version_get", summary: "Get the server version", response: api.VersionGet{}},
+	{method: "GET", path: "/config", id: "config_get", summary: "Get the configuration", response: api.ConfigGet{}},
+	{method: "PATCH", path: "/config", id: "config_patch", summary: "Update a configuration item", request: api.ConfigPost{}},
+	{method: "GET", path: "/tasks", id: "tasks_get", summary: "List tasks", query: []string{"recursion"}, response: []api.Task{}},
+
+	// Operations
+	{method: "GET", path: "/operations", id: "operations_get", summary: "List operations", query: []string{"recursion"}, response: map[string][]restapi.Operation{}},
+	{method: "GET", path: "/operations/{id}", id: "operation_get", summary: "Get an operation", response: restapi.Operation{}},
+	{method: "DELETE", path: "/operations/{id}", id: "operation_delete", summary: "Cancel an operation"},
+
+	// Addons
+	{method: "GET", path: "/addons", id: "addons_get", summary: "List addons", query: []string{"recursion"}, response: []api.Addon{}},
+	{method: "POST", path: "/addons", id: "addons_post", summary: "Create an addon", payload: true, async: true},
+	{method: "GET", path: "/addons/{name}", id: "addon_get", summary: "Get an addon", response: api.Addon{}},
+	{method: "PATCH", path: "/addons/{name}", id: "addon_patch", summary: "Add a new addon version", payload: true, async: true},
+	{method: "DELETE", path: "/addons/{name}", id: "addon_delete", summary: "Delete an addon", async: true},
+	{method: "DELETE", path: "/addons/{name}/{version}", id: "addon_version_delete", summary: "Delete an addon version", async: true},
+
+	// Applications
+	{method: "GET", path: "/applications", id: "applications_get", summary: "List applications", query: []string{"recursion"}, response: []api.Application{}},
+	{method: "POST", path: "/applications", id: "applications_post", summary: "Create an application", payload: true, async: true},
+	{method: "DELETE", path: "/applications", id: "applications_delete", summary: "Delete multiple applications", request: api.ApplicationsDelete{}, async: true},
+	{method: "GET", path: "/applications/{id}", id: "application_get", summary: "Get an application", response: api.Application{}},
+	{method: "PATCH", path: "/applications/{id}", id: "application_patch", summary: "Update an application", request: api.ApplicationPatch{}, async: true},
+	{method: "DELETE", path: "/applications/{id}", id: "application_delete", summary: "Delete an application", request: api.ApplicationDelete{}, async: true},
+	{method: "GET", path: "/applications/{id}/{version}", id: "application_version_export", summary: "Export an application version", download: true},
+	{method: "PATCH", path: "/applications/{id}/{version}", id: "application_version_patch", summary: "Update an application version", request: api.ApplicationVersionPatch{}, async: true},
+	{method: "DELETE", path: "/applications/{id}/{version}", id: "application_version_delete", summary: "Delete an application version", request: api.ApplicationVersionDelete{}, async: true},
+
+	// Images
+	{method: "GET", path: "/images", id: "images_get", summary: "List images", query: []string{"recursion", "type"}, response: []api.Image{}},
+	{method: "POST", path: "/images", id: "images_post", summary: "Add or import an image", payload: true, async: true},
+	{method: "GET", path: "/images/{id}", id: "image_get", summary: "Get an image", query: []string{"type"}, response: api.Image{}},
+	{method: "PATCH", path: "/images/{id}", id: "image_patch", summary: "Update an image", request: api.ImagePatch{}, async: true},
+	{method: "DELETE", path: "/images/{id}", id: "image_delete", summary: "Delete an image", request: api.ImageDelete{}, async: true},
+	{method: "DELETE", path: "/images/{id}/{version}", id: "image_version_delete", summary: "Delete an image version", async: true},
+
+	// Instances
+	{method: "GET", path: "/instances", id: "instances_get", summary: "List instances", query: []string{"recursion"}, response: []api.Instance{}},
+	{method: "POST", path: "/instances", id: "instances_post", summary: "Launch an instance", query: []string{"no_wait"}, request: api.InstancesPost{}, async: true},
+	{method: "DELETE", path: "/instances", id: "instances_delete", summary: "Delete multiple instances", request: api.InstancesDelete{}, async: true},
+	{method: "GET", path: "/instances/{id}", id: "instance_get", summary: "Get an instance", response: api.Instance{}},
+	{method: "PATCH", path: "/instances/{id}", id: "instance_patch", summary: "Update an instance", request: api.InstancePatch{}, async: true},
+	{method: "DELETE", path: "/instances/{id}", id: "instance_delete", summary: "Delete an instance", request: api.InstanceDelete{}, async: true},
+	{method: "POST", path: "/instances/{id}/exec", id: "instance_exec_post", summary: "Execute a command in an instance", request: api.InstanceExecPost{}, async: true},
+	{method: "GET", path: "/instances/{id}/logs/{name}", id: "instance_log_get", summary: "Get a log file of an instance", download: true},
+	{method: "GET", path: "/instances/{id}/shares", id: "instance_shares_get", summary: "List shares of an instance", query: []string{"recursion"}, response: []api.InstanceShare{}},
+	{method: "POST", path: "/instances/{id}/shares", id: "instance_shares_post", summary: "Share an instance", request: api.InstanceSharesPost{}, response: api.InstanceSharesPostResponse{}},
+	{method: "PATCH", path: "/instances/{id}/shares/{share_id}", id: "instance_share_patch", summary: "Update an instance share", request: api.InstanceSharePatch{}},
+	{method: "DELETE", path: "/instances/{id}/shares/{share_id}", id: "instance_share_delete", summary: "Delete an instance share", async: true},
+
+	// Containers
+	{method: "GET", path: "/containers", id: "containers_get", summary: "List containers", query: []string{"recursion"}, response: []api.Container{}},
+	{method: "POST", path: "/containers", id: "containers_post", summary: "Launch a container", query: []string{"no_wait"}, request: api.ContainersPost{}, async: true},
+	{method: "DELETE", path: "/containers", id: "containers_delete", summary: "Delete multiple containers", request: api.ContainersDelete{}, async: true},
+	{method: "GET", path: "/containers/{id}", id: "container_get", summary: "Get a container", response: api.Container{}},
+	{method: "PATCH", path: "/containers/{id}", id: "container_patch", summary: "Update a container", request: api.ContainerPatch{}, async: true},
+	{method: "DELETE", path: "/containers/{id}", id: "container_delete", summary: "Delete a container", request: api.ContainerDelete{}, async: true},
+	{method: "POST", path: "/containers/{id}/exec", id: "container_exec_post", summary: "Execute a command in a container", request: api.ContainerExecPost{}, async: true},
+	{method: "GET", path: "/containers/{id}/logs/{name}", id: "container_log_get", summary: "Get a log file of a container", download: true},
+
+	// Nodes
+	{method: "GET", path: "/nodes", id: "nodes_get", summary: "List nodes", query: []string{"recursion"}, response: []api.Node{}},
+	{method: "POST", path: "/nodes", id: "nodes_post", summary: "Add a node", request: api.NodesPost{}, async: true},
+	{method: "GET", path: "/nodes/{name}", id: "node_get", summary: "Get a node", response: api.Node{}},
+	{method: "PATCH", path: "/nodes/{name}", id: "node_patch", summary: "Update a node", request: api.NodePatch{}, async: true},
+	{method: "DELETE", path: "/nodes/{name}", id: "node_delete", summary: "Remove a node", request: api.NodeDelete{}, async: true},
+
+	// Certificates
+	{method: "GET", path: "/certificates", id: "certificates_get", summary: "List trusted certificates", query: []string{"recursion"}, response: []restapi.Certificate{}},
+	{method: "POST", path: "/certificates", id: "certificates_post", summary: "Add a trusted certificate", request: restapi.CertificatesPost{}},
+	{method: "DELETE", path: "/certificates/{fingerprint}", id: "certificate_delete", summary: "Remove a trusted certificate"},
+
+	// Registry
+	{method: "GET", path: "/registry/applications", id: "registry_applications_get", summary: "List applications in the registry", response: []api.RegistryApplication{}},
+	{method: "DELETE", path: "/registry/applications/{id}", id: "registry_application_delete", summary: "Delete an application from the registry", async: true},
+	{method: "POST", path: "/registry/applications/{id}/push", id: "registry_application_push", summary: "Push an application to the registry", async: true},
+	{method: "POST", path: "/registry/applications/{id}/pull", id: "registry_application_pull", summary: "Pull an application from the registry", async: true},
+
+	// Authentication and authorization
+	{method: "GET", path: "/auth/oidc", id: "auth_oidc_get", summary: "Get the OIDC configuration", query: []string{"grant_type"}, response: restapi.OIDCResponse{}},
+	{method: "GET", path: "/auth/permissions", id: "auth_permissions_get", summary: "List permissions of the current identity", response: []api.Permission{}},
+	{method: "GET", path: "/auth/groups", id: "auth_groups_get", summary: "List authorization groups", query: []string{"recursion"}, response: []api.AuthGroup{}},
+	{method: "POST", path: "/auth/groups", id: "auth_groups_post", summary: "Create an authorization group", request: api.AuthGroupPost{}, async: true},
+	{method: "GET", path: "/auth/groups/{name}", id: "auth_group_get", summary: "Get an authorization group", response: api.AuthGroup{}},
+	{method: "PUT", path: "/auth/groups/{name}", id: "auth_group_put", summary: "Update an authorization group", request: api.AuthGroupPut{}, async: true},
+	{method: "DELETE", path: "/auth/groups/{name}", id: "auth_group_delete", summary: "Delete an authorization group", request: api.AuthGroupDelete{}, async: true},
+	{method: "GET", path: "/auth/identities", id: "auth_identities_get", summary: "List identities", query: []string{"recursion"}, response: []api.Identity{}},
+	{method: "POST", path: "/auth/identities", id: "auth_identities_post", summary: "Create an identity", request: api.IdentityPost{}, async: true},
+	{method: "GET", path: "/auth/identities/{id}", id: "auth_identity_get", summary: "Get an identity", response: api.Identity{}},
+	{method: "PUT", path: "/auth/identities/{id}", id: "auth_identity_put", summary: "Update an identity", request: api.IdentityPut{}, async: true},
+	{method: "DELETE", path: "/auth/identities/{id}", id: "auth_identity_delete", summary: "Delete an identity", request: api.IdentityDelete{}, async: true},
+}
@@ -0,0 +1,277 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+//go:generate go run ../../../examples/ams/openapi -output ../../../api/openapi.json
+
+// Package openapi assembles an OpenAPI 3 document for the AMS endpoints the
+// SDK uses. All schemas are derived from the Go types of the SDK.
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/schema"
+	restapi "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/rest/api"
+)
+
+// Version is the OpenAPI version of the generated document. 3.1 is used as
+// it is aligned with JSON Schema and supports nullable types.
+const Version = "3.1.0"
+
+const componentsPrefix = "#/components/schemas/"
+
+// Document represents an OpenAPI document
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of a single path, indexed by the lower case
+// HTTP method
+type PathItem map[string]*Operation
+
+// Operation describes a single API operation
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// Parameter describes a path, query or header parameter
+type Parameter struct {
+	Name        string         `json:"name"`
+	In          string         `json:"in"`
+	Description string         `json:"description,omitempty"`
+	Required    bool           `json:"required,omitempty"`
+	Schema      *schema.Schema `json:"schema"`
+}
+
+// RequestBody describes the body of a request
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *schema.Schema `json:"schema"`
+}
+
+// Components holds all schemas referenced from the paths
+type Components struct {
+	Schemas map[string]*schema.Schema `json:"schemas"`
+}
+
+var pathParamRegexp = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Generate assembles the OpenAPI document for all endpoints the SDK uses
+func Generate() *Document {
+	g := schema.NewGenerator(schema.GenerateOptions{Tag: "json", RefPrefix: componentsPrefix})
+	responseRef := g.Schema(restapi.Response{})
+	operationRef := g.Schema(restapi.Operation{})
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info: Info{
+			Title:       "AMS external REST API",
+			Description: "Endpoints of the Anbox Management Service used by the AMS SDK",
+			Version:     restapi.Version,
+		},
+		Paths: map[string]*PathItem{},
+	}
+
+	for _, e := range endpoints {
+		path := "/" + restapi.Version + e.path
+		if len(e.path) == 0 {
+			path = "/" + restapi.Version
+		}
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(e.method)] = e.operation(g, responseRef, operationRef)
+	}
+
+	doc.Components.Schemas = g.Defs()
+	return doc
+}
+
+func (e endpoint) operation(g *schema.Generator, responseRef, operationRef *schema.Schema) *Operation {
+	op := &Operation{
+		OperationID: e.id,
+		Summary:     e.summary,
+		Tags:        []string{e.tag()},
+		Responses: map[string]*Response{
+			"default": {
+				Description: "Error",
+				Content:     jsonContent(responseRef),
+			},
+		},
+	}
+
+	for _, m := range pathParamRegexp.FindAllStringSubmatch(e.path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &schema.Schema{Type: schema.Types{"string"}},
+		})
+	}
+	for _, q := range e.query {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:   q,
+			In:     "query",
+			Schema: &schema.Schema{Type: schema.Types{"string"}},
+		})
+	}
+
+	switch {
+	case e.payload:
+		for _, h := range payloadHeaders {
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:        h.name,
+				In:          "header",
+				Description: h.description,
+				Required:    h.required,
+				Schema:      &schema.Schema{Type: schema.Types{"string"}},
+			})
+		}
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content: map[string]MediaType{
+				"application/octet-stream": {Schema: &schema.Schema{Type: schema.Types{"string"}, Format: "binary"}},
+			},
+		}
+	case e.request != nil:
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  jsonContent(g.Schema(e.request)),
+		}
+	}
+
+	switch {
+	case e.download:
+		op.Responses["200"] = &Response{
+			Description: "Content",
+			Content: map[string]MediaType{
+				"application/octet-stream": {Schema: &schema.Schema{Type: schema.Types{"string"}, Format: "binary"}},
+			},
+		}
+	case e.async:
+		op.Responses["202"] = &Response{
+			Description: "Operation created",
+			Content:     jsonContent(envelope(responseRef, operationRef)),
+		}
+	case e.response != nil:
+		op.Responses["200"] = &Response{
+			Description: "Success",
+			Content:     jsonContent(envelope(responseRef, g.Schema(e.response))),
+		}
+	default:
+		op.Responses["200"] = &Response{
+			Description: "Success",
+			Content:     jsonContent(responseRef),
+		}
+	}
+	return op
+}
+
+// envelope returns the schema of a response whose metadata has the given
+// schema
+func envelope(responseRef, metadata *schema.Schema) *schema.Schema {
+	return &schema.Schema{
+		AllOf: []*schema.Schema{
+			responseRef,
+			{
+				Type:       schema.Types{"object"},
+				Properties: map[string]*schema.Schema{"metadata": metadata},
+			},
+		},
+	}
+}
+
+func jsonContent(s *schema.Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: s}}
+}
+
+// JSON returns the document as indented JSON. The output is stable as all
+// maps are marshalled with sorted keys.
+func (d *Document) JSON() ([]byte, error) {
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// Check compares the document stored at the given path with a freshly
+// generated one. An error is returned if they differ, e.g. because a Go type
+// changed without the stored document being regenerated.
+func Check(path string) error {
+	stored, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	generated, err := Generate().JSON()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(stored, generated) {
+		return nil
+	}
+
+	storedLines := strings.Split(string(stored), "\n")
+	generatedLines := strings.Split(string(generated), "\n")
+	for n := 0; n < len(storedLines) || n < len(generatedLines); n++ {
+		var s, g string
+		if n < len(storedLines) {
+			s = storedLines[n]
+		}
+		if n < len(generatedLines) {
+			g = generatedLines[n]
+		}
+		if s != g {
+			return fmt.Errorf("%s is out of date, first difference in line %d:\n- %s\n+ %s", path, n+1, strings.TrimSpace(s), strings.TrimSpace(g))
+		}
+	}
+	return fmt.Errorf("%s is out of date", path)
+}
@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package openapi
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+)
+
+const apiDir = "../../../api"
+
+func TestStoredDocumentIsUpToDate(t *testing.T) {
+	if err := Check(filepath.Join(apiDir, "openapi.json")); err != nil {
+		t.Fatalf("%v\nrun go generate ./pkg/ams/openapi to update it", err)
+	}
+}
+
+// swaggerModels returns the names of all types in api/ams annotated with
+// swagger:model
+func swaggerModels(t *testing.T) map[string]bool {
+	t.Helper()
+	fset := token.NewFileSet()
+	files, err := filepath.Glob(filepath.Join(apiDir, "ams", "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	models := map[string]bool{}
+	for _, name := range files {
+		f, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE || gen.Doc == nil {
+				continue
+			}
+			if !strings.Contains(gen.Doc.Text(), "swagger:model") {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				models[spec.(*ast.TypeSpec).Name.Name] = true
+			}
+		}
+	}
+	return models
+}
+
+// apiType returns the named type of v from the api/ams package, if any
+func apiType(v interface{}) (reflect.Type, bool) {
+	if v == nil {
+		return nil, false
+	}
+	typ := reflect.TypeOf(v)
+	for typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map || typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ, typ.PkgPath() == reflect.TypeOf(api.ServiceStatus{}).PkgPath()
+}
+
+// undocumentedModels are types of published endpoints which the swagger
+// annotations in api/ams don't cover yet
+var undocumentedModels = map[string]bool{
+	"ConfigGet":          true,
+	"InstanceSharesPost": true,
+	"InstanceSharePatch": true,
+}
+
+func TestEndpointTypesAreDocumented(t *testing.T) {
+	models := swaggerModels(t)
+	for name := range undocumentedModels {
+		if models[name] {
+			t.Errorf("%s is a swagger model now and can be removed from undocumentedModels", name)
+		}
+		models[name] = true
+	}
+	for _, e := range endpoints {
+		for _, v := range []interface{}{e.request, e.response} {
+			typ, ok := apiType(v)
+			if ok && !models[typ.Name()] {
+				t.Errorf("%s %s uses %s which is not a swagger model", e.method, e.path, typ.Name())
+			}
+		}
+	}
+}
+
+func TestPayloadHeadersAreDocumented(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(apiDir, "ams", "swagger.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	section := string(b)
+	if i := strings.Index(section, "## File upload"); i >= 0 {
+		section = section[i:]
+	}
+	for _, h := range payloadHeaders {
+		if !strings.Contains(section, "* "+h.name+":") {
+			t.Errorf("payload header %s is not documented", h.name)
+		}
+	}
+}
+
+func TestEndpointIDsAreUnique(t *testing.T) {
+	ids := map[string]bool{}
+	for _, e := range endpoints {
+		if ids[e.id] {
+			t.Errorf("duplicate operation ID %s", e.id)
+		}
+		ids[e.id] = true
+	}
+}
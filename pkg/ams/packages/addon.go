@@ -0,0 +1,220 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// HooksDirName is the directory holding the hooks of an addon package
+const HooksDirName = "hooks"
+
+// AddonHooks lists all hooks an addon can provide
+var AddonHooks = []string{
+	"install",
+	"prepare",
+	"pre-start",
+	"post-start",
+	"post-stop",
+	"backup",
+	"restore",
+}
+
+var addonABIRegexp = regexp.MustCompile(`^[a-z0-9_\-]+$`)
+
+// AddonManifestProvides describes what an addon provides to an instance
+type AddonManifestProvides struct {
+	ABISupport   []string `yaml:"abi-support,omitempty"`
+	Capabilities []string `yaml:"capabilities,omitempty"`
+}
+
+// AddonManifest represents the manifest.yaml of an addon package
+type AddonManifest struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description,omitempty"`
+	Provides    *AddonManifestProvides `yaml:"provides,omitempty"`
+	Hooks       *api.ApplicationHooks  `yaml:"hooks,omitempty"`
+}
+
+// ParseAddonManifest parses an addon manifest from its YAML representation
+func ParseAddonManifest(data []byte) (*AddonManifest, error) {
+	m := &AddonManifest{}
+	if err := ParseManifest(bytes.NewReader(data), m); err != nil {
+		return nil, errs.NewErrInvalidFormat(fmt.Sprintf("manifest: %v", err))
+	}
+	return m, nil
+}
+
+// Validate checks all fields of the manifest against the rules AMS enforces
+func (m *AddonManifest) Validate() error {
+	var fe FieldErrors
+
+	if len(m.Name) == 0 {
+		fe.Add("name", errs.NewErrRequired("name"))
+	} else if !addonNameRegexp.MatchString(m.Name) {
+		fe.Addf("name", "invalid addon name %q", m.Name)
+	}
+
+	if m.Provides != nil {
+		for n, abi := range m.Provides.ABISupport {
+			if !addonABIRegexp.MatchString(abi) {
+				fe.Addf(fieldPath("provides", "abi-support", n), "invalid ABI %q", abi)
+			}
+		}
+		validateNonEmptyList(&fe, "provides.capabilities", m.Provides.Capabilities)
+	}
+
+	if m.Hooks != nil && len(m.Hooks.Timeout) > 0 {
+		if err := ValidateHookTimeout(m.Hooks.Timeout); err != nil {
+			fe.Add("hooks.timeout", err)
+		}
+	}
+
+	return fe.Err()
+}
+
+// LintReport summarizes the result of linting a package
+type LintReport struct {
+	// Errors lists all problems which cause AMS to reject the package
+	Errors FieldErrors
+	// Warnings lists problems which don't prevent the package from being
+	// accepted but likely point to a mistake
+	Warnings []string
+}
+
+// Err returns the errors of the report or nil if there are none
+func (r *LintReport) Err() error {
+	return r.Errors.Err()
+}
+
+// String returns a human readable summary of the report
+func (r *LintReport) String() string {
+	var b strings.Builder
+	for _, e := range r.Errors {
+		fmt.Fprintf(&b, "error: %v\n", e)
+	}
+	for _, w := range r.Warnings {
+		fmt.Fprintf(&b, "warning: %s\n", w)
+	}
+	fmt.Fprintf(&b, "%d error(s), %d warning(s)", len(r.Errors), len(r.Warnings))
+	return b.String()
+}
+
+// AddonPackage represents an addon package which is either a directory, a
+// tarball or a zip archive
+type AddonPackage struct {
+	path     string
+	manifest *AddonManifest
+	files    *packageFiles
+}
+
+// NewAddonPackage loads the addon package at the given path
+func NewAddonPackage(packagePath string) (*AddonPackage, error) {
+	files, err := readPackageFiles(packagePath, ManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	content, ok := files.Content(ManifestFileName)
+	if !ok {
+		return nil, errs.NewErrNotFound(ManifestFileName)
+	}
+	manifest, err := ParseAddonManifest(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AddonPackage{
+		path:     packagePath,
+		manifest: manifest,
+		files:    files,
+	}, nil
+}
+
+// Lint checks the manifest and the hooks of the package and returns a
+// summary of all problems found
+func (p *AddonPackage) Lint() *LintReport {
+	r := &LintReport{}
+	if err := p.manifest.Validate(); err != nil {
+		r.Errors = append(r.Errors, err.(FieldErrors)...)
+	}
+
+	if !p.files.HasPrefix(HooksDirName) {
+		r.Errors.Add(HooksDirName, errs.NewErrNotFound(HooksDirName+" directory"))
+		return r
+	}
+
+	var names []string
+	for name := range p.files.entries {
+		if path.Dir(name) == HooksDirName {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		hook := path.Base(name)
+		entry := p.files.entries[name]
+		if !shared.StringInSlice(hook, AddonHooks) {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s is not a known hook and will never be called", name))
+			continue
+		}
+		if !entry.Mode.IsRegular() {
+			r.Errors.Addf(name, "hook is not a regular file")
+			continue
+		}
+		if entry.Mode.Perm()&0111 == 0 {
+			r.Errors.Add(name, errs.NewErrNotExecutable(name))
+		}
+		if entry.Size == 0 {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%s is empty", name))
+		}
+	}
+
+	return r
+}
+
+// Validate validates the manifest and the hooks of the package
+func (p *AddonPackage) Validate() error {
+	return p.Lint().Err()
+}
+
+// Manifest returns the manifest structure of the package
+func (p *AddonPackage) Manifest() interface{} {
+	return p.manifest
+}
+
+// AddonManifest returns the typed manifest of the package
+func (p *AddonPackage) AddonManifest() *AddonManifest {
+	return p.manifest
+}
+
+// Path returns the path of the package
+func (p *AddonPackage) Path() string {
+	return p.path
+}
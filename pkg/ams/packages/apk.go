@@ -0,0 +1,263 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"unicode/utf16"
+
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+const (
+	androidManifestFileName = "AndroidManifest.xml"
+	// maxAndroidManifestSize limits the size of the binary manifest read
+	// from an APK
+	maxAndroidManifestSize = 16 * 1024 * 1024
+
+	axmlStringPoolType   = 0x0001
+	axmlFileType         = 0x0003
+	axmlStartElementType = 0x0102
+	axmlUTF8Flag         = 1 << 8
+	axmlTypeString       = 0x03
+	axmlTypeIntDec       = 0x10
+	axmlTypeIntHex       = 0x11
+	axmlNoEntry          = 0xffffffff
+)
+
+// APKInfo holds the identity of an Android application package
+type APKInfo struct {
+	PackageName string `json:"package_name" yaml:"package-name"`
+	VersionCode int64  `json:"version_code" yaml:"version-code"`
+	VersionName string `json:"version_name,omitempty" yaml:"version-name,omitempty"`
+}
+
+// ReadAPKInfo reads the package name and version from the binary
+// AndroidManifest.xml of the APK at the given path
+func ReadAPKInfo(apkPath string) (*APKInfo, error) {
+	zr, err := zip.OpenReader(apkPath)
+	if err != nil {
+		return nil, errs.NewErrInvalidFormat(fmt.Sprintf("APK %s: %v", apkPath, err))
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		if f.Name != androidManifestFileName {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		data, err := io.ReadAll(io.LimitReader(r, maxAndroidManifestSize))
+		if err != nil {
+			return nil, err
+		}
+		info, err := parseBinaryAndroidManifest(data)
+		if err != nil {
+			return nil, errs.NewErrInvalidFormat(fmt.Sprintf("APK %s: %v", apkPath, err))
+		}
+		return info, nil
+	}
+	return nil, errs.NewErrNotFound(androidManifestFileName)
+}
+
+// parseBinaryAndroidManifest extracts the attributes of the <manifest>
+// element from the compiled binary XML format Android uses inside APKs
+func parseBinaryAndroidManifest(data []byte) (*APKInfo, error) {
+	le := binary.LittleEndian
+	if len(data) < 8 || le.Uint16(data) != axmlFileType {
+		return nil, fmt.Errorf("%s is not a binary XML file", androidManifestFileName)
+	}
+
+	var strs []string
+	offset := int(le.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		chunkType := le.Uint16(data[offset:])
+		headerSize := int(le.Uint16(data[offset+2:]))
+		chunkSize := int(le.Uint32(data[offset+4:]))
+		if chunkSize < 8 || offset+chunkSize > len(data) {
+			return nil, fmt.Errorf("invalid chunk at offset %d", offset)
+		}
+		chunk := data[offset : offset+chunkSize]
+
+		switch chunkType {
+		case axmlStringPoolType:
+			var err error
+			strs, err = parseStringPool(chunk)
+			if err != nil {
+				return nil, err
+			}
+		case axmlStartElementType:
+			info, ok, err := parseManifestElement(chunk, headerSize, strs)
+			if err != nil || ok {
+				return info, err
+			}
+		}
+		offset += chunkSize
+	}
+	return nil, fmt.Errorf("no manifest element found")
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	le := binary.LittleEndian
+	if len(chunk) < 28 {
+		return nil, fmt.Errorf("string pool is truncated")
+	}
+	count := int(le.Uint32(chunk[8:]))
+	flags := le.Uint32(chunk[16:])
+	stringsStart := int(le.Uint32(chunk[20:]))
+	if 28+4*count > len(chunk) || stringsStart > len(chunk) {
+		return nil, fmt.Errorf("string pool is truncated")
+	}
+
+	strs := make([]string, count)
+	for n := 0; n < count; n++ {
+		pos := stringsStart + int(le.Uint32(chunk[28+4*n:]))
+		if pos >= len(chunk) {
+			return nil, fmt.Errorf("string %d is out of bounds", n)
+		}
+		var err error
+		if flags&axmlUTF8Flag != 0 {
+			strs[n], err = decodeUTF8PoolString(chunk[pos:])
+		} else {
+			strs[n], err = decodeUTF16PoolString(chunk[pos:])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return strs, nil
+}
+
+func decodeUTF8PoolString(b []byte) (string, error) {
+	// The length in characters comes first, followed by the length in bytes.
+	// Both use a second byte if the high bit is set.
+	pos := 0
+	skipLength := func() int {
+		if pos >= len(b) {
+			return -1
+		}
+		l := int(b[pos])
+		pos++
+		if l&0x80 != 0 {
+			if pos >= len(b) {
+				return -1
+			}
+			l = (l&0x7f)<<8 | int(b[pos])
+			pos++
+		}
+		return l
+	}
+	skipLength()
+	size := skipLength()
+	if size < 0 || pos+size > len(b) {
+		return "", fmt.Errorf("string is out of bounds")
+	}
+	return string(b[pos : pos+size]), nil
+}
+
+func decodeUTF16PoolString(b []byte) (string, error) {
+	le := binary.LittleEndian
+	if len(b) < 2 {
+		return "", fmt.Errorf("string is out of bounds")
+	}
+	pos := 2
+	size := int(le.Uint16(b))
+	if size&0x8000 != 0 {
+		if len(b) < 4 {
+			return "", fmt.Errorf("string is out of bounds")
+		}
+		size = (size&0x7fff)<<16 | int(le.Uint16(b[2:]))
+		pos = 4
+	}
+	if pos+2*size > len(b) {
+		return "", fmt.Errorf("string is out of bounds")
+	}
+	units := make([]uint16, size)
+	for n := range units {
+		units[n] = le.Uint16(b[pos+2*n:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// parseManifestElement returns the APK info if the element is the
+// <manifest> element
+func parseManifestElement(chunk []byte, headerSize int, strs []string) (*APKInfo, bool, error) {
+	le := binary.LittleEndian
+	ext := headerSize
+	if ext+20 > len(chunk) {
+		return nil, false, fmt.Errorf("element is truncated")
+	}
+	lookup := func(idx uint32) string {
+		if idx == axmlNoEntry || int(idx) >= len(strs) {
+			return ""
+		}
+		return strs[idx]
+	}
+	if lookup(le.Uint32(chunk[ext+4:])) != "manifest" {
+		return nil, false, nil
+	}
+
+	attrStart := int(le.Uint16(chunk[ext+8:]))
+	attrSize := int(le.Uint16(chunk[ext+10:]))
+	attrCount := int(le.Uint16(chunk[ext+12:]))
+	if attrSize < 20 || ext+attrStart+attrSize*attrCount > len(chunk) {
+		return nil, false, fmt.Errorf("manifest element is truncated")
+	}
+
+	info := &APKInfo{}
+	for n := 0; n < attrCount; n++ {
+		attr := chunk[ext+attrStart+n*attrSize:]
+		name := lookup(le.Uint32(attr[4:]))
+		raw := lookup(le.Uint32(attr[8:]))
+		dataType := attr[15]
+		value := le.Uint32(attr[16:])
+
+		asString := raw
+		if len(asString) == 0 {
+			switch dataType {
+			case axmlTypeString:
+				asString = lookup(value)
+			case axmlTypeIntDec, axmlTypeIntHex:
+				asString = strconv.FormatInt(int64(int32(value)), 10)
+			}
+		}
+
+		switch name {
+		case "package":
+			info.PackageName = asString
+		case "versionName":
+			info.VersionName = asString
+		case "versionCode":
+			if dataType == axmlTypeIntDec || dataType == axmlTypeIntHex {
+				info.VersionCode = int64(value)
+			} else if v, err := strconv.ParseInt(asString, 10, 64); err == nil {
+				info.VersionCode = v
+			}
+		}
+	}
+	return info, true, nil
+}
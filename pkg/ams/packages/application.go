@@ -0,0 +1,319 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/constants"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+const (
+	// ManifestFileName is the name of the manifest file inside a package
+	ManifestFileName = "manifest.yaml"
+	// ApplicationAPKFileName is the name of the APK inside an application package
+	ApplicationAPKFileName = "app.apk"
+	// ExtraDataDirName is the directory holding the extra data inside an
+	// application package
+	ExtraDataDirName = "extra-data"
+)
+
+var (
+	applicationNameRegexp    = regexp.MustCompile(constants.ApplicationNamePattern)
+	addonNameRegexp          = regexp.MustCompile(constants.AddonNamePattern)
+	androidPackageNameRegexp = regexp.MustCompile(constants.AndroidPackageNamePattern)
+	instanceTypeRegexp       = regexp.MustCompile(constants.InstanceTypePattern)
+	extraDataOwnerRegexp     = regexp.MustCompile(`^[A-Za-z0-9_\-]+:[A-Za-z0-9_\-]+$`)
+)
+
+// SupportedGPUTypes lists the GPU types an application can ask for
+var SupportedGPUTypes = []string{"amd", "intel", "nvidia"}
+
+// ApplicationManifestService describes a network service an application
+// exposes on its instances
+type ApplicationManifestService struct {
+	Name      string                `yaml:"name,omitempty"`
+	Port      int                   `yaml:"port"`
+	PortEnd   int                   `yaml:"port-end,omitempty"`
+	Protocols []api.NetworkProtocol `yaml:"protocols"`
+	Expose    bool                  `yaml:"expose"`
+}
+
+// ApplicationManifest represents the manifest.yaml of an application package
+type ApplicationManifest struct {
+	Name                string                              `yaml:"name"`
+	ManifestVersion     string                              `yaml:"manifest-version,omitempty"`
+	InstanceType        string                              `yaml:"instance-type,omitempty"`
+	Image               string                              `yaml:"image,omitempty"`
+	BootPackage         string                              `yaml:"boot-package,omitempty"`
+	BootActivity        string                              `yaml:"boot-activity,omitempty"`
+	RequiredPermissions []string                            `yaml:"required-permissions,omitempty"`
+	Addons              []string                            `yaml:"addons,omitempty"`
+	Tags                []string                            `yaml:"tags,omitempty"`
+	Resources           *api.ApplicationResources           `yaml:"resources,omitempty"`
+	Services            []ApplicationManifestService        `yaml:"services,omitempty"`
+	Watchdog            *api.ApplicationWatchdog            `yaml:"watchdog,omitempty"`
+	ExtraData           map[string]api.ApplicationExtraData `yaml:"extra-data,omitempty"`
+	Features            []string                            `yaml:"features,omitempty"`
+	VideoEncoder        api.VideoEncoderType                `yaml:"video-encoder,omitempty"`
+	Hooks               *api.ApplicationHooks               `yaml:"hooks,omitempty"`
+	Bootstrap           *api.ApplicationBootstrap           `yaml:"bootstrap,omitempty"`
+	NodeSelector        []string                            `yaml:"node-selector,omitempty"`
+}
+
+// ParseApplicationManifest parses an application manifest from its YAML representation
+func ParseApplicationManifest(data []byte) (*ApplicationManifest, error) {
+	m := &ApplicationManifest{}
+	if err := ParseManifest(bytes.NewReader(data), m); err != nil {
+		return nil, errs.NewErrInvalidFormat(fmt.Sprintf("manifest: %v", err))
+	}
+	return m, nil
+}
+
+// Validate checks all fields of the manifest against the rules AMS enforces.
+// All problems found are returned as FieldErrors.
+func (m *ApplicationManifest) Validate() error {
+	var fe FieldErrors
+
+	if len(m.Name) == 0 {
+		fe.Add("name", errs.NewErrRequired("name"))
+	} else if !applicationNameRegexp.MatchString(m.Name) {
+		fe.Addf("name", "invalid application name %q", m.Name)
+	}
+
+	if len(m.InstanceType) > 0 && !instanceTypeRegexp.MatchString(m.InstanceType) {
+		fe.Addf("instance-type", "invalid instance type %q", m.InstanceType)
+	}
+	if len(m.InstanceType) == 0 && m.Resources == nil {
+		fe.Addf("instance-type", "either an instance type or resources must be specified")
+	}
+
+	if len(m.BootPackage) > 0 && !androidPackageNameRegexp.MatchString(m.BootPackage) {
+		fe.Addf("boot-package", "invalid Android package name %q", m.BootPackage)
+	}
+	if len(m.BootActivity) > 0 && len(m.BootPackage) == 0 {
+		fe.Addf("boot-activity", "requires boot-package to be set")
+	}
+
+	validateNonEmptyList(&fe, "required-permissions", m.RequiredPermissions)
+	validateNonEmptyList(&fe, "tags", m.Tags)
+	validateNonEmptyList(&fe, "features", m.Features)
+	validateNonEmptyList(&fe, "node-selector", m.NodeSelector)
+
+	for n, addon := range m.Addons {
+		if len(addon) == 0 || !addonNameRegexp.MatchString(addon) {
+			fe.Addf(fieldPath("addons", n), "invalid addon name %q", addon)
+		}
+	}
+
+	if m.Resources != nil {
+		validateResources(&fe, m.Resources)
+	}
+
+	for n, svc := range m.Services {
+		validateService(&fe, fieldPath("services", n), svc)
+	}
+
+	if m.Watchdog != nil {
+		if err := m.Watchdog.ValidateAllowedPackages(); err != nil {
+			fe.Add("watchdog.allowed-packages", err)
+		}
+	}
+
+	for _, name := range m.extraDataNames() {
+		validateExtraData(&fe, fieldPath("extra-data", name), name, m.ExtraData[name])
+	}
+
+	if len(m.VideoEncoder) > 0 && api.VideoEncoderFromString(string(m.VideoEncoder)) == api.VideoEncoderTypeUnknown {
+		fe.Addf("video-encoder", "unknown video encoder %q", m.VideoEncoder)
+	}
+
+	if m.Hooks != nil && len(m.Hooks.Timeout) > 0 {
+		if err := ValidateHookTimeout(m.Hooks.Timeout); err != nil {
+			fe.Add("hooks.timeout", err)
+		}
+	}
+
+	if m.Bootstrap != nil {
+		for n, keep := range m.Bootstrap.Keep {
+			if len(keep) == 0 || path.IsAbs(keep) || strings.HasPrefix(path.Clean(keep), "..") {
+				fe.Addf(fieldPath("bootstrap", "keep", n), "invalid path %q", keep)
+			}
+		}
+	}
+
+	return fe.Err()
+}
+
+// extraDataNames returns the names of all extra data entries in a stable order
+func (m *ApplicationManifest) extraDataNames() []string {
+	names := make([]string, 0, len(m.ExtraData))
+	for name := range m.ExtraData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func validateNonEmptyList(fe *FieldErrors, field string, values []string) {
+	for n, v := range values {
+		if len(strings.TrimSpace(v)) == 0 {
+			fe.Addf(fieldPath(field, n), "must not be empty")
+		}
+	}
+}
+
+func validateResources(fe *FieldErrors, r *api.ApplicationResources) {
+	if r.CPUs < 0 {
+		fe.Addf("resources.cpus", "must not be negative")
+	}
+	if len(r.Memory) > 0 {
+		if _, err := shared.ParseByteSizeString(r.Memory); err != nil {
+			fe.Add("resources.memory", err)
+		}
+	}
+	if len(r.DiskSize) > 0 {
+		if _, err := shared.ParseByteSizeString(r.DiskSize); err != nil {
+			fe.Add("resources.disk-size", err)
+		}
+	}
+	if r.GPUSlots < 0 {
+		fe.Addf("resources.gpu-slots", "must not be negative")
+	}
+	if len(r.GPUType) > 0 && !shared.StringInSlice(r.GPUType, SupportedGPUTypes) {
+		fe.Addf("resources.gpu-type", "unknown GPU type %q, expected one of %s", r.GPUType, strings.Join(SupportedGPUTypes, ", "))
+	}
+	if r.VPUSlots < 0 {
+		fe.Addf("resources.vpu-slots", "must not be negative")
+	}
+}
+
+func validateService(fe *FieldErrors, field string, svc ApplicationManifestService) {
+	if svc.Port <= 0 || svc.Port > 65535 {
+		fe.Addf(fieldPath(field, "port"), "port %d is out of range", svc.Port)
+	}
+	if svc.PortEnd != 0 && (svc.PortEnd < svc.Port || svc.PortEnd > 65535) {
+		fe.Addf(fieldPath(field, "port-end"), "port range %d-%d is invalid", svc.Port, svc.PortEnd)
+	}
+	if len(svc.Protocols) == 0 {
+		fe.Add(fieldPath(field, "protocols"), errs.NewErrRequired("protocols"))
+	}
+	for n, proto := range svc.Protocols {
+		if api.NetworkProtocolFromString(string(proto)) == api.NetworkProtocolUnknown {
+			fe.Addf(fieldPath(field, "protocols", n), "unknown protocol %q", proto)
+		}
+	}
+}
+
+func validateExtraData(fe *FieldErrors, field, name string, data api.ApplicationExtraData) {
+	if len(name) == 0 || strings.Contains(name, "/") {
+		fe.Addf(field, "invalid extra data name %q", name)
+	}
+	if len(data.Target) == 0 {
+		fe.Add(fieldPath(field, "target"), errs.NewErrRequired("target"))
+	} else if !path.IsAbs(data.Target) {
+		fe.Addf(fieldPath(field, "target"), "target %q must be an absolute path", data.Target)
+	}
+	if len(data.Owner) > 0 && !extraDataOwnerRegexp.MatchString(data.Owner) {
+		fe.Addf(fieldPath(field, "owner"), "owner %q must be given as user:group", data.Owner)
+	}
+	if len(data.Permissions) > 0 {
+		perm, err := strconv.ParseUint(data.Permissions, 8, 32)
+		if err != nil || perm > 0777 {
+			fe.Addf(fieldPath(field, "permissions"), "invalid permissions %q", data.Permissions)
+		}
+	}
+}
+
+// ApplicationPackage represents an application package which is either a
+// directory, a tarball or a zip archive
+type ApplicationPackage struct {
+	path     string
+	manifest *ApplicationManifest
+	files    *packageFiles
+}
+
+// NewApplicationPackage loads the application package at the given path
+func NewApplicationPackage(packagePath string) (*ApplicationPackage, error) {
+	files, err := readPackageFiles(packagePath, ManifestFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	content, ok := files.Content(ManifestFileName)
+	if !ok {
+		return nil, errs.NewErrNotFound(ManifestFileName)
+	}
+	manifest, err := ParseApplicationManifest(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ApplicationPackage{
+		path:     packagePath,
+		manifest: manifest,
+		files:    files,
+	}, nil
+}
+
+// Validate validates the manifest of the package and checks the package
+// provides all files the manifest refers to
+func (p *ApplicationPackage) Validate() error {
+	var fe FieldErrors
+	if err := p.manifest.Validate(); err != nil {
+		fe = append(fe, err.(FieldErrors)...)
+	}
+
+	if !p.files.Has(ApplicationAPKFileName) && len(p.manifest.BootPackage) == 0 {
+		fe.Addf("boot-package", "must be set when the package doesn't contain an %s", ApplicationAPKFileName)
+	}
+
+	for _, name := range p.manifest.extraDataNames() {
+		dataPath := path.Join(ExtraDataDirName, name)
+		if !p.files.Has(dataPath) {
+			fe.Add(fieldPath("extra-data", name), errs.NewErrNotFound(dataPath))
+		}
+	}
+
+	return fe.Err()
+}
+
+// Manifest returns the manifest structure of the package
+func (p *ApplicationPackage) Manifest() interface{} {
+	return p.manifest
+}
+
+// ApplicationManifest returns the typed manifest of the package
+func (p *ApplicationPackage) ApplicationManifest() *ApplicationManifest {
+	return p.manifest
+}
+
+// Path returns the path of the package
+func (p *ApplicationPackage) Path() string {
+	return p.path
+}
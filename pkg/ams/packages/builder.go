@@ -0,0 +1,450 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// DefaultBuildModTime is the modification time all entries of a built package
+// get unless another one is given. It is the earliest time a zip archive can
+// represent.
+var DefaultBuildModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// BuildArgs provides details on how to build a package from a directory
+type BuildArgs struct {
+	// Format of the package. Defaults to PackageFormatTarBZ2.
+	Format PackageFormat
+	// Include limits the package to paths matching one of the given glob
+	// patterns. All paths are included if empty.
+	Include []string
+	// Exclude lists glob patterns of paths left out of the package in
+	// addition to the ones listed in the .amsignore file of the directory
+	Exclude []string
+	// ModTime is the modification time set for all entries. Defaults to
+	// DefaultBuildModTime.
+	ModTime time.Time
+}
+
+// BuildResult describes a built package
+type BuildResult struct {
+	// Format of the package
+	Format PackageFormat
+	// Fingerprint is the SHA-256 checksum of the package
+	Fingerprint string
+	// Size of the package in bytes
+	Size int64
+	// Entries lists the paths of all entries in the package in the order
+	// they were written
+	Entries []string
+}
+
+type buildEntry struct {
+	name     string
+	fullPath string
+	mode     os.FileMode
+	size     int64
+}
+
+// BuildPackage streams the content of the given directory as a package into
+// w. The output only depends on the content of the included files and their
+// executable bit: entries are sorted, get a fixed modification time and are
+// owned by root. The fingerprint is computed while the package is written.
+func BuildPackage(w io.Writer, rootDir string, args *BuildArgs) (*BuildResult, error) {
+	if args == nil {
+		args = &BuildArgs{}
+	}
+	entries, err := collectBuildEntries(rootDir, args, "")
+	if err != nil {
+		return nil, err
+	}
+	return writePackage(w, entries, args)
+}
+
+// BuildPackageFile builds a package from the given directory in the same way
+// as BuildPackage and atomically writes it to outputPath
+func BuildPackageFile(rootDir, outputPath string, args *BuildArgs) (*BuildResult, error) {
+	if args == nil {
+		args = &BuildArgs{}
+	}
+	entries, err := collectBuildEntries(rootDir, args, outputPath)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := shared.NewAtomicFile(outputPath, 0644)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Cancel()
+
+	result, err := writePackage(f, entries, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Commit(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// collectBuildEntries lists all entries of the directory which go into the
+// package, sorted by their path. The file at skipPath is never included.
+func collectBuildEntries(rootDir string, args *BuildArgs, skipPath string) ([]buildEntry, error) {
+	fi, err := os.Stat(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, errs.NewInvalidArgument("rootDir")
+	}
+
+	ignored, err := readIgnoreFile(rootDir)
+	if err != nil {
+		return nil, err
+	}
+	exclude := newPathMatcher(append(ignored, args.Exclude...))
+	include := newPathMatcher(args.Include)
+
+	if len(skipPath) > 0 {
+		if skipPath, err = filepath.Abs(skipPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []buildEntry
+	dirs := map[string]buildEntry{}
+	neededDirs := map[string]bool{}
+
+	err = filepath.WalkDir(rootDir, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(rootDir, fullPath)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		isDir := d.IsDir()
+
+		if name == IgnoreFileName || exclude.Match(name, isDir) {
+			if isDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if abs, err := filepath.Abs(fullPath); err == nil && abs == skipPath {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := buildEntry{name: name, fullPath: fullPath, mode: info.Mode()}
+
+		switch {
+		case isDir:
+			dirs[name] = e
+			if len(include) == 0 || include.Match(name, true) {
+				neededDirs[name] = true
+			}
+			return nil
+		case info.Mode().IsRegular():
+			e.size = info.Size()
+		case info.Mode()&os.ModeSymlink != 0:
+		default:
+			// Devices, sockets and pipes can't be part of a package
+			return nil
+		}
+
+		if len(include) > 0 && !include.Match(name, false) {
+			return nil
+		}
+		entries = append(entries, e)
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			neededDirs[dir] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name := range neededDirs {
+		entries = append(entries, dirs[name])
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
+	return entries, nil
+}
+
+// normalizedMode drops all permission details except the executable bit
+func normalizedMode(mode os.FileMode) os.FileMode {
+	switch {
+	case mode.IsDir():
+		return os.ModeDir | 0755
+	case mode&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case mode.Perm()&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	n int64
+}
+
+// Write implements io.Writer interface
+func (c *countingWriter) Write(b []byte) (int, error) {
+	c.n += int64(len(b))
+	return len(b), nil
+}
+
+func writePackage(w io.Writer, entries []buildEntry, args *BuildArgs) (*BuildResult, error) {
+	modTime := args.ModTime
+	if modTime.IsZero() {
+		modTime = DefaultBuildModTime
+	}
+	modTime = modTime.UTC()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	out := io.MultiWriter(w, hasher, counter)
+
+	var err error
+	switch {
+	case args.Format == PackageFormatZip:
+		err = writeZipPackage(out, entries, modTime)
+	case args.Format.IsTarball():
+		err = writeTarPackage(out, args.Format, entries, modTime)
+	default:
+		err = errs.NewErrNotSupported(fmt.Sprintf("package format %s", args.Format))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BuildResult{
+		Format:      args.Format,
+		Fingerprint: fmt.Sprintf("%x", hasher.Sum(nil)),
+		Size:        counter.n,
+	}
+	for _, e := range entries {
+		result.Entries = append(result.Entries, e.name)
+	}
+	return result, nil
+}
+
+func writeTarPackage(w io.Writer, format PackageFormat, entries []buildEntry, modTime time.Time) error {
+	cw, err := compressor(format, w)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(cw)
+	for _, e := range entries {
+		if err := writeTarEntry(tw, e, modTime); err != nil {
+			cw.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, e buildEntry, modTime time.Time) error {
+	mode := normalizedMode(e.mode)
+	hdr := &tar.Header{
+		Name:    e.name,
+		Mode:    int64(mode.Perm()),
+		ModTime: modTime,
+		Format:  tar.FormatPAX,
+	}
+
+	switch {
+	case mode.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(e.fullPath)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = e.size
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	return copyFileContent(tw, e)
+}
+
+func writeZipPackage(w io.Writer, entries []buildEntry, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		if err := writeZipEntry(zw, e, modTime); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func writeZipEntry(zw *zip.Writer, e buildEntry, modTime time.Time) error {
+	mode := normalizedMode(e.mode)
+	hdr := &zip.FileHeader{
+		Name:     e.name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	hdr.SetMode(mode)
+	if mode.IsDir() {
+		hdr.Name += "/"
+		hdr.Method = zip.Store
+	}
+
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case mode.IsDir():
+		return nil
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(e.fullPath)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(fw, target)
+		return err
+	default:
+		return copyFileContent(fw, e)
+	}
+}
+
+// copyFileContent copies the content of the entry and ensures it didn't
+// change since the directory was scanned
+func copyFileContent(w io.Writer, e buildEntry) error {
+	f, err := os.Open(e.fullPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	n, err := io.Copy(w, io.LimitReader(f, e.size))
+	if err != nil {
+		return err
+	}
+	if n != e.size {
+		return fmt.Errorf("%s changed while the package was built", e.name)
+	}
+	return nil
+}
+
+// compressor returns a writer compressing everything written to it into w.
+// There is no bzip2, xz or zstd compressor in the standard library so the
+// respective command line tools are used.
+func compressor(format PackageFormat, w io.Writer) (io.WriteCloser, error) {
+	switch format {
+	case PackageFormatTarGZ:
+		// The zero header carries no name or modification time, which
+		// keeps the output stable
+		return gzip.NewWriterLevel(w, gzip.BestCompression)
+	case PackageFormatTarBZ2:
+		return newCommandWriter(w, "bzip2", "-c", "-9")
+	case PackageFormatTarXZ:
+		return newCommandWriter(w, "xz", "-c", "-T1")
+	case PackageFormatTarZstd:
+		return newCommandWriter(w, "zstd", "-c", "-q", "-T1")
+	default:
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("%s compression", format))
+	}
+}
+
+// commandWriter pipes everything written to it through an external command
+type commandWriter struct {
+	io.WriteCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+func newCommandWriter(w io.Writer, name string, args ...string) (*commandWriter, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("compression without the %s command", name))
+	}
+
+	c := &commandWriter{cmd: exec.Command(name, args...)}
+	c.cmd.Stdout = w
+	c.cmd.Stderr = &c.stderr
+
+	stdin, err := c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.WriteCloser = stdin
+
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close flushes all data through the command and waits for it to finish
+func (c *commandWriter) Close() error {
+	c.WriteCloser.Close()
+	if err := c.cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", c.cmd.Path, err, strings.TrimSpace(c.stderr.String()))
+	}
+	return nil
+}
@@ -22,7 +22,6 @@ import (
 	"bytes"
 	"fmt"
 	"io"
-	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -51,39 +50,41 @@ const (
 )
 
 // DetectPackageType is used to auto-determine the type of a package by looking
-// at the magic bytes of a file
+// at the magic bytes of a file. Use DetectPackageFormat to detect formats which
+// have no PackageType. A package whose content doesn't match its magic bytes,
+// like a corrupt tarball, is reported as PackageTypeUnknown without an error.
 func DetectPackageType(filePath string) (PackageType, error) {
-	file, err := os.Open(filePath)
-	if err != nil {
-		return PackageTypeUnknown, err
-	}
-	defer file.Close()
-
-	buff := make([]byte, 512)
-	if _, err = file.Read(buff); err != nil {
+	format, err := DetectPackageFormat(filePath)
+	if err != nil && !errs.IsErrInvalidFormat(err) {
 		return PackageTypeUnknown, err
 	}
-	mimeType := http.DetectContentType(buff)
-	switch mimeType {
-	case "application/zip":
-		return PackageTypeZip, nil
-		// TODO: Add the mimetype required to detect tarballs. This is currently not
-		// available in the standard functions in Go lib
-	default:
-		return PackageTypeUnknown, nil
-	}
-
+	return format.PackageType(), nil
 }
 
-// IsTarball detects if the given package is a valid tarball
+// IsTarball detects if the given package is a valid tarball in any of the
+// supported compression formats. See packageFormatOf.
 func IsTarball(filePath string) bool {
-	return strings.HasSuffix(strings.ToLower(filePath), ".tar.bz2") ||
-		strings.HasSuffix(strings.ToLower(filePath), ".tbz2")
+	return packageFormatOf(filePath).IsTarball()
 }
 
-// IsZip detects if the given package is a valid zip archive
+// IsZip detects if the given package is a valid zip archive. See
+// packageFormatOf.
 func IsZip(filePath string) bool {
-	return strings.HasSuffix(strings.ToLower(filePath), ".zip")
+	return packageFormatOf(filePath) == PackageFormatZip
+}
+
+// packageFormatOf determines the format of a package by its content. The
+// file extension is only used if the file can't be read or its content gives
+// no hint. Corrupt packages have no format.
+func packageFormatOf(filePath string) PackageFormat {
+	format, err := DetectPackageFormat(filePath)
+	if errs.IsErrInvalidFormat(err) {
+		return PackageFormatUnknown
+	}
+	if format == PackageFormatUnknown {
+		format = PackageFormatFromExtension(filePath)
+	}
+	return format
 }
 
 // ParseManifest parses the manifest structure from io reader
@@ -97,6 +98,16 @@ func ParseManifest(reader io.Reader, manifest interface{}) error {
 
 // CreateTempPackage creates a temporary package file with the given contents
 func CreateTempPackage(sources []string, packageType PackageType) (string, error) {
+	format := PackageFormatZip
+	if packageType == PackageTypeTarBZ2 {
+		format = PackageFormatTarBZ2
+	}
+	return CreateTempPackageWithFormat(sources, format)
+}
+
+// CreateTempPackageWithFormat creates a temporary package file in the given
+// format with the given contents
+func CreateTempPackageWithFormat(sources []string, format PackageFormat) (string, error) {
 	srcDir, err := os.Getwd()
 	if err != nil {
 		return "", err
@@ -145,13 +156,13 @@ func CreateTempPackage(sources []string, packageType PackageType) (string, error
 	}
 
 	var packagePath string
-	switch packageType {
-	case PackageTypeTarBZ2:
-		packagePath = filepath.Join(outputDir, "application.tar.bz2")
-		if err := shared.CreateBzip2Tarball(srcDir, packagePath, sources); err != nil {
+	switch {
+	case format.IsTarball():
+		packagePath = filepath.Join(outputDir, "application"+format.Extensions()[0])
+		if err := shared.CreateTarball(srcDir, packagePath, format.tarCompressionFlag(), sources); err != nil {
 			return "", fmt.Errorf("Failed to create tarball file")
 		}
-	case PackageTypeZip:
+	case format == PackageFormatZip:
 		fallthrough
 	default:
 		packagePath = filepath.Join(outputDir, "application.zip")
@@ -0,0 +1,335 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FileChangeType describes how a file changed between two packages
+type FileChangeType string
+
+const (
+	// FileAdded marks a file which only exists in the new package
+	FileAdded FileChangeType = "added"
+	// FileRemoved marks a file which only exists in the old package
+	FileRemoved FileChangeType = "removed"
+	// FileModified marks a file whose content or mode changed
+	FileModified FileChangeType = "modified"
+)
+
+// ManifestChange describes a changed field of the package manifest
+type ManifestChange struct {
+	// Field is the path of the field, e.g. resources.memory
+	Field string `json:"field" yaml:"field"`
+	// Old is the value in the old package or nil if the field was added
+	Old interface{} `json:"old,omitempty" yaml:"old,omitempty"`
+	// New is the value in the new package or nil if the field was removed
+	New interface{} `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// FileChange describes a file which differs between two packages
+type FileChange struct {
+	Path      string         `json:"path" yaml:"path"`
+	Change    FileChangeType `json:"change" yaml:"change"`
+	OldSize   int64          `json:"old_size" yaml:"old-size"`
+	NewSize   int64          `json:"new_size" yaml:"new-size"`
+	SizeDelta int64          `json:"size_delta" yaml:"size-delta"`
+}
+
+// APKChange describes how the APK of an application package changed
+type APKChange struct {
+	Old *APKInfo `json:"old,omitempty" yaml:"old,omitempty"`
+	New *APKInfo `json:"new,omitempty" yaml:"new,omitempty"`
+}
+
+// PackageDiff is a structured report of the differences between two
+// packages. It can be rendered as text with String or marshalled to JSON.
+type PackageDiff struct {
+	Manifest []ManifestChange `json:"manifest" yaml:"manifest"`
+	Files    []FileChange     `json:"files" yaml:"files"`
+	APK      *APKChange       `json:"apk,omitempty" yaml:"apk,omitempty"`
+}
+
+// Empty returns true if there are no differences between the packages
+func (d *PackageDiff) Empty() bool {
+	return len(d.Manifest) == 0 && len(d.Files) == 0 && d.APK == nil
+}
+
+// String renders the diff as human readable text
+func (d *PackageDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+
+	var b strings.Builder
+	if len(d.Manifest) > 0 {
+		b.WriteString("manifest:\n")
+		for _, c := range d.Manifest {
+			switch {
+			case c.Old == nil:
+				fmt.Fprintf(&b, "  + %s: %v\n", c.Field, formatDiffValue(c.New))
+			case c.New == nil:
+				fmt.Fprintf(&b, "  - %s: %v\n", c.Field, formatDiffValue(c.Old))
+			default:
+				fmt.Fprintf(&b, "  ~ %s: %v -> %v\n", c.Field, formatDiffValue(c.Old), formatDiffValue(c.New))
+			}
+		}
+	}
+	if d.APK != nil {
+		fmt.Fprintf(&b, "apk:\n  %s -> %s\n", d.APK.Old, d.APK.New)
+	}
+	if len(d.Files) > 0 {
+		b.WriteString("files:\n")
+		for _, f := range d.Files {
+			switch f.Change {
+			case FileAdded:
+				fmt.Fprintf(&b, "  + %s (%d bytes)\n", f.Path, f.NewSize)
+			case FileRemoved:
+				fmt.Fprintf(&b, "  - %s (%d bytes)\n", f.Path, f.OldSize)
+			default:
+				fmt.Fprintf(&b, "  ~ %s (%+d bytes)\n", f.Path, f.SizeDelta)
+			}
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// String returns the identity of the APK as a single line
+func (i *APKInfo) String() string {
+	if i == nil {
+		return "none"
+	}
+	s := fmt.Sprintf("%s (version code %d", i.PackageName, i.VersionCode)
+	if len(i.VersionName) > 0 {
+		s += ", version " + i.VersionName
+	}
+	return s + ")"
+}
+
+func formatDiffValue(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}, []interface{}:
+		b, err := json.Marshal(v)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// DiffPackages compares two packages, each being a directory, a tarball or a
+// zip archive. Changes to the manifest are reported per field, changes to
+// files by path and for application packages changes to the identity of the
+// APK are reported as well.
+func DiffPackages(oldPath, newPath string) (*PackageDiff, error) {
+	opts := packageReadOptions{
+		load:    []string{ManifestFileName},
+		hash:    true,
+		extract: []string{ApplicationAPKFileName},
+	}
+	oldFiles, err := readPackageFilesWithOptions(oldPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer oldFiles.Close()
+	newFiles, err := readPackageFilesWithOptions(newPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer newFiles.Close()
+
+	d := &PackageDiff{
+		Manifest: []ManifestChange{},
+		Files:    diffFiles(oldFiles, newFiles),
+	}
+
+	oldManifest, err := genericManifest(oldFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %v", oldPath, err)
+	}
+	newManifest, err := genericManifest(newFiles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest of %s: %v", newPath, err)
+	}
+	diffValues(&d.Manifest, "", oldManifest, newManifest)
+
+	d.APK, err = diffAPKs(oldFiles, newFiles)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func diffFiles(oldFiles, newFiles *packageFiles) []FileChange {
+	changes := []FileChange{}
+	for name, o := range oldFiles.entries {
+		if o.Mode.IsDir() {
+			continue
+		}
+		n, ok := newFiles.entries[name]
+		switch {
+		case !ok || n.Mode.IsDir():
+			changes = append(changes, FileChange{Path: name, Change: FileRemoved, OldSize: o.Size, SizeDelta: -o.Size})
+		case o.Fingerprint != n.Fingerprint || o.Mode != n.Mode:
+			changes = append(changes, FileChange{Path: name, Change: FileModified, OldSize: o.Size, NewSize: n.Size, SizeDelta: n.Size - o.Size})
+		}
+	}
+	for name, n := range newFiles.entries {
+		if n.Mode.IsDir() {
+			continue
+		}
+		if o, ok := oldFiles.entries[name]; !ok || o.Mode.IsDir() {
+			changes = append(changes, FileChange{Path: name, Change: FileAdded, NewSize: n.Size, SizeDelta: n.Size})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+// genericManifest parses the manifest of a package into plain maps and lists
+// so that manifests of any kind can be compared field by field
+func genericManifest(files *packageFiles) (interface{}, error) {
+	content, ok := files.Content(ManifestFileName)
+	if !ok {
+		return nil, nil
+	}
+	var m interface{}
+	if err := ParseManifest(bytes.NewReader(content), &m); err != nil {
+		return nil, err
+	}
+	return normalizeYAMLValue(m), nil
+}
+
+// normalizeYAMLValue converts the maps yaml.v2 produces into maps with string
+// keys which can be marshalled to JSON
+func normalizeYAMLValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, v := range value {
+			m[fmt.Sprintf("%v", k)] = normalizeYAMLValue(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(value))
+		for n, v := range value {
+			l[n] = normalizeYAMLValue(v)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+// diffValues records all differences between two manifest values. Maps are
+// compared key by key, lists of maps element by element and all other
+// values as a whole.
+func diffValues(changes *[]ManifestChange, field string, oldValue, newValue interface{}) {
+	if reflect.DeepEqual(oldValue, newValue) {
+		return
+	}
+
+	oldMap, oldIsMap := oldValue.(map[string]interface{})
+	newMap, newIsMap := newValue.(map[string]interface{})
+	if oldIsMap && newIsMap || oldIsMap && newValue == nil || newIsMap && oldValue == nil {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValues(changes, fieldPath(field, k), oldMap[k], newMap[k])
+		}
+		return
+	}
+
+	oldList, oldIsList := oldValue.([]interface{})
+	newList, newIsList := newValue.([]interface{})
+	if oldIsList && newIsList && isListOfMaps(oldList) && isListOfMaps(newList) {
+		for n := 0; n < len(oldList) || n < len(newList); n++ {
+			var o, v interface{}
+			if n < len(oldList) {
+				o = oldList[n]
+			}
+			if n < len(newList) {
+				v = newList[n]
+			}
+			if o == nil || v == nil {
+				*changes = append(*changes, ManifestChange{Field: fieldPath(field, n), Old: o, New: v})
+				continue
+			}
+			diffValues(changes, fieldPath(field, n), o, v)
+		}
+		return
+	}
+
+	*changes = append(*changes, ManifestChange{Field: field, Old: oldValue, New: newValue})
+}
+
+func isListOfMaps(l []interface{}) bool {
+	for _, v := range l {
+		if _, ok := v.(map[string]interface{}); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func diffAPKs(oldFiles, newFiles *packageFiles) (*APKChange, error) {
+	oldEntry, hasOld := oldFiles.Entry(ApplicationAPKFileName)
+	newEntry, hasNew := newFiles.Entry(ApplicationAPKFileName)
+	if !hasOld && !hasNew || hasOld && hasNew && oldEntry.Fingerprint == newEntry.Fingerprint {
+		return nil, nil
+	}
+
+	read := func(files *packageFiles) (*APKInfo, error) {
+		apkPath, ok := files.Extracted(ApplicationAPKFileName)
+		if !ok {
+			return nil, nil
+		}
+		return ReadAPKInfo(apkPath)
+	}
+	oldInfo, err := read(oldFiles)
+	if err != nil {
+		return nil, err
+	}
+	newInfo, err := read(newFiles)
+	if err != nil {
+		return nil, err
+	}
+	if oldInfo != nil && newInfo != nil && *oldInfo == *newInfo {
+		return nil, nil
+	}
+	return &APKChange{Old: oldInfo, New: newInfo}, nil
+}
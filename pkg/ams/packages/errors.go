@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldError describes a problem with a single field of a package manifest
+type FieldError struct {
+	// Path of the field in the manifest, e.g. resources.memory
+	Path string
+	// Err describes what is wrong with the field
+	Err error
+}
+
+// Error returns the error string
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e FieldError) Unwrap() error {
+	return e.Err
+}
+
+// FieldErrors collects all problems found while validating a package
+type FieldErrors []FieldError
+
+// Error returns the error string
+func (e FieldErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fe := range e {
+		msgs = append(msgs, fe.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Add records a new problem for the field at the given path
+func (e *FieldErrors) Add(path string, err error) {
+	*e = append(*e, FieldError{Path: path, Err: err})
+}
+
+// Addf records a new problem for the field at the given path with a
+// formatted message
+func (e *FieldErrors) Addf(path, format string, args ...interface{}) {
+	e.Add(path, fmt.Errorf(format, args...))
+}
+
+// Err returns nil if no problems were recorded and the collection otherwise
+func (e FieldErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// fieldPath joins the elements of a field path
+func fieldPath(elems ...interface{}) string {
+	var b strings.Builder
+	for _, elem := range elems {
+		switch v := elem.(type) {
+		case int:
+			fmt.Fprintf(&b, "[%d]", v)
+		default:
+			if b.Len() > 0 {
+				b.WriteString(".")
+			}
+			fmt.Fprintf(&b, "%v", v)
+		}
+	}
+	return b.String()
+}
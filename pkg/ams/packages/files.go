@@ -0,0 +1,333 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// maxManifestSize limits the size of manifest files read from a package
+const maxManifestSize = 1024 * 1024
+
+// packageFile describes a single entry of a package
+type packageFile struct {
+	Mode os.FileMode
+	Size int64
+	// Fingerprint is the SHA-256 checksum of the content. It is only set
+	// for regular files if requested when reading the package.
+	Fingerprint string
+}
+
+// packageReadOptions controls what is read from a package besides the list
+// of its entries
+type packageReadOptions struct {
+	// load lists small files whose content is kept in memory
+	load []string
+	// hash requests a fingerprint of every regular file
+	hash bool
+	// extract lists files which are copied to a temporary directory
+	extract []string
+}
+
+// packageFiles holds the list of entries of a package together with the
+// content of selected small files like the manifest
+type packageFiles struct {
+	entries   map[string]packageFile
+	contents  map[string][]byte
+	extracted map[string]string
+	tempDir   string
+
+	load    map[string]bool
+	extract map[string]bool
+	hash    bool
+}
+
+// Has checks if the package contains an entry with the given name
+func (p *packageFiles) Has(name string) bool {
+	_, ok := p.entries[cleanEntryName(name)]
+	return ok
+}
+
+// Entry returns the entry with the given name
+func (p *packageFiles) Entry(name string) (packageFile, bool) {
+	e, ok := p.entries[cleanEntryName(name)]
+	return e, ok
+}
+
+// HasPrefix checks if the package contains any entry below the given directory
+func (p *packageFiles) HasPrefix(dir string) bool {
+	dir = cleanEntryName(dir) + "/"
+	for name := range p.entries {
+		if strings.HasPrefix(name, dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Content returns the content of a file which was loaded with the package
+func (p *packageFiles) Content(name string) ([]byte, bool) {
+	c, ok := p.contents[cleanEntryName(name)]
+	return c, ok
+}
+
+// Extracted returns the path of a file which was extracted from the package
+func (p *packageFiles) Extracted(name string) (string, bool) {
+	path, ok := p.extracted[cleanEntryName(name)]
+	return path, ok
+}
+
+// Close removes all files extracted from the package
+func (p *packageFiles) Close() error {
+	if len(p.tempDir) == 0 {
+		return nil
+	}
+	return os.RemoveAll(p.tempDir)
+}
+
+func cleanEntryName(name string) string {
+	name = path.Clean("/" + filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "/")
+}
+
+// readPackageFiles lists all entries of the package at the given path which
+// can be a directory, a tarball or a zip archive. The content of all files
+// listed in load is kept in memory.
+func readPackageFiles(packagePath string, load ...string) (*packageFiles, error) {
+	return readPackageFilesWithOptions(packagePath, packageReadOptions{load: load})
+}
+
+// readPackageFilesWithOptions lists all entries of the package like
+// readPackageFiles does and additionally hashes or extracts files as
+// requested. The caller must call Close once extracted files are no longer
+// needed.
+func readPackageFilesWithOptions(packagePath string, opts packageReadOptions) (*packageFiles, error) {
+	fi, err := os.Stat(packagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &packageFiles{
+		entries:   map[string]packageFile{},
+		contents:  map[string][]byte{},
+		extracted: map[string]string{},
+		load:      map[string]bool{},
+		extract:   map[string]bool{},
+		hash:      opts.hash,
+	}
+	for _, name := range opts.load {
+		p.load[cleanEntryName(name)] = true
+	}
+	for _, name := range opts.extract {
+		p.extract[cleanEntryName(name)] = true
+	}
+
+	if fi.IsDir() {
+		err = p.readDir(packagePath)
+	} else {
+		var format PackageFormat
+		format, err = DetectPackageFormat(packagePath)
+		if err != nil {
+			return nil, err
+		}
+		if format == PackageFormatUnknown {
+			format = PackageFormatFromExtension(packagePath)
+		}
+		switch format {
+		case PackageFormatZip:
+			err = p.readZip(packagePath)
+		case PackageFormatUnknown:
+			err = errs.NewErrInvalidFormat(fmt.Sprintf("package %s: unknown package format", packagePath))
+		default:
+			err = p.readTarball(packagePath, format)
+		}
+	}
+	if err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// wants returns true if the content of the entry is needed
+func (p *packageFiles) wants(name string) bool {
+	name = cleanEntryName(name)
+	return p.hash || p.load[name] || p.extract[name]
+}
+
+func (p *packageFiles) add(name string, mode os.FileMode, size int64, r io.Reader) error {
+	name = cleanEntryName(name)
+	if len(name) == 0 {
+		return nil
+	}
+	entry := packageFile{Mode: mode, Size: size}
+	if r == nil || !mode.IsRegular() {
+		p.entries[name] = entry
+		return nil
+	}
+
+	var writers []io.Writer
+	hasher := sha256.New()
+	if p.hash {
+		writers = append(writers, hasher)
+	}
+
+	var content *bytes.Buffer
+	if p.load[name] {
+		if size > maxManifestSize {
+			return fmt.Errorf("%s exceeds the maximum size of %d bytes", name, maxManifestSize)
+		}
+		content = &bytes.Buffer{}
+		writers = append(writers, content)
+	}
+
+	var extracted *os.File
+	if p.extract[name] {
+		if len(p.tempDir) == 0 {
+			dir, err := os.MkdirTemp("", "ams-package")
+			if err != nil {
+				return err
+			}
+			p.tempDir = dir
+		}
+		f, err := os.CreateTemp(p.tempDir, path.Base(name))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		extracted = f
+		writers = append(writers, f)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return err
+	}
+
+	if p.hash {
+		entry.Fingerprint = fmt.Sprintf("%x", hasher.Sum(nil))
+	}
+	if content != nil {
+		p.contents[name] = content.Bytes()
+	}
+	if extracted != nil {
+		p.extracted[name] = extracted.Name()
+	}
+	p.entries[name] = entry
+	return nil
+}
+
+func (p *packageFiles) readDir(root string) error {
+	return filepath.Walk(root, func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, filePath)
+		if err != nil || name == "." {
+			return err
+		}
+		if !fi.Mode().IsRegular() || !p.wants(name) {
+			return p.add(name, fi.Mode(), fi.Size(), nil)
+		}
+		if p.extract[cleanEntryName(name)] && !p.hash && !p.load[cleanEntryName(name)] {
+			// Files of a directory can be used in place
+			p.extracted[cleanEntryName(name)] = filePath
+			return p.add(name, fi.Mode(), fi.Size(), nil)
+		}
+
+		f, err := os.Open(filePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return p.add(name, fi.Mode(), fi.Size(), f)
+	})
+}
+
+func (p *packageFiles) readTarball(packagePath string, format PackageFormat) error {
+	f, err := os.Open(packagePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := decompressor(format, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errs.NewErrInvalidFormat(fmt.Sprintf("package %s: %v", packagePath, err))
+		}
+		var content io.Reader
+		if p.wants(hdr.Name) {
+			content = tr
+		}
+		if err := p.add(hdr.Name, hdr.FileInfo().Mode(), hdr.Size, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *packageFiles) readZip(packagePath string) error {
+	zr, err := zip.OpenReader(packagePath)
+	if err != nil {
+		return errs.NewErrInvalidFormat(fmt.Sprintf("package %s: %v", packagePath, err))
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		var r io.ReadCloser
+		if p.wants(zf.Name) && zf.Mode().IsRegular() {
+			r, err = zf.Open()
+			if err != nil {
+				return err
+			}
+		}
+		var content io.Reader
+		if r != nil {
+			content = r
+		}
+		err := p.add(zf.Name, zf.Mode(), int64(zf.UncompressedSize64), content)
+		if r != nil {
+			r.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// PackageFormat describes the archive format and compression of a package
+// in more detail than PackageType does
+type PackageFormat int
+
+const (
+	// PackageFormatUnknown represents a package in an unknown format
+	PackageFormatUnknown PackageFormat = iota - 1
+	// PackageFormatTarBZ2 represents a bzip2 compressed tarball
+	PackageFormatTarBZ2
+	// PackageFormatZip represents a zip archive
+	PackageFormatZip
+	// PackageFormatTarGZ represents a gzip compressed tarball
+	PackageFormatTarGZ
+	// PackageFormatTarXZ represents a xz compressed tarball
+	PackageFormatTarXZ
+	// PackageFormatTarZstd represents a zstd compressed tarball
+	PackageFormatTarZstd
+)
+
+// magicHeaderSize is the number of bytes needed to detect a package format
+const magicHeaderSize = 512
+
+var packageFormatMagics = []struct {
+	format PackageFormat
+	magic  []byte
+}{
+	{PackageFormatTarBZ2, []byte("BZh")},
+	{PackageFormatTarGZ, []byte{0x1f, 0x8b}},
+	{PackageFormatTarXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{PackageFormatTarZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{PackageFormatZip, []byte("PK\x03\x04")},
+	// An empty zip archive only consists of the end of central directory record
+	{PackageFormatZip, []byte("PK\x05\x06")},
+}
+
+// String returns the name of the format
+func (f PackageFormat) String() string {
+	switch f {
+	case PackageFormatTarBZ2:
+		return "tar.bz2"
+	case PackageFormatZip:
+		return "zip"
+	case PackageFormatTarGZ:
+		return "tar.gz"
+	case PackageFormatTarXZ:
+		return "tar.xz"
+	case PackageFormatTarZstd:
+		return "tar.zst"
+	default:
+		return "unknown"
+	}
+}
+
+// Extensions returns the file extensions commonly used for the format
+func (f PackageFormat) Extensions() []string {
+	switch f {
+	case PackageFormatTarBZ2:
+		return []string{".tar.bz2", ".tbz2"}
+	case PackageFormatZip:
+		return []string{".zip"}
+	case PackageFormatTarGZ:
+		return []string{".tar.gz", ".tgz"}
+	case PackageFormatTarXZ:
+		return []string{".tar.xz", ".txz"}
+	case PackageFormatTarZstd:
+		return []string{".tar.zst", ".tzst"}
+	default:
+		return nil
+	}
+}
+
+// IsTarball returns true if the format is a compressed tarball
+func (f PackageFormat) IsTarball() bool {
+	switch f {
+	case PackageFormatTarBZ2, PackageFormatTarGZ, PackageFormatTarXZ, PackageFormatTarZstd:
+		return true
+	default:
+		return false
+	}
+}
+
+// PackageType returns the PackageType corresponding to the format or
+// PackageTypeUnknown if there is none
+func (f PackageFormat) PackageType() PackageType {
+	switch f {
+	case PackageFormatTarBZ2:
+		return PackageTypeTarBZ2
+	case PackageFormatZip:
+		return PackageTypeZip
+	default:
+		return PackageTypeUnknown
+	}
+}
+
+// RequiredExtension returns the API extension the AMS service must support
+// to accept packages in this format. An empty string is returned for formats
+// which AMS doesn't gate behind an extension, which includes all tarballs.
+func (f PackageFormat) RequiredExtension() string {
+	switch f {
+	case PackageFormatZip:
+		return "zip_archive_support"
+	default:
+		return ""
+	}
+}
+
+// tarCompressionFlag returns the flag tar needs to create an archive in
+// this format
+func (f PackageFormat) tarCompressionFlag() string {
+	switch f {
+	case PackageFormatTarBZ2:
+		return "--bzip2"
+	case PackageFormatTarGZ:
+		return "--gzip"
+	case PackageFormatTarXZ:
+		return "--xz"
+	case PackageFormatTarZstd:
+		return "--zstd"
+	default:
+		return ""
+	}
+}
+
+// PackageFormats lists all known package formats
+var PackageFormats = []PackageFormat{
+	PackageFormatTarBZ2,
+	PackageFormatZip,
+	PackageFormatTarGZ,
+	PackageFormatTarXZ,
+	PackageFormatTarZstd,
+}
+
+// PackageFormatFromExtension determines the format of a package from the
+// extension of its file name
+func PackageFormatFromExtension(filePath string) PackageFormat {
+	name := strings.ToLower(filePath)
+	for _, f := range PackageFormats {
+		for _, ext := range f.Extensions() {
+			if strings.HasSuffix(name, ext) {
+				return f
+			}
+		}
+	}
+	return PackageFormatUnknown
+}
+
+// DetectPackageFormatFromHeader determines the format of a package from the
+// magic bytes at its beginning. Unlike DetectPackageFormat it doesn't verify
+// compressed data contains a tarball.
+func DetectPackageFormatFromHeader(head []byte) PackageFormat {
+	for _, m := range packageFormatMagics {
+		if bytes.HasPrefix(head, m.magic) {
+			return m.format
+		}
+	}
+	return PackageFormatUnknown
+}
+
+// DetectPackageFormat determines the format of the package at the given path
+// by its magic bytes. For tarballs the first tar header is read as well to
+// make sure the compressed data is a tarball. xz and zstd are decompressed
+// with the xz and zstd commands; if these aren't installed only the magic
+// bytes are checked.
+func DetectPackageFormat(filePath string) (PackageFormat, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return PackageFormatUnknown, err
+	}
+	defer f.Close()
+
+	head := make([]byte, magicHeaderSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return PackageFormatUnknown, err
+	}
+
+	format := DetectPackageFormatFromHeader(head[:n])
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return PackageFormatUnknown, err
+	}
+	if err := validateTarball(format, f); err != nil && !errs.IsErrNotSupported(err) {
+		return PackageFormatUnknown, errs.NewErrInvalidFormat(fmt.Sprintf("package %s: %v", filePath, err))
+	}
+	return format, nil
+}
+
+// decompressor returns a reader providing the decompressed content of r
+func decompressor(format PackageFormat, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case PackageFormatTarBZ2:
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case PackageFormatTarGZ:
+		return gzip.NewReader(r)
+	case PackageFormatTarXZ:
+		return newCommandReader("xz", r)
+	case PackageFormatTarZstd:
+		return newCommandReader("zstd", r)
+	default:
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("%s decompression", format))
+	}
+}
+
+// commandReader decompresses data with an external command as the standard
+// library has no xz or zstd decompressor
+type commandReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	done   bool
+}
+
+func newCommandReader(name string, r io.Reader) (*commandReader, error) {
+	if _, err := exec.LookPath(name); err != nil {
+		return nil, errs.NewErrNotSupported(fmt.Sprintf("decompression without the %s command", name))
+	}
+	c := &commandReader{cmd: exec.Command(name, "--decompress", "--stdout")}
+	c.cmd.Stdin = r
+	c.cmd.Stderr = &c.stderr
+	stdout, err := c.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.ReadCloser = stdout
+	if err := c.cmd.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Read reports a failure of the command once all output was read
+func (c *commandReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err == io.EOF && !c.done {
+		c.done = true
+		if werr := c.cmd.Wait(); werr != nil {
+			return n, fmt.Errorf("%s: %v: %s", c.cmd.Path, werr, strings.TrimSpace(c.stderr.String()))
+		}
+	}
+	return n, err
+}
+
+// Close stops the command if the output wasn't read completely
+func (c *commandReader) Close() error {
+	if c.done {
+		return nil
+	}
+	c.done = true
+	c.ReadCloser.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+// validateTarball checks that the compressed data in r starts with a valid
+// tar header if the format is a tarball. If the data can't be decompressed
+// on this system ErrNotSupported is returned.
+func validateTarball(format PackageFormat, r io.Reader) error {
+	if !format.IsTarball() {
+		return nil
+	}
+	dr, err := decompressor(format, r)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	_, err = tar.NewReader(dr).Next()
+	if err == io.EOF {
+		return fmt.Errorf("%s archive is empty", format)
+	}
+	return err
+}
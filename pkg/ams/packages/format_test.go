@@ -0,0 +1,333 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// tarball returns an uncompressed tarball with a single manifest
+func tarball(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("name: app\n")
+	if err := tw.WriteHeader(&tar.Header{Name: ManifestFileName, Mode: 0644, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(ManifestFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("name: app\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// compress compresses data in the given format. Formats the standard library
+// can't write are compressed with the respective command.
+func compress(t *testing.T, format PackageFormat, data []byte) []byte {
+	t.Helper()
+	if format == PackageFormatTarGZ {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		if _, err := gw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := gw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	name := map[PackageFormat]string{
+		PackageFormatTarBZ2:  "bzip2",
+		PackageFormatTarXZ:   "xz",
+		PackageFormatTarZstd: "zstd",
+	}[format]
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+	cmd := exec.Command(name, "--compress", "--stdout")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDetectPackageFormatFromHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   []byte
+		format PackageFormat
+	}{
+		{"bzip2", []byte("BZh91AY&SY"), PackageFormatTarBZ2},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00}, PackageFormatTarGZ},
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00}, PackageFormatTarXZ},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x04}, PackageFormatTarZstd},
+		{"zip", []byte("PK\x03\x04\x14\x00"), PackageFormatZip},
+		{"empty zip", []byte("PK\x05\x06\x00\x00"), PackageFormatZip},
+		{"truncated magic", []byte{0xfd, '7', 'z'}, PackageFormatUnknown},
+		{"text", []byte("name: app\n"), PackageFormatUnknown},
+		{"empty", nil, PackageFormatUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if format := DetectPackageFormatFromHeader(test.head); format != test.format {
+				t.Errorf("expected %s, got %s", test.format, format)
+			}
+		})
+	}
+}
+
+func TestDetectPackageFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    func(t *testing.T) []byte
+		format  PackageFormat
+		invalid bool
+	}{
+		{
+			name:   "tar.bz2",
+			file:   "app.tar.bz2",
+			data:   func(t *testing.T) []byte { return compress(t, PackageFormatTarBZ2, tarball(t)) },
+			format: PackageFormatTarBZ2,
+		},
+		{
+			name:   "tar.gz",
+			file:   "app.tar.gz",
+			data:   func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, tarball(t)) },
+			format: PackageFormatTarGZ,
+		},
+		{
+			name:   "tar.xz",
+			file:   "app.tar.xz",
+			data:   func(t *testing.T) []byte { return compress(t, PackageFormatTarXZ, tarball(t)) },
+			format: PackageFormatTarXZ,
+		},
+		{
+			name:   "tar.zst",
+			file:   "app.tar.zst",
+			data:   func(t *testing.T) []byte { return compress(t, PackageFormatTarZstd, tarball(t)) },
+			format: PackageFormatTarZstd,
+		},
+		{
+			name:   "zip",
+			file:   "app.zip",
+			data:   zipArchive,
+			format: PackageFormatZip,
+		},
+		{
+			name:   "content wins over extension",
+			file:   "app.zip",
+			data:   func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, tarball(t)) },
+			format: PackageFormatTarGZ,
+		},
+		{
+			name:   "unknown content",
+			file:   "app.tar.gz",
+			data:   func(t *testing.T) []byte { return []byte("name: app\n") },
+			format: PackageFormatUnknown,
+		},
+		{
+			name:    "compressed data is no tarball",
+			file:    "app.tar.gz",
+			data:    func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, []byte("name: app\n")) },
+			invalid: true,
+		},
+		{
+			name:    "empty tarball",
+			file:    "app.tar.gz",
+			data:    func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, make([]byte, 1024)) },
+			invalid: true,
+		},
+		{
+			name:    "truncated compressed data",
+			file:    "app.tar.gz",
+			data:    func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, tarball(t))[:12] },
+			invalid: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := writeFile(t, test.file, test.data(t))
+			format, err := DetectPackageFormat(p)
+			if test.invalid {
+				if !errs.IsErrInvalidFormat(err) {
+					t.Fatalf("expected an invalid format error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format != test.format {
+				t.Errorf("expected %s, got %s", test.format, format)
+			}
+		})
+	}
+}
+
+func TestDetectPackageType(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  func(t *testing.T) []byte
+		typ   PackageType
+		isErr bool
+	}{
+		{
+			name: "tar.bz2",
+			data: func(t *testing.T) []byte { return compress(t, PackageFormatTarBZ2, tarball(t)) },
+			typ:  PackageTypeTarBZ2,
+		},
+		{
+			name: "zip",
+			data: zipArchive,
+			typ:  PackageTypeZip,
+		},
+		{
+			// Formats without a PackageType are unknown to callers of
+			// DetectPackageType
+			name: "tar.gz",
+			data: func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, tarball(t)) },
+			typ:  PackageTypeUnknown,
+		},
+		{
+			name: "corrupt tarball",
+			data: func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, []byte("name: app\n")) },
+			typ:  PackageTypeUnknown,
+		},
+		{
+			name:  "missing file",
+			isErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "package")
+			if test.data != nil {
+				p = writeFile(t, "package", test.data(t))
+			}
+			typ, err := DetectPackageType(p)
+			if test.isErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != test.typ {
+				t.Errorf("expected type %d, got %d", test.typ, typ)
+			}
+		})
+	}
+}
+
+func TestIsTarball(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    func(t *testing.T) []byte
+		tarball bool
+		zip     bool
+	}{
+		{
+			name:    "tar.xz",
+			file:    "app.tar.xz",
+			data:    func(t *testing.T) []byte { return compress(t, PackageFormatTarXZ, tarball(t)) },
+			tarball: true,
+		},
+		{
+			name:    "tarball with zip extension",
+			file:    "app.zip",
+			data:    func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, tarball(t)) },
+			tarball: true,
+		},
+		{
+			name: "zip with tarball extension",
+			file: "app.tar.bz2",
+			data: zipArchive,
+			zip:  true,
+		},
+		{
+			name: "corrupt tarball",
+			file: "app.tar.gz",
+			data: func(t *testing.T) []byte { return compress(t, PackageFormatTarGZ, []byte("name: app\n")) },
+		},
+		{
+			name:    "missing file",
+			file:    "app.tbz2",
+			tarball: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), test.file)
+			if test.data != nil {
+				p = writeFile(t, test.file, test.data(t))
+			}
+			if IsTarball(p) != test.tarball {
+				t.Errorf("expected IsTarball to return %v", test.tarball)
+			}
+			if IsZip(p) != test.zip {
+				t.Errorf("expected IsZip to return %v", test.zip)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package packages
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName is the name of the file listing paths which are left out
+// when a package is built from a directory
+const IgnoreFileName = ".amsignore"
+
+// pathPattern is a single glob pattern matched against slash separated
+// paths relative to the package root
+type pathPattern struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+	// anchored patterns contain a slash and are matched against the full
+	// path, all others against every path element
+	anchored bool
+}
+
+// pathMatcher decides whether a path is matched by a list of patterns. Like
+// with .gitignore the last matching pattern wins and a match on a directory
+// applies to everything below it.
+type pathMatcher []pathPattern
+
+func newPathMatcher(patterns []string) pathMatcher {
+	m := pathMatcher{}
+	for _, p := range patterns {
+		p = strings.TrimSpace(p)
+		if len(p) == 0 || strings.HasPrefix(p, "#") {
+			continue
+		}
+		pp := pathPattern{}
+		if strings.HasPrefix(p, "!") {
+			pp.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") {
+			pp.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if strings.Contains(p, "/") {
+			pp.anchored = true
+			p = strings.TrimPrefix(p, "/")
+		}
+		if len(p) == 0 {
+			continue
+		}
+		pp.glob = p
+		m = append(m, pp)
+	}
+	return m
+}
+
+// readIgnoreFile reads the patterns of the ignore file in the given
+// directory. A missing file yields no patterns.
+func readIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		patterns = append(patterns, s.Text())
+	}
+	return patterns, s.Err()
+}
+
+func (pp pathPattern) matches(name string, isDir bool) bool {
+	if pp.dirOnly && !isDir {
+		return false
+	}
+	if pp.anchored {
+		ok, _ := path.Match(pp.glob, name)
+		return ok
+	}
+	ok, _ := path.Match(pp.glob, path.Base(name))
+	return ok
+}
+
+// Match returns true if the path or one of its parent directories is matched
+func (m pathMatcher) Match(name string, isDir bool) bool {
+	matched := false
+	parts := strings.Split(name, "/")
+	for n := range parts {
+		prefix := strings.Join(parts[:n+1], "/")
+		prefixIsDir := isDir || n < len(parts)-1
+		for _, pp := range m {
+			if pp.matches(prefix, prefixIsDir) {
+				matched = !pp.negate
+			}
+		}
+	}
+	return matched
+}
@@ -0,0 +1,246 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package schema
+
+import (
+	"fmt"
+	"sort"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/constants"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// Names of the schema documents the SDK provides
+const (
+	ApplicationManifest = "application-manifest"
+	AddonManifest       = "addon-manifest"
+	InstancesPost       = "instances-post"
+	ApplicationPatch    = "application-patch"
+	NodesPost           = "nodes-post"
+	NodePatch           = "node-patch"
+	AuthGroupPost       = "auth-group-post"
+)
+
+// idPrefix is the prefix of the $id of all documents
+const idPrefix = "https://anbox-cloud.io/schemas/"
+
+type document struct {
+	title  string
+	value  interface{}
+	opts   GenerateOptions
+	refine func(s *Schema)
+}
+
+var documents = map[string]document{
+	ApplicationManifest: {
+		title:  "AMS application manifest",
+		value:  packages.ApplicationManifest{},
+		opts:   GenerateOptions{Tag: "yaml", Strict: true},
+		refine: refineApplicationManifest,
+	},
+	AddonManifest: {
+		title:  "AMS addon manifest",
+		value:  packages.AddonManifest{},
+		opts:   GenerateOptions{Tag: "yaml", Strict: true},
+		refine: refineAddonManifest,
+	},
+	InstancesPost: {
+		title: "AMS instance creation request",
+		value: api.InstancesPost{},
+		opts:  GenerateOptions{Tag: "json"},
+		refine: func(s *Schema) {
+			refineNetworkServices(s)
+		},
+	},
+	ApplicationPatch: {
+		title: "AMS application update request",
+		value: api.ApplicationPatch{},
+		opts:  GenerateOptions{Tag: "json"},
+		refine: func(s *Schema) {
+			setPattern(property(s, "instance-type"), constants.InstanceTypePattern)
+			setEnum(property(s, "video_encoder"), videoEncoders()...)
+			refineNetworkServices(s)
+		},
+	},
+	NodesPost: {
+		title: "AMS node creation request",
+		value: api.NodesPost{},
+		opts:  GenerateOptions{Tag: "json"},
+		refine: func(s *Schema) {
+			s.Required = []string{"name", "address"}
+		},
+	},
+	NodePatch: {
+		title: "AMS node update request",
+		value: api.NodePatch{},
+		opts:  GenerateOptions{Tag: "json"},
+	},
+	AuthGroupPost: {
+		title: "AMS authorization group creation request",
+		value: api.AuthGroupPost{},
+		opts:  GenerateOptions{Tag: "json"},
+		refine: func(s *Schema) {
+			s.Required = []string{"name"}
+		},
+	},
+}
+
+// Names returns the names of all schema documents the SDK provides
+func Names() []string {
+	names := make([]string, 0, len(documents))
+	for name := range documents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns the schema document with the given name
+func Get(name string) (*Schema, error) {
+	doc, ok := documents[name]
+	if !ok {
+		return nil, errs.NewErrNotFound(fmt.Sprintf("schema %q", name))
+	}
+	s := Generate(doc.value, doc.opts)
+	s.ID = idPrefix + name + ".json"
+	s.Title = doc.title
+	if doc.refine != nil {
+		doc.refine(s)
+	}
+	return s, nil
+}
+
+// Validate checks a YAML or JSON document against the schema with the given
+// name
+func Validate(name string, data []byte) error {
+	s, err := Get(name)
+	if err != nil {
+		return err
+	}
+	return s.Validate(data)
+}
+
+func refineApplicationManifest(s *Schema) {
+	s.Required = []string{"name"}
+	setPattern(property(s, "name"), constants.ApplicationNamePattern)
+	setPattern(property(s, "instance-type"), constants.InstanceTypePattern)
+	setPattern(property(s, "boot-package"), constants.AndroidPackageNamePattern)
+	setPattern(items(property(s, "addons")), constants.AddonNamePattern)
+	setEnum(property(s, "video-encoder"), videoEncoders()...)
+
+	resources := s.Defs["ApplicationResources"]
+	gpuTypes := make([]interface{}, 0, len(packages.SupportedGPUTypes))
+	for _, t := range packages.SupportedGPUTypes {
+		gpuTypes = append(gpuTypes, t)
+	}
+	setEnum(property(resources, "gpu-type"), gpuTypes...)
+	for _, name := range []string{"cpus", "gpu-slots", "vpu-slots"} {
+		setRange(property(resources, name), 0, -1)
+	}
+
+	service := s.Defs["ApplicationManifestService"]
+	setRange(property(service, "port"), 1, 65535)
+	setRange(property(service, "port-end"), 0, 65535)
+	setEnum(items(property(service, "protocols")), protocols()...)
+	if service != nil {
+		service.Required = []string{"port", "protocols"}
+	}
+
+	if extraData := s.Defs["ApplicationExtraData"]; extraData != nil {
+		extraData.Required = []string{"target"}
+		setPattern(property(extraData, "target"), `^/`)
+		// Unquoted permissions like 0644 are read as octal integers by YAML
+		if permissions := property(extraData, "permissions"); permissions != nil {
+			permissions.Type = Types{"string", "integer"}
+			setPattern(permissions, `^[0-7]{3,4}$`)
+			setRange(permissions, 0, 0o7777)
+		}
+	}
+}
+
+func refineAddonManifest(s *Schema) {
+	s.Required = []string{"name"}
+	setPattern(property(s, "name"), constants.AddonNamePattern)
+}
+
+func refineNetworkServices(s *Schema) {
+	spec := s.Defs["NetworkServiceSpec"]
+	setRange(property(spec, "port"), 1, 65535)
+	setRange(property(spec, "port_end"), 0, 65535)
+	setEnum(items(property(spec, "protocols")), protocols()...)
+}
+
+func videoEncoders() []interface{} {
+	return []interface{}{
+		string(api.VideoEncoderTypeGPU),
+		string(api.VideoEncoderTypeGPUPreferred),
+		string(api.VideoEncoderTypeSoftware),
+		string(api.VideoEncoderTypeVPU),
+	}
+}
+
+func protocols() []interface{} {
+	return []interface{}{string(api.NetworkProtocolTCP), string(api.NetworkProtocolUDP)}
+}
+
+// property returns the schema of a property of an object. Nil is returned
+// if the object or the property doesn't exist so that refinements for
+// fields which were removed from a type are silently skipped.
+func property(s *Schema, name string) *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Properties[name]
+}
+
+func items(s *Schema) *Schema {
+	if s == nil {
+		return nil
+	}
+	return s.Items
+}
+
+func setPattern(s *Schema, pattern string) {
+	if s != nil {
+		s.Pattern = pattern
+	}
+}
+
+func setEnum(s *Schema, values ...interface{}) {
+	if s == nil {
+		return
+	}
+	if s.Type.Has("null") {
+		values = append(values, nil)
+	}
+	s.Enum = values
+}
+
+// setRange limits a numeric value. A negative max leaves it unbounded.
+func setRange(s *Schema, min, max float64) {
+	if s == nil {
+		return
+	}
+	s.Minimum = &min
+	if max >= 0 {
+		s.Maximum = &max
+	}
+}
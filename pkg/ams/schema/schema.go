@@ -0,0 +1,290 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package schema generates JSON Schema documents from the Go types of the
+// SDK and validates YAML or JSON documents against them.
+package schema
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Draft is the JSON Schema dialect of all generated documents
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema represents a JSON Schema document or a part of it
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	ID                   string             `json:"$id,omitempty"`
+	Ref                  string             `json:"$ref,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Defs                 map[string]*Schema `json:"$defs,omitempty"`
+
+	// noAdditionalProperties marks objects which don't accept unknown
+	// properties. It is marshalled as additionalProperties: false.
+	noAdditionalProperties bool
+}
+
+// MarshalJSON implements json.Marshaler interface
+func (s *Schema) MarshalJSON() ([]byte, error) {
+	type plain Schema
+	if !s.noAdditionalProperties {
+		return json.Marshal((*plain)(s))
+	}
+	return json.Marshal(struct {
+		*plain
+		AdditionalProperties bool `json:"additionalProperties"`
+	}{(*plain)(s), false})
+}
+
+// Types lists the JSON types a value may have. A single type is marshalled
+// as a plain string.
+type Types []string
+
+// MarshalJSON implements json.Marshaler interface
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// Has returns true if the given type is in the list
+func (t Types) Has(name string) bool {
+	for _, n := range t {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateOptions controls how a schema is derived from a Go type
+type GenerateOptions struct {
+	// Tag is the struct tag property names are taken from, e.g. json or yaml
+	Tag string
+	// Strict rejects properties which are not part of the Go type
+	Strict bool
+	// RefPrefix is prepended to the name of a definition when it is
+	// referenced. Defaults to #/$defs/.
+	RefPrefix string
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	rawJSONType   = reflect.TypeOf(json.RawMessage{})
+	durationType  = reflect.TypeOf(time.Duration(0))
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// Generator derives schemas for multiple Go types which share one set of
+// definitions
+type Generator struct {
+	opts  GenerateOptions
+	defs  map[string]*Schema
+	types map[reflect.Type]string
+}
+
+// NewGenerator returns a new generator
+func NewGenerator(opts GenerateOptions) *Generator {
+	if len(opts.Tag) == 0 {
+		opts.Tag = "json"
+	}
+	if len(opts.RefPrefix) == 0 {
+		opts.RefPrefix = "#/$defs/"
+	}
+	return &Generator{
+		opts:  opts,
+		defs:  map[string]*Schema{},
+		types: map[reflect.Type]string{},
+	}
+}
+
+// Schema returns the schema for the type of v. Named struct types are added
+// to the definitions and a reference to them is returned.
+func (g *Generator) Schema(v interface{}) *Schema {
+	return g.schemaFor(reflect.TypeOf(v))
+}
+
+// Defs returns all definitions collected so far
+func (g *Generator) Defs() map[string]*Schema {
+	return g.defs
+}
+
+// Generate derives a JSON Schema from the type of v. Named struct types are
+// placed in $defs and referenced so that recursive types are supported.
+func Generate(v interface{}, opts GenerateOptions) *Schema {
+	g := NewGenerator(opts)
+
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s := g.structSchema(t)
+	s.Schema = Draft
+	if len(g.defs) > 0 {
+		s.Defs = g.defs
+	}
+	return s
+}
+
+// defName returns the name of the definition for a named type. Types with
+// the same name from different packages are qualified with their package.
+func (g *Generator) defName(t reflect.Type) string {
+	if name, ok := g.types[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := g.defs[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	g.types[t] = name
+	return name
+}
+
+func (g *Generator) schemaFor(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: Types{"string"}, Format: "date-time"}
+	case rawJSONType, interfaceType:
+		return &Schema{}
+	case durationType:
+		return &Schema{Type: Types{"integer"}}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schemaFor(t.Elem())
+		if len(s.Type) > 0 && !s.Type.Has("null") {
+			s.Type = append(s.Type, "null")
+		}
+		return s
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: Types{"integer"}}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		zero := 0.0
+		return &Schema{Type: Types{"integer"}, Minimum: &zero}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are encoded as base64 strings
+			return &Schema{Type: Types{"string"}}
+		}
+		return &Schema{Type: Types{"array"}, Items: g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: Types{"object"}, AdditionalProperties: g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.structSchema(t)
+		}
+		_, known := g.types[t]
+		name := g.defName(t)
+		if !known {
+			// Reserve the name first to stop recursion
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return &Schema{Ref: g.opts.RefPrefix + name}
+	default:
+		return &Schema{}
+	}
+}
+
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{
+		Type:                   Types{"object"},
+		Properties:             map[string]*Schema{},
+		noAdditionalProperties: g.opts.Strict,
+	}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		name, inline, skip := g.fieldName(f)
+		if skip {
+			continue
+		}
+
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if inline && ft.Kind() == reflect.Struct {
+			g.addFields(s, ft)
+			continue
+		}
+		if !f.IsExported() {
+			continue
+		}
+		s.Properties[name] = g.schemaFor(f.Type)
+	}
+}
+
+// fieldName returns the property name of a struct field the same way the
+// encoding package for the configured tag determines it
+func (g *Generator) fieldName(f reflect.StructField) (name string, inline, skip bool) {
+	tag, hasTag := f.Tag.Lookup(g.opts.Tag)
+	if tag == "-" {
+		return "", false, true
+	}
+	parts := strings.Split(tag, ",")
+	for _, opt := range parts[1:] {
+		if opt == "inline" {
+			return "", true, false
+		}
+	}
+	if f.Anonymous && (!hasTag || len(parts[0]) == 0) {
+		return "", true, false
+	}
+	if !f.IsExported() {
+		return "", false, true
+	}
+
+	name = parts[0]
+	if len(name) == 0 {
+		name = f.Name
+		if g.opts.Tag == "yaml" {
+			name = strings.ToLower(name)
+		}
+	}
+	return name, false, false
+}
@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/packages"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Validate checks the given YAML or JSON document against the schema. As
+// JSON is a subset of YAML both are accepted. All violations are returned as
+// packages.FieldErrors.
+func (s *Schema) Validate(data []byte) error {
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errs.NewErrInvalidFormat(fmt.Sprintf("document: %v", err))
+	}
+	return s.ValidateValue(normalize(doc))
+}
+
+// ValidateValue checks an already decoded document against the schema. Maps
+// must have string keys and numbers can be of any Go numeric type.
+func (s *Schema) ValidateValue(value interface{}) error {
+	v := &validator{root: s, patterns: map[string]*regexp.Regexp{}}
+	v.validate("", s, value)
+	return v.errors.Err()
+}
+
+type validator struct {
+	root     *Schema
+	errors   packages.FieldErrors
+	patterns map[string]*regexp.Regexp
+}
+
+func (v *validator) resolve(s *Schema) *Schema {
+	for len(s.Ref) > 0 {
+		name := s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+		def, ok := v.root.Defs[name]
+		if !ok || def == nil {
+			return &Schema{}
+		}
+		s = def
+	}
+	return s
+}
+
+func fieldName(path string) string {
+	if len(path) == 0 {
+		return "(root)"
+	}
+	return path
+}
+
+func joinPath(path, elem string) string {
+	if len(path) == 0 {
+		return elem
+	}
+	return path + "." + elem
+}
+
+func (v *validator) validate(path string, s *Schema, value interface{}) {
+	s = v.resolve(s)
+
+	for _, sub := range s.AllOf {
+		v.validate(path, sub, value)
+	}
+
+	if len(s.Type) > 0 {
+		t := jsonType(value)
+		if !s.Type.Has(t) && !(t == "integer" && s.Type.Has("number")) {
+			v.errors.Addf(fieldName(path), "expected %s but got %s", strings.Join(s.Type, " or "), t)
+			return
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, e := range s.Enum {
+			if reflect.DeepEqual(normalize(e), value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.errors.Addf(fieldName(path), "value %v is not one of %v", value, s.Enum)
+		}
+	}
+
+	switch val := value.(type) {
+	case string:
+		if len(s.Pattern) > 0 {
+			re, ok := v.patterns[s.Pattern]
+			if !ok {
+				re = regexp.MustCompile(s.Pattern)
+				v.patterns[s.Pattern] = re
+			}
+			if !re.MatchString(val) {
+				v.errors.Addf(fieldName(path), "value %q does not match pattern %s", val, s.Pattern)
+			}
+		}
+	case float64:
+		if s.Minimum != nil && val < *s.Minimum {
+			v.errors.Addf(fieldName(path), "value %v is less than %v", val, *s.Minimum)
+		}
+		if s.Maximum != nil && val > *s.Maximum {
+			v.errors.Addf(fieldName(path), "value %v is greater than %v", val, *s.Maximum)
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for n, item := range val {
+				v.validate(fmt.Sprintf("%s[%d]", path, n), s.Items, item)
+			}
+		}
+	case map[string]interface{}:
+		v.validateObject(path, s, val)
+	}
+}
+
+func (v *validator) validateObject(path string, s *Schema, obj map[string]interface{}) {
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			v.errors.Add(joinPath(path, name), errs.NewErrRequired(name))
+		}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if prop, ok := s.Properties[k]; ok {
+			v.validate(joinPath(path, k), prop, obj[k])
+			continue
+		}
+		switch {
+		case s.AdditionalProperties != nil:
+			v.validate(joinPath(path, k), s.AdditionalProperties, obj[k])
+		case s.noAdditionalProperties:
+			v.errors.Addf(joinPath(path, k), "unknown property")
+		}
+	}
+}
+
+// jsonType returns the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if val == float64(int64(val)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// normalize converts decoded YAML into the representation encoding/json
+// uses: maps with string keys and all numbers as float64
+func normalize(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			m[fmt.Sprintf("%v", k)] = normalize(v)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			m[k] = normalize(v)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(val))
+		for n, v := range val {
+			l[n] = normalize(v)
+		}
+		return l
+	case int:
+		return float64(val)
+	case int64:
+		return float64(val)
+	case uint64:
+		return float64(val)
+	case float32:
+		return float64(val)
+	default:
+		return value
+	}
+}
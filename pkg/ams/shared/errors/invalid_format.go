@@ -36,3 +36,13 @@ func (e ErrInvalidFormat) Error() string {
 func NewErrInvalidFormat(what string) ErrInvalidFormat {
 	return ErrInvalidFormat{content{what}}
 }
+
+// IsErrInvalidFormat checks if the given error is of type ErrInvalidFormat
+func IsErrInvalidFormat(err error) bool {
+	switch err.(type) {
+	case ErrInvalidFormat:
+		return true
+	default:
+		return false
+	}
+}
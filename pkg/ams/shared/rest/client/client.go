@@ -19,6 +19,7 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
@@ -28,6 +29,7 @@ import (
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -53,6 +55,8 @@ type client struct {
 	eventListeners     []*EventListener
 	eventListenersLock *sync.Mutex
 
+	timeouts timeoutsHolder
+
 	// TODO for now this is not being filled anywhere
 	httpUserAgent string
 }
@@ -95,8 +99,8 @@ func newNetworkClient(url *url.URL, tlsConfig *tls.Config) (Client, error) {
 		Doer: &http.Client{
 			Transport: &http.Transport{
 				TLSClientConfig: tlsConfig,
+				DialContext:     dialContext((&net.Dialer{}).DialContext),
 			},
-			Timeout: DefaultTransportTimeout,
 		},
 	}
 	return c, nil
@@ -119,8 +123,8 @@ func NewOIDCClient(addr any, tlsConfig *tls.Config, tokenProvier TokenProvider)
 			Client: &http.Client{
 				Transport: &http.Transport{
 					TLSClientConfig: tlsConfig,
+					DialContext:     dialContext((&net.Dialer{}).DialContext),
 				},
-				Timeout: DefaultTransportTimeout,
 			},
 			tokenProvider: tokenProvier,
 		},
@@ -147,13 +151,19 @@ func newUnixSocketClient(path string) (Client, error) {
 		return nil, err
 	}
 
+	unixDialContext := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+
 	c := &client{
 		Doer: &http.Client{
 			Transport: &http.Transport{
+				// Dial is still used for websockets
 				Dial:              unixDial,
+				DialContext:       dialContext(unixDialContext),
 				DisableKeepAlives: true,
 			},
-			Timeout: DefaultTransportTimeout,
 		},
 		serviceURL:         unixSocketServiceURL,
 		eventListenersLock: &sync.Mutex{},
@@ -193,18 +203,36 @@ func (c *client) HTTPTransport() *http.Transport {
 	return nil
 }
 
-// SetTimeout overwrites default timeout of the client with a new one
+// SetTransportTimeout overwrites the default overall timeout of requests
+// which don't specify their own timeouts
+//
+// Deprecated: use SetTimeouts or WithTimeouts instead
 func (c *client) SetTransportTimeout(timeout time.Duration) {
-	if c.Doer == nil {
-		return
-	}
+	t := c.timeouts.get()
+	t.Overall = timeout
+	c.timeouts.set(t)
+}
 
-	c.Doer.(*http.Client).Timeout = timeout
+// SetTimeouts overwrites the default timeouts of requests which don't specify
+// their own timeouts through their context
+func (c *client) SetTimeouts(timeouts Timeouts) {
+	c.timeouts.set(timeouts)
+}
+
+// Timeouts returns the default timeouts of the client
+func (c *client) Timeouts() Timeouts {
+	return c.timeouts.get()
 }
 
 // QueryStruct sends a request to the server and stores response in a struct
 func (c *client) QueryStruct(method, path string, params QueryParams, header http.Header, body io.Reader, etag string, target interface{}) (string, error) {
-	resp, etag, err := c.CallAPI(method, path, params, header, body, etag)
+	return c.QueryStructContext(context.Background(), method, path, params, header, body, etag, target)
+}
+
+// QueryStructContext sends a request with the given context to the server and
+// stores response in a struct
+func (c *client) QueryStructContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, etag string, target interface{}) (string, error) {
+	resp, etag, err := c.CallAPIContext(ctx, method, path, params, header, body, etag)
 	if err != nil {
 		return "", err
 	}
@@ -225,13 +253,19 @@ func pretty(input interface{}) string {
 // QueryOperation sends a request to the server that will return an async response in an Operation object
 // that allows additional logic like wait for completion or cancel it
 func (c *client) QueryOperation(method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (Operation, string, error) {
+	return c.QueryOperationContext(context.Background(), method, path, params, header, body, etag)
+}
+
+// QueryOperationContext sends a request with the given context to the server
+// that will return an async response in an Operation object
+func (c *client) QueryOperationContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (Operation, string, error) {
 	// Attempt to setup an early event listener
 	listener, err := c.GetEvents()
 	if err != nil {
 		listener = nil
 	}
 
-	resp, etag, err := c.CallAPI(method, path, params, header, body, etag)
+	resp, etag, err := c.CallAPIContext(ctx, method, path, params, header, body, etag)
 	if err != nil {
 		if listener != nil {
 			listener.Disconnect()
@@ -259,17 +293,31 @@ func (c *client) QueryOperation(method, path string, params QueryParams, header
 
 // CallAPI requests a REST api method with provided query params and body and returns related http response
 func (c *client) CallAPI(method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (*api.Response, string, error) {
-	resp, err := c.performRequest(method, path, params, header, body, etag)
+	return c.CallAPIContext(context.Background(), method, path, params, header, body, etag)
+}
+
+// CallAPIContext requests a REST api method with the given context and returns related http response
+func (c *client) CallAPIContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (*api.Response, string, error) {
+	resp, err := c.performRequest(ctx, method, path, params, header, body, etag)
 	if err != nil {
 		return nil, "", err
 	}
 	defer resp.Body.Close()
 
-	return c.parseResponse(resp)
+	response, etag, err := c.parseResponse(resp)
+	if err != nil && resp.StatusCode >= http.StatusBadRequest {
+		return nil, "", &StatusError{StatusCode: resp.StatusCode, Err: err}
+	}
+	return response, etag, err
 }
 
 func (c *client) DownloadFile(path string, params QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
-	resp, err := c.performRequest("GET", path, params, header, nil, "")
+	return c.DownloadFileContext(context.Background(), path, params, header, downloader)
+}
+
+// DownloadFileContext downloads a file with the given context and passes its content to downloader
+func (c *client) DownloadFileContext(ctx context.Context, path string, params QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error {
+	resp, err := c.performRequest(ctx, "GET", path, params, header, nil, "")
 	if err != nil {
 		return err
 	}
@@ -277,26 +325,54 @@ func (c *client) DownloadFile(path string, params QueryParams, header http.Heade
 
 	// NOTE: As a fileResposne is an inline response and different with
 	// generic api.Response so that we can't simply parse the response
-	// directly unless http status code is not StatusOK
-	if resp.StatusCode != http.StatusOK {
+	// directly unless http status code is not StatusOK. Partial content is
+	// returned when a range was requested.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 		_, _, err := c.parseResponse(resp)
-		return err
+		if err != nil {
+			return &StatusError{StatusCode: resp.StatusCode, Err: err}
+		}
+		return nil
 	}
 
 	return downloader(&resp.Header, resp.Body)
 }
 
-func (c *client) performRequest(method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (*http.Response, error) {
+// StatusError is returned when a request fails with an HTTP error status
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func (c *client) performRequest(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, etag string) (*http.Response, error) {
 	u := c.serviceURL.ResolveReference(
 		&url.URL{
 			Path: path,
 		},
 	)
 
-	r, err := http.NewRequest(method, u.String(), body)
+	timeouts, ok := TimeoutsFromContext(ctx)
+	if !ok {
+		timeouts = c.timeouts.get()
+	}
+	ctx, watchdog := applyTimeouts(ctx, timeouts)
+
+	r, err := http.NewRequestWithContext(ctx, method, u.String(), body)
 	if err != nil {
+		watchdog.stop()
 		return nil, err
 	}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &watchedRequestBody{ReadCloser: r.Body, w: watchdog}
+	}
 
 	v := r.URL.Query()
 	for key, value := range params {
@@ -314,7 +390,27 @@ func (c *client) performRequest(method, path string, params QueryParams, header
 		}
 	}
 
-	return c.Doer.Do(r)
+	// The length of streamed bodies can't be determined by the http package
+	// itself so callers pass it along as header
+	if length := r.Header.Get("Content-Length"); len(length) > 0 {
+		r.Header.Del("Content-Length")
+		r.ContentLength, err = strconv.ParseInt(length, 10, 64)
+		if err != nil {
+			watchdog.stop()
+			return nil, fmt.Errorf("invalid content length %q: %w", length, err)
+		}
+	}
+
+	resp, err := c.Doer.Do(r)
+	if err != nil {
+		watchdog.stop()
+		return nil, err
+	}
+
+	// The timeouts keep applying until the caller is done with the body
+	watchdog.touch()
+	resp.Body = &watchedBody{ReadCloser: resp.Body, w: watchdog}
+	return resp, nil
 }
 
 // Internal functions
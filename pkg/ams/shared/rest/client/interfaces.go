@@ -63,11 +63,17 @@ type Client interface {
 	HTTPTransport() *http.Transport
 
 	SetTransportTimeout(timeout time.Duration)
+	SetTimeouts(timeouts Timeouts)
+	Timeouts() Timeouts
 
 	QueryStruct(method, path string, params QueryParams, header http.Header, body io.Reader, ETag string, target interface{}) (etag string, err error)
+	QueryStructContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, ETag string, target interface{}) (etag string, err error)
 	QueryOperation(method, path string, params QueryParams, header http.Header, body io.Reader, ETag string) (operation Operation, etag string, err error)
+	QueryOperationContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, ETag string) (operation Operation, etag string, err error)
 	CallAPI(method, path string, params QueryParams, header http.Header, body io.Reader, ETag string) (response *api.Response, etag string, err error)
+	CallAPIContext(ctx context.Context, method, path string, params QueryParams, header http.Header, body io.Reader, ETag string) (response *api.Response, etag string, err error)
 	DownloadFile(path string, params QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error
+	DownloadFileContext(ctx context.Context, path string, params QueryParams, header http.Header, downloader func(header *http.Header, body io.ReadCloser) error) error
 
 	Websocket(resource string) (conn *websocket.Conn, err error)
 
@@ -0,0 +1,197 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultConnectTimeout is the default time allowed to establish a connection
+// with the server
+const DefaultConnectTimeout = 30 * time.Second
+
+// Timeouts limits how long a single request may take. A zero value disables
+// the particular timeout.
+type Timeouts struct {
+	// Connect limits the time to establish the connection with the server
+	Connect time.Duration
+	// Idle limits the time a request may not make any progress, neither
+	// sending nor receiving data, before it is aborted
+	Idle time.Duration
+	// Overall limits the time of the complete request including the
+	// transfer of request and response bodies
+	Overall time.Duration
+}
+
+// DefaultTimeouts returns the timeouts applied to requests which don't carry
+// any through their context
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Connect: DefaultConnectTimeout,
+		Overall: DefaultTransportTimeout,
+	}
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns a copy of ctx which applies the given timeouts to all
+// requests made with it instead of the defaults of the client
+func WithTimeouts(ctx context.Context, timeouts Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, timeouts)
+}
+
+// TimeoutsFromContext returns the timeouts attached to ctx, if any
+func TimeoutsFromContext(ctx context.Context) (Timeouts, bool) {
+	t, ok := ctx.Value(timeoutsKey{}).(Timeouts)
+	return t, ok
+}
+
+type connectTimeoutKey struct{}
+
+// dialContext wraps the given dial function so it honors the connect timeout
+// of the request being dialed for
+func dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if timeout, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// applyTimeouts derives a context from ctx which enforces the given timeouts.
+// The returned watchdog must be notified about progress of the request and
+// stopped once the request has finished.
+func applyTimeouts(ctx context.Context, timeouts Timeouts) (context.Context, *idleWatchdog) {
+	if timeouts.Connect > 0 {
+		ctx = context.WithValue(ctx, connectTimeoutKey{}, timeouts.Connect)
+	}
+
+	var cancelOverall context.CancelFunc = func() {}
+	if timeouts.Overall > 0 {
+		ctx, cancelOverall = context.WithTimeout(ctx, timeouts.Overall)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := &idleWatchdog{
+		cancel: func() {
+			cancel()
+			cancelOverall()
+		},
+	}
+	if timeouts.Idle > 0 {
+		w.timer = time.AfterFunc(timeouts.Idle, cancel)
+		w.idle = timeouts.Idle
+	}
+	return ctx, w
+}
+
+// idleWatchdog cancels a request when it hasn't made any progress for a while
+type idleWatchdog struct {
+	lock   sync.Mutex
+	timer  *time.Timer
+	idle   time.Duration
+	cancel context.CancelFunc
+	done   bool
+}
+
+// touch records progress of the request
+func (w *idleWatchdog) touch() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timer != nil && !w.done {
+		w.timer.Reset(w.idle)
+	}
+}
+
+// stop releases all resources of the request
+func (w *idleWatchdog) stop() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.done {
+		return
+	}
+	w.done = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.cancel()
+}
+
+// watchedRequestBody reports every read of a request body to the watchdog
+type watchedRequestBody struct {
+	io.ReadCloser
+	w *idleWatchdog
+}
+
+func (b *watchedRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.w.touch()
+	}
+	return n, err
+}
+
+// watchedBody is a response body which stops the watchdog of its request
+// once it's closed
+type watchedBody struct {
+	io.ReadCloser
+	w *idleWatchdog
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.w.touch()
+	}
+	return n, err
+}
+
+func (b *watchedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.w.stop()
+	return err
+}
+
+// timeoutsHolder stores the default timeouts of a client
+type timeoutsHolder struct {
+	lock     sync.RWMutex
+	timeouts *Timeouts
+}
+
+func (h *timeoutsHolder) get() Timeouts {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	if h.timeouts == nil {
+		return DefaultTimeouts()
+	}
+	return *h.timeouts
+}
+
+func (h *timeoutsHolder) set(t Timeouts) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.timeouts = &t
+}
@@ -398,10 +398,19 @@ func CreateZip(workingDir, outputPath string, content []string) error {
 
 // CreateBzip2Tarball creates a bzip2 tarball file with given files path
 func CreateBzip2Tarball(workingDir, outputPath string, content []string) error {
+	return CreateTarball(workingDir, outputPath, "--bzip2", content)
+}
+
+// CreateTarball creates a tarball file with given files path which is
+// compressed according to the given tar compression flag, e.g. --gzip
+func CreateTarball(workingDir, outputPath, compressionFlag string, content []string) error {
 	comopressArg := []string{
-		"cfj", outputPath,
+		"cf", outputPath,
 		"-C", workingDir,
 	}
+	if len(compressionFlag) > 0 {
+		comopressArg = append(comopressArg, compressionFlag)
+	}
 	comopressArg = append(comopressArg, content...)
 
 	return exec.Command("tar", comopressArg...).Run()
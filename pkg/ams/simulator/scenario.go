@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package simulator
+
+import (
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+)
+
+// Scenario describes hypothetical changes to a cluster
+type Scenario struct {
+	// NodeChanges are applied to existing nodes, indexed by node name
+	NodeChanges map[string]NodeChange `json:"node-changes,omitempty" yaml:"node-changes,omitempty"`
+	// AddNodes are added to the cluster
+	AddNodes []api.Node `json:"add-nodes,omitempty" yaml:"add-nodes,omitempty"`
+	// RemoveNodes are removed from the cluster. Their instances are placed
+	// on the remaining nodes.
+	RemoveNodes []string `json:"remove-nodes,omitempty" yaml:"remove-nodes,omitempty"`
+	// ApplicationChanges are applied to applications, indexed by
+	// application ID or name
+	ApplicationChanges map[string]ApplicationChange `json:"application-changes,omitempty" yaml:"application-changes,omitempty"`
+	// Launches are placed in order after all changes are applied
+	Launches []Launch `json:"launches,omitempty" yaml:"launches,omitempty"`
+}
+
+// NodeChange describes a change to a node. Only set fields are changed.
+type NodeChange struct {
+	CPUs                 *int               `json:"cpus,omitempty" yaml:"cpus,omitempty"`
+	CPUAllocationRate    *float32           `json:"cpu_allocation_rate,omitempty" yaml:"cpu_allocation_rate,omitempty"`
+	Memory               *string            `json:"memory,omitempty" yaml:"memory,omitempty"`
+	MemoryAllocationRate *float32           `json:"memory_allocation_rate,omitempty" yaml:"memory_allocation_rate,omitempty"`
+	DiskSize             *string            `json:"disk_size,omitempty" yaml:"disk_size,omitempty"`
+	GPUSlots             *int               `json:"gpu_slots,omitempty" yaml:"gpu_slots,omitempty"`
+	Tags                 *[]string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Unschedulable        *bool              `json:"unschedulable,omitempty" yaml:"unschedulable,omitempty"`
+	GPUs                 []api.NodeGPUPatch `json:"gpus,omitempty" yaml:"gpus,omitempty"`
+}
+
+// ApplicationChange describes a change to an application. Only set fields
+// are changed.
+type ApplicationChange struct {
+	NodeSelector *[]string                 `json:"node_selector,omitempty" yaml:"node_selector,omitempty"`
+	Resources    *api.ApplicationResources `json:"resources,omitempty" yaml:"resources,omitempty"`
+}
+
+// Launch describes instances to place
+type Launch struct {
+	// Application is the ID or name of an application of the snapshot
+	Application string `json:"application,omitempty" yaml:"application,omitempty"`
+	// Resources override the resources of the application. Required if
+	// no application is given.
+	Resources *api.ApplicationResources `json:"resources,omitempty" yaml:"resources,omitempty"`
+	// NodeSelector overrides the node selector of the application
+	NodeSelector []string `json:"node_selector,omitempty" yaml:"node_selector,omitempty"`
+	// Type of the instances. Defaults to the type used by the application or
+	// container.
+	Type api.InstanceType `json:"type,omitempty" yaml:"type,omitempty"`
+	// Node pins the instances to a node
+	Node string `json:"node,omitempty" yaml:"node,omitempty"`
+	// Count of instances to launch. Defaults to one.
+	Count int `json:"count,omitempty" yaml:"count,omitempty"`
+}
+
+// LoadScenario reads a scenario from a YAML fixture
+func LoadScenario(path string) (*Scenario, error) {
+	s := &Scenario{}
+	if err := shared.LoadFromFile(path, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// apply changes a node according to the change
+func (c *NodeChange) apply(node *api.Node) {
+	if c.CPUs != nil {
+		node.CPUs = *c.CPUs
+	}
+	if c.CPUAllocationRate != nil {
+		node.CPUAllocationRate = *c.CPUAllocationRate
+	}
+	if c.Memory != nil {
+		node.Memory = *c.Memory
+	}
+	if c.MemoryAllocationRate != nil {
+		node.MemoryAllocationRate = *c.MemoryAllocationRate
+	}
+	if c.DiskSize != nil {
+		node.DiskSize = *c.DiskSize
+	}
+	if c.GPUSlots != nil {
+		node.GPUSlots = *c.GPUSlots
+	}
+	if c.Tags != nil {
+		node.Tags = *c.Tags
+	}
+	if c.Unschedulable != nil {
+		node.Unschedulable = *c.Unschedulable
+	}
+	for _, p := range c.GPUs {
+		for n := range node.GPUs {
+			if node.GPUs[n].ID == p.ID && p.Slots != nil {
+				node.GPUs[n].Slots = *p.Slots
+			}
+		}
+	}
+}
+
+// apply changes an application according to the change
+func (c *ApplicationChange) apply(app *api.Application) {
+	if c.NodeSelector != nil {
+		app.NodeSelector = *c.NodeSelector
+	}
+	if c.Resources != nil {
+		app.Resources = *c.Resources
+	}
+}
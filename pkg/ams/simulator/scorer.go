@@ -0,0 +1,62 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package simulator
+
+import (
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+)
+
+// Scorer ranks the nodes an instance fits on. The instance is placed on the
+// node with the highest score. Ties are broken by node name.
+type Scorer interface {
+	Score(node *capacity.NodeCapacity, req *capacity.Requirements) float64
+}
+
+// ScorerFunc allows using a function as a Scorer
+type ScorerFunc func(node *capacity.NodeCapacity, req *capacity.Requirements) float64
+
+// Score calls f(node, req)
+func (f ScorerFunc) Score(node *capacity.NodeCapacity, req *capacity.Requirements) float64 {
+	return f(node, req)
+}
+
+var (
+	// LeastAllocated prefers the nodes with the most free CPUs and memory,
+	// spreading instances across the cluster. This approximates the
+	// placement of AMS and is used by default.
+	LeastAllocated Scorer = ScorerFunc(func(node *capacity.NodeCapacity, _ *capacity.Requirements) float64 {
+		return freeRatio(node)
+	})
+	// MostAllocated prefers the nodes with the least free CPUs and memory,
+	// packing instances on as few nodes as possible
+	MostAllocated Scorer = ScorerFunc(func(node *capacity.NodeCapacity, _ *capacity.Requirements) float64 {
+		return 1 - freeRatio(node)
+	})
+)
+
+// freeRatio returns the average share of free CPUs and memory on the node
+func freeRatio(node *capacity.NodeCapacity) float64 {
+	ratio := func(free, total int64) float64 {
+		if total <= 0 {
+			return 0
+		}
+		return float64(free) / float64(total)
+	}
+	return (ratio(int64(node.Free.CPUs), int64(node.Total.CPUs)) + ratio(node.Free.Memory, node.Total.Memory)) / 2
+}
@@ -0,0 +1,313 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+// Package simulator predicts where AMS places instances. It replays an
+// approximation of the AMS placement rules against a snapshot of a cluster
+// with hypothetical changes applied.
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+// Options configure a simulation
+type Options struct {
+	// Scorer ranks the candidate nodes. Defaults to LeastAllocated.
+	Scorer Scorer
+}
+
+// Placement describes where a single instance was placed
+type Placement struct {
+	// Launch is the index of the launch in the scenario or -1 for instances
+	// displaced from removed nodes
+	Launch int `json:"launch" yaml:"launch"`
+	// Instance is the ID of a displaced instance
+	Instance    string `json:"instance,omitempty" yaml:"instance,omitempty"`
+	Application string `json:"application,omitempty" yaml:"application,omitempty"`
+	// Node the instance was placed on. Empty if it could not be placed.
+	Node string `json:"node,omitempty" yaml:"node,omitempty"`
+	// Reasons explains for every node why the instance didn't fit. Only
+	// set if the instance could not be placed.
+	Reasons map[string]capacity.Reason `json:"reasons,omitempty" yaml:"reasons,omitempty"`
+}
+
+// Result is the outcome of a simulation
+type Result struct {
+	Placements []Placement `json:"placements" yaml:"placements"`
+	// Failed is the number of instances which could not be placed
+	Failed int `json:"failed" yaml:"failed"`
+	// Cluster is the capacity of the cluster after all placements
+	Cluster *capacity.Cluster `json:"cluster" yaml:"cluster"`
+}
+
+// String renders the result as a human readable report
+func (r *Result) String() string {
+	var b strings.Builder
+	for _, p := range r.Placements {
+		what := p.Application
+		if len(what) == 0 {
+			what = "instance"
+		}
+		if len(p.Instance) > 0 {
+			what = fmt.Sprintf("%s (displaced %s)", what, p.Instance)
+		} else {
+			what = fmt.Sprintf("%s (launch %d)", what, p.Launch)
+		}
+		if len(p.Node) > 0 {
+			fmt.Fprintf(&b, "%s -> %s\n", what, p.Node)
+			continue
+		}
+		nodes := make([]string, 0, len(p.Reasons))
+		for n := range p.Reasons {
+			nodes = append(nodes, n)
+		}
+		sort.Strings(nodes)
+		reasons := make([]string, 0, len(nodes))
+		for _, n := range nodes {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", n, p.Reasons[n]))
+		}
+		fmt.Fprintf(&b, "%s -> failed (%s)\n", what, strings.Join(reasons, ", "))
+	}
+	fmt.Fprintf(&b, "%d placed, %d failed\n", len(r.Placements)-r.Failed, r.Failed)
+	return b.String()
+}
+
+type request struct {
+	launch      int
+	instance    string
+	application string
+	node        string
+	req         *capacity.Requirements
+}
+
+// Simulate applies the scenario to the snapshot and places all launches and
+// displaced instances. The snapshot is not modified.
+func Simulate(snap *Snapshot, scenario *Scenario, opts *Options) (*Result, error) {
+	if snap == nil {
+		return nil, errs.NewInvalidArgument("snapshot")
+	}
+	if scenario == nil {
+		scenario = &Scenario{}
+	}
+	scorer := LeastAllocated
+	if opts != nil && opts.Scorer != nil {
+		scorer = opts.Scorer
+	}
+
+	state, err := applyScenario(snap, scenario)
+	if err != nil {
+		return nil, err
+	}
+	requests, err := state.requests(scenario)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := capacity.Compute(state.Nodes, state.Instances)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Result{Cluster: cluster}
+	for _, rq := range requests {
+		p, err := place(cluster, scorer, rq)
+		if err != nil {
+			return nil, err
+		}
+		if len(p.Node) == 0 {
+			r.Failed++
+		}
+		r.Placements = append(r.Placements, p)
+	}
+	return r, nil
+}
+
+// place puts a single instance on the best scored node it fits on
+func place(cluster *capacity.Cluster, scorer Scorer, rq request) (Placement, error) {
+	p := Placement{
+		Launch:      rq.launch,
+		Instance:    rq.instance,
+		Application: rq.application,
+		Reasons:     map[string]capacity.Reason{},
+	}
+	var best *capacity.NodeCapacity
+	var bestScore float64
+	for n := range cluster.Nodes {
+		nc := &cluster.Nodes[n]
+		if len(rq.node) > 0 && nc.Node != rq.node {
+			continue
+		}
+		fit, err := nc.Fit(rq.req)
+		if err != nil {
+			return p, err
+		}
+		if fit.Count == 0 {
+			p.Reasons[nc.Node] = fit.Reason
+			continue
+		}
+		if score := scorer.Score(nc, rq.req); best == nil || score > bestScore {
+			best, bestScore = nc, score
+		}
+	}
+	if best == nil {
+		if len(rq.node) > 0 && len(p.Reasons) == 0 {
+			return p, errs.NewErrNotFound("node " + rq.node)
+		}
+		return p, nil
+	}
+	if err := cluster.Allocate(best.Node, rq.req); err != nil {
+		return p, err
+	}
+	p.Node = best.Node
+	p.Reasons = nil
+	return p, nil
+}
+
+// applyScenario returns a copy of the snapshot with all changes of the
+// scenario applied
+func applyScenario(snap *Snapshot, scenario *Scenario) (*Snapshot, error) {
+	state := &Snapshot{
+		Instances:    append([]api.Instance(nil), snap.Instances...),
+		Applications: append([]api.Application(nil), snap.Applications...),
+	}
+
+	removed := map[string]bool{}
+	for _, name := range scenario.RemoveNodes {
+		removed[name] = true
+	}
+	for _, node := range snap.Nodes {
+		if removed[node.Name] {
+			delete(removed, node.Name)
+			continue
+		}
+		node.GPUs = append([]api.NodeGPU(nil), node.GPUs...)
+		state.Nodes = append(state.Nodes, node)
+	}
+	for name := range removed {
+		return nil, errs.NewErrNotFound("node " + name)
+	}
+	for _, node := range scenario.AddNodes {
+		for _, n := range state.Nodes {
+			if n.Name == node.Name {
+				return nil, errs.NewErrAlreadyExists("node " + node.Name)
+			}
+		}
+		if node.StatusCode == api.NodeStatusUnknown {
+			node.StatusCode = api.NodeStatusOnline
+		}
+		state.Nodes = append(state.Nodes, node)
+	}
+
+	for name, change := range scenario.NodeChanges {
+		found := false
+		for n := range state.Nodes {
+			if state.Nodes[n].Name == name {
+				change.apply(&state.Nodes[n])
+				found = true
+			}
+		}
+		if !found {
+			return nil, errs.NewErrNotFound("node " + name)
+		}
+	}
+	for name, change := range scenario.ApplicationChanges {
+		app := state.application(name)
+		if app == nil {
+			return nil, errs.NewErrNotFound("application " + name)
+		}
+		change.apply(app)
+	}
+	return state, nil
+}
+
+// requests returns the instances to place: first the ones displaced from
+// removed nodes and then all launches of the scenario. Displaced instances
+// are dropped from the snapshot.
+func (s *Snapshot) requests(scenario *Scenario) ([]request, error) {
+	nodes := map[string]bool{}
+	for _, n := range s.Nodes {
+		nodes[n.Name] = true
+	}
+
+	var requests []request
+	instances := s.Instances[:0:0]
+	for _, inst := range s.Instances {
+		if len(inst.Node) == 0 || nodes[inst.Node] || inst.StatusCode == api.InstanceStatusDeleted {
+			instances = append(instances, inst)
+			continue
+		}
+		req := &capacity.Requirements{
+			Resources:    inst.Resources.ToApplicationResources(),
+			InstanceType: inst.Type,
+			Architecture: inst.Architecture,
+		}
+		name := inst.AppName
+		if app := s.application(inst.AppID); app != nil {
+			req.NodeSelector = app.NodeSelector
+			name = app.Name
+		}
+		requests = append(requests, request{launch: -1, instance: inst.ID, application: name, req: req})
+	}
+	s.Instances = instances
+
+	for n, l := range scenario.Launches {
+		req, name, err := s.launchRequirements(&l)
+		if err != nil {
+			return nil, fmt.Errorf("launch %d: %w", n, err)
+		}
+		count := l.Count
+		if count <= 0 {
+			count = 1
+		}
+		for range count {
+			requests = append(requests, request{launch: n, application: name, node: l.Node, req: req})
+		}
+	}
+	return requests, nil
+}
+
+// launchRequirements returns the requirements of the instances of a launch
+func (s *Snapshot) launchRequirements(l *Launch) (*capacity.Requirements, string, error) {
+	req := &capacity.Requirements{InstanceType: api.InstanceTypeContainer}
+	name := l.Application
+	if len(l.Application) > 0 {
+		app := s.application(l.Application)
+		if app == nil {
+			return nil, "", errs.NewErrNotFound("application " + l.Application)
+		}
+		req = capacity.RequirementsFromApplication(app)
+		name = app.Name
+	} else if l.Resources == nil {
+		return nil, "", errs.NewErrRequired("application or resources")
+	}
+	if l.Resources != nil {
+		req.Resources = *l.Resources
+	}
+	if l.NodeSelector != nil {
+		req.NodeSelector = l.NodeSelector
+	}
+	if len(l.Type) > 0 {
+		req.InstanceType = l.Type
+	}
+	return req, name, nil
+}
@@ -0,0 +1,239 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package simulator
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/capacity"
+	errs "github.com/anbox-cloud/ams-sdk/pkg/ams/shared/errors"
+)
+
+func testNode(name string, cpus int, memory string, tags ...string) api.Node {
+	return api.Node{
+		Name:       name,
+		CPUs:       cpus,
+		Memory:     memory,
+		DiskSize:   "100GB",
+		StatusCode: api.NodeStatusOnline,
+		Tags:       tags,
+	}
+}
+
+// testSnapshot returns two nodes with room for four a4.3 instances each and
+// an application using that instance type
+func testSnapshot() *Snapshot {
+	return &Snapshot{
+		Nodes: []api.Node{
+			testNode("lxd0", 16, "16GB"),
+			testNode("lxd1", 16, "16GB", "gpu"),
+		},
+		Applications: []api.Application{
+			{ID: "app0", Name: "game", InstanceType: "a4.3"},
+		},
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	unschedulable := true
+	selector := []string{"gpu"}
+	tests := []struct {
+		name       string
+		snapshot   func() *Snapshot
+		scenario   Scenario
+		scorer     Scorer
+		placements []Placement
+		failed     int
+	}{
+		{
+			name:     "spread",
+			snapshot: testSnapshot,
+			scenario: Scenario{Launches: []Launch{{Application: "game", Count: 3}}},
+			placements: []Placement{
+				{Launch: 0, Application: "game", Node: "lxd0"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Node: "lxd0"},
+			},
+		},
+		{
+			name:     "pack",
+			snapshot: testSnapshot,
+			scenario: Scenario{Launches: []Launch{{Application: "app0", Count: 3}}},
+			scorer:   MostAllocated,
+			placements: []Placement{
+				{Launch: 0, Application: "game", Node: "lxd0"},
+				{Launch: 0, Application: "game", Node: "lxd0"},
+				{Launch: 0, Application: "game", Node: "lxd0"},
+			},
+		},
+		{
+			name:     "pinned to node",
+			snapshot: testSnapshot,
+			scenario: Scenario{Launches: []Launch{{Application: "game", Node: "lxd1", Count: 2}}},
+			placements: []Placement{
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+			},
+		},
+		{
+			name:     "node selector",
+			snapshot: testSnapshot,
+			scenario: Scenario{
+				ApplicationChanges: map[string]ApplicationChange{"game": {NodeSelector: &selector}},
+				Launches: []Launch{
+					{Application: "game"},
+					{Application: "game", NodeSelector: []string{"arm"}},
+				},
+			},
+			placements: []Placement{
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 1, Application: "game", Reasons: map[string]capacity.Reason{
+					"lxd0": capacity.ReasonNodeSelector,
+					"lxd1": capacity.ReasonNodeSelector,
+				}},
+			},
+			failed: 1,
+		},
+		{
+			name:     "unschedulable and out of cpus",
+			snapshot: testSnapshot,
+			scenario: Scenario{
+				NodeChanges: map[string]NodeChange{"lxd0": {Unschedulable: &unschedulable}},
+				Launches:    []Launch{{Application: "game", Count: 5}},
+			},
+			placements: []Placement{
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+				{Launch: 0, Application: "game", Reasons: map[string]capacity.Reason{
+					"lxd0": capacity.ReasonUnschedulable,
+					"lxd1": capacity.ReasonCPUs,
+				}},
+			},
+			failed: 1,
+		},
+		{
+			name: "out of memory",
+			snapshot: func() *Snapshot {
+				return &Snapshot{Nodes: []api.Node{testNode("lxd0", 32, "4GB")}}
+			},
+			scenario: Scenario{Launches: []Launch{{
+				Resources: &api.ApplicationResources{CPUs: 1, Memory: "3GB"},
+				Count:     2,
+			}}},
+			placements: []Placement{
+				{Launch: 0, Node: "lxd0"},
+				{Launch: 0, Reasons: map[string]capacity.Reason{"lxd0": capacity.ReasonMemory}},
+			},
+			failed: 1,
+		},
+		{
+			name: "displaced instances",
+			snapshot: func() *Snapshot {
+				s := testSnapshot()
+				s.Instances = []api.Instance{
+					{ID: "inst0", AppID: "app0", Node: "lxd0", StatusCode: api.InstanceStatusRunning,
+						Resources: api.InstanceResources{CPUs: 4, Memory: 3 << 30}},
+					{ID: "inst1", AppID: "app0", Node: "lxd1", StatusCode: api.InstanceStatusRunning,
+						Resources: api.InstanceResources{CPUs: 4, Memory: 3 << 30}},
+				}
+				return s
+			},
+			scenario: Scenario{
+				RemoveNodes: []string{"lxd0"},
+				AddNodes:    []api.Node{{Name: "lxd2", CPUs: 16, Memory: "16GB", DiskSize: "100GB"}},
+				Launches:    []Launch{{Application: "game"}},
+			},
+			placements: []Placement{
+				{Launch: -1, Instance: "inst0", Application: "game", Node: "lxd2"},
+				{Launch: 0, Application: "game", Node: "lxd1"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			snap := test.snapshot()
+			r, err := Simulate(snap, &test.scenario, &Options{Scorer: test.scorer})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r.Placements, test.placements) {
+				t.Fatalf("expected placements %+v, got %+v", test.placements, r.Placements)
+			}
+			if r.Failed != test.failed {
+				t.Fatalf("expected %d failed, got %d", test.failed, r.Failed)
+			}
+			if !reflect.DeepEqual(snap, test.snapshot()) {
+				t.Fatal("snapshot was modified")
+			}
+		})
+	}
+}
+
+func TestSimulateErrors(t *testing.T) {
+	isNotFound := func(err error) bool { return errors.As(err, &errs.ErrNotFound{}) }
+	tests := []struct {
+		name     string
+		scenario Scenario
+		check    func(error) bool
+	}{
+		{
+			name:     "unknown removed node",
+			scenario: Scenario{RemoveNodes: []string{"lxd9"}},
+			check:    isNotFound,
+		},
+		{
+			name:     "unknown changed node",
+			scenario: Scenario{NodeChanges: map[string]NodeChange{"lxd9": {}}},
+			check:    isNotFound,
+		},
+		{
+			name:     "unknown application",
+			scenario: Scenario{Launches: []Launch{{Application: "chess"}}},
+			check:    isNotFound,
+		},
+		{
+			name:     "unknown pinned node",
+			scenario: Scenario{Launches: []Launch{{Application: "game", Node: "lxd9"}}},
+			check:    isNotFound,
+		},
+		{
+			name:     "existing node added",
+			scenario: Scenario{AddNodes: []api.Node{testNode("lxd1", 16, "16GB")}},
+			check:    func(err error) bool { return errors.As(err, &errs.ErrAlreadyExists{}) },
+		},
+		{
+			name:     "launch without resources",
+			scenario: Scenario{Launches: []Launch{{Count: 1}}},
+			check:    func(err error) bool { return errors.As(err, &errs.ErrRequired{}) },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Simulate(testSnapshot(), &test.scenario, nil)
+			if !test.check(err) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
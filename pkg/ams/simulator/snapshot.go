@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package simulator
+
+import (
+	api "github.com/anbox-cloud/ams-sdk/api/ams"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/client"
+	"github.com/anbox-cloud/ams-sdk/pkg/ams/shared"
+)
+
+// Snapshot captures the state of a cluster the simulation starts from
+type Snapshot struct {
+	Nodes        []api.Node        `json:"nodes" yaml:"nodes"`
+	Instances    []api.Instance    `json:"instances" yaml:"instances"`
+	Applications []api.Application `json:"applications" yaml:"applications"`
+}
+
+// TakeSnapshot captures the current state of the cluster the client is
+// connected to
+func TakeSnapshot(c client.Client) (*Snapshot, error) {
+	nodes, err := c.ListNodes()
+	if err != nil {
+		return nil, err
+	}
+	instances, err := c.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	apps, err := c.ListApplications()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Nodes: nodes, Instances: instances, Applications: apps}, nil
+}
+
+// LoadSnapshot reads a snapshot from a YAML fixture
+func LoadSnapshot(path string) (*Snapshot, error) {
+	s := &Snapshot{}
+	if err := shared.LoadFromFile(path, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// application returns the application with the given ID or name
+func (s *Snapshot) application(idOrName string) *api.Application {
+	for n := range s.Applications {
+		if s.Applications[n].ID == idOrName {
+			return &s.Applications[n]
+		}
+	}
+	for n := range s.Applications {
+		if s.Applications[n].Name == idOrName {
+			return &s.Applications[n]
+		}
+	}
+	return nil
+}
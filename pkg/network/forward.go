@@ -0,0 +1,229 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// InstanceDialer opens TCP streams to ports inside an instance. The AMS client
+// implements this interface.
+type InstanceDialer interface {
+	DialInstance(ctx context.Context, id string, port int) (net.Conn, error)
+}
+
+// PortForwarder forwards connections accepted on a local address to a port
+// inside an instance. Every accepted connection gets its own stream to the
+// instance so multiple connections can be served concurrently.
+type PortForwarder struct {
+	listener   net.Listener
+	dialer     InstanceDialer
+	instanceID string
+	remotePort int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	conns     map[net.Conn]struct{}
+	connsLock sync.Mutex
+}
+
+// PortForward starts forwarding connections on localAddr to remotePort inside
+// the given instance, similar to `kubectl port-forward`. If localAddr has no
+// or a zero port, a free port is allocated. Forwarding runs in the background
+// until the context is cancelled or Close is called.
+func PortForward(ctx context.Context, dialer InstanceDialer, instanceID, localAddr string, remotePort int) (*PortForwarder, error) {
+	if dialer == nil {
+		return nil, errors.New("no dialer given")
+	}
+	if len(instanceID) == 0 {
+		return nil, errors.New("no instance ID given")
+	}
+	if remotePort <= 0 || remotePort > 65535 {
+		return nil, fmt.Errorf("invalid remote port %d", remotePort)
+	}
+
+	addr, err := resolveForwardAddress(localAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	f := &PortForwarder{
+		listener:   l,
+		dialer:     dialer,
+		instanceID: instanceID,
+		remotePort: remotePort,
+		ctx:        ctx,
+		cancel:     cancel,
+		conns:      map[net.Conn]struct{}{},
+	}
+
+	f.wg.Add(1)
+	go f.serve()
+
+	go func() {
+		<-ctx.Done()
+		f.listener.Close()
+		f.closeConns()
+	}()
+
+	return f, nil
+}
+
+func resolveForwardAddress(localAddr string) (string, error) {
+	if len(localAddr) == 0 {
+		localAddr = "127.0.0.1:0"
+	}
+
+	host, port, err := net.SplitHostPort(localAddr)
+	if err != nil {
+		// Only a host was given
+		host = localAddr
+		port = ""
+	}
+	if len(host) == 0 {
+		host = "127.0.0.1"
+	}
+
+	if len(port) == 0 || port == "0" {
+		p, err := AllocatePort()
+		if err != nil {
+			return "", err
+		}
+		port = strconv.Itoa(p)
+	}
+
+	return net.JoinHostPort(host, port), nil
+}
+
+// Addr returns the local address connections are accepted on
+func (f *PortForwarder) Addr() net.Addr {
+	return f.listener.Addr()
+}
+
+// Close stops forwarding and closes all active connections
+func (f *PortForwarder) Close() error {
+	f.cancel()
+	f.wg.Wait()
+	return nil
+}
+
+// Wait blocks until forwarding has stopped
+func (f *PortForwarder) Wait() {
+	f.wg.Wait()
+}
+
+func (f *PortForwarder) serve() {
+	defer f.wg.Done()
+	for {
+		conn, err := f.listener.Accept()
+		if err != nil {
+			if f.ctx.Err() == nil {
+				log.Printf("Failed to accept connection: %v", err)
+				f.cancel()
+			}
+			return
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			f.handle(conn)
+		}()
+	}
+}
+
+func (f *PortForwarder) handle(local net.Conn) {
+	f.trackConn(local, true)
+	defer f.trackConn(local, false)
+	defer local.Close()
+
+	remote, err := f.dialer.DialInstance(f.ctx, f.instanceID, f.remotePort)
+	if err != nil {
+		log.Printf("Failed to connect to port %d of instance %s: %v", f.remotePort, f.instanceID, err)
+		return
+	}
+	f.trackConn(remote, true)
+	defer f.trackConn(remote, false)
+	defer remote.Close()
+
+	// When one side finishes sending, pass the end of the stream on to the
+	// other one and keep forwarding until it has finished too
+	done := make(chan bool, 2)
+	go func() {
+		io.Copy(remote, local)
+		closeWrite(remote)
+		done <- true
+	}()
+	go func() {
+		io.Copy(local, remote)
+		closeWrite(local)
+		done <- true
+	}()
+
+	for n := 0; n < 2; n++ {
+		select {
+		case <-done:
+		case <-f.ctx.Done():
+			return
+		}
+	}
+}
+
+// closeWrite shuts down the writing side of the connection. Connections
+// which can't be half-closed are closed completely.
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+		return
+	}
+	conn.Close()
+}
+
+func (f *PortForwarder) trackConn(conn net.Conn, active bool) {
+	f.connsLock.Lock()
+	defer f.connsLock.Unlock()
+	if active {
+		f.conns[conn] = struct{}{}
+	} else {
+		delete(f.conns, conn)
+	}
+}
+
+func (f *PortForwarder) closeConns() {
+	f.connsLock.Lock()
+	defer f.connsLock.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+/*
+ * This file is part of AMS SDK
+ * Copyright 2021 Canonical Ltd.
+ *
+ * This program is free software: you can redistribute it and/or modify it under
+ * the terms of the Lesser GNU General Public License version 3, as published
+ * by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful, but WITHOUT
+ * ANY WARRANTY; without even the implied warranties of MERCHANTABILITY, SATISFACTORY
+ * QUALITY, or FITNESS FOR A PARTICULAR PURPOSE.  See the Lesser GNU General Public
+ * License for more details.
+ *
+ * You should have received a copy of the Lesser GNU General Public License along
+ * with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// testDialer connects to a local listener instead of an instance
+type testDialer struct {
+	addr  string
+	dials atomic.Int32
+}
+
+func (d *testDialer) DialInstance(ctx context.Context, id string, port int) (net.Conn, error) {
+	d.dials.Add(1)
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", d.addr)
+}
+
+// startUpstream starts a server which reads a request until the client
+// stops sending and only then answers it
+func startUpstream(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := io.ReadAll(conn)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(conn, "reply to %s", req)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestPortForwardHalfClose(t *testing.T) {
+	dialer := &testDialer{addr: startUpstream(t)}
+	f, err := PortForward(context.Background(), dialer, "instance0", "", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const numConns = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, numConns)
+	for n := 0; n < numConns; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := net.Dial("tcp", f.Addr().String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer conn.Close()
+
+			req := fmt.Sprintf("request %d", n)
+			if _, err := io.WriteString(conn, req); err != nil {
+				errs <- err
+				return
+			}
+			// Stop sending but keep reading the response
+			if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+				errs <- err
+				return
+			}
+			resp, err := io.ReadAll(conn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(resp) != "reply to "+req {
+				errs <- fmt.Errorf("unexpected response %q to %q", resp, req)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if n := dialer.dials.Load(); n != numConns {
+		t.Fatalf("expected %d streams to the instance, got %d", numConns, n)
+	}
+}
+
+func TestPortForwardClose(t *testing.T) {
+	dialer := &testDialer{addr: startUpstream(t)}
+	f, err := PortForward(context.Background(), dialer, "instance0", "", 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "request"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing the forwarder ends connections still waiting for a response
+	f.Close()
+	io.ReadAll(conn)
+	if _, err := net.Dial("tcp", f.Addr().String()); err == nil {
+		t.Fatal("expected the forwarder to stop accepting connections")
+	}
+}